@@ -0,0 +1,38 @@
+package scard
+
+import (
+	"errors"
+
+	"github.com/ebfe/scard/apdu"
+)
+
+// Do encodes cmd, sends it with Transmit and decodes the response. Like
+// apdu.Client, it fetches the remaining data with GET RESPONSE on 61 XX and
+// sends cmd again with the right Le on 6C XX. Commands with more than 255
+// bytes of data are chained if the ATR announces command chaining but not
+// extended length support. Use an apdu.Client to configure this.
+func (card *Card) Do(cmd apdu.Command) (apdu.Response, error) {
+	c := apdu.Client{Transmitter: card, Capabilities: card.capabilities()}
+	rsp, err := c.Do(cmd)
+	var opErr *OpError
+	if err != nil && !errors.As(err, &opErr) {
+		err = card.opError("Do", err)
+	}
+	return rsp, err
+}
+
+// capabilities returns the card capabilities from the ATR, or nil if they
+// are unknown.
+func (card *Card) capabilities() *apdu.Capabilities {
+	if !card.capsKnown {
+		status, err := card.Status()
+		if err != nil {
+			return nil
+		}
+		if caps, ok := apdu.CapabilitiesFromATR(status.Atr); ok {
+			card.caps = &caps
+		}
+		card.capsKnown = true
+	}
+	return card.caps
+}
@@ -0,0 +1,217 @@
+// Package apdu encodes command APDUs and decodes response APDUs as defined
+// in ISO/IEC 7816-3 and ISO/IEC 7816-4.
+//
+// A Command is encoded in one of the four cases of ISO/IEC 7816-3, depending
+// on whether it has command data and expects response data:
+//
+//	case 1: CLA INS P1 P2
+//	case 2: CLA INS P1 P2 Le
+//	case 3: CLA INS P1 P2 Lc Data
+//	case 4: CLA INS P1 P2 Lc Data Le
+//
+// The short form is used when the data and the expected response fit,
+// otherwise the extended form with 2 or 3 byte Lc and Le fields.
+package apdu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits of the short and extended length encodings.
+const (
+	MaxShortData    = 255
+	MaxShortNe      = 256
+	MaxExtendedData = 65535
+	MaxExtendedNe   = 65536
+)
+
+var (
+	// ErrDataTooLong is returned when encoding a Command with more than
+	// MaxExtendedData bytes of data.
+	ErrDataTooLong = errors.New("apdu: command data too long")
+	// ErrInvalidNe is returned when encoding a Command with Ne out of the
+	// range 0 to MaxExtendedNe.
+	ErrInvalidNe = errors.New("apdu: invalid Ne")
+)
+
+// Command is a command APDU.
+type Command struct {
+	CLA byte
+	INS byte
+	P1  byte
+	P2  byte
+	// Data is the command data, encoded with the Lc field.
+	Data []byte
+	// Ne is the maximum number of response data bytes expected, encoded as
+	// the Le field. Zero means no Le field; MaxShortNe and MaxExtendedNe are
+	// encoded as Le 00 and 00 00 respectively.
+	Ne int
+	// Extended forces the extended length encoding even if the short one
+	// would do.
+	Extended bool
+}
+
+// IsExtended reports whether the command is encoded in extended form.
+func (c Command) IsExtended() bool {
+	return c.Extended || len(c.Data) > MaxShortData || c.Ne > MaxShortNe
+}
+
+// MarshalBinary encodes the command.
+func (c Command) MarshalBinary() ([]byte, error) {
+	if len(c.Data) > MaxExtendedData {
+		return nil, ErrDataTooLong
+	}
+	if c.Ne < 0 || c.Ne > MaxExtendedNe {
+		return nil, ErrInvalidNe
+	}
+
+	b := make([]byte, 4, 4+3+len(c.Data)+3)
+	b[0], b[1], b[2], b[3] = c.CLA, c.INS, c.P1, c.P2
+	ext := c.IsExtended()
+
+	if len(c.Data) > 0 {
+		if ext {
+			b = append(b, 0, byte(len(c.Data)>>8), byte(len(c.Data)))
+		} else {
+			b = append(b, byte(len(c.Data)))
+		}
+		b = append(b, c.Data...)
+	}
+	if c.Ne > 0 {
+		switch {
+		case !ext:
+			// 256 is encoded as 00
+			b = append(b, byte(c.Ne))
+		case len(c.Data) == 0:
+			// 65536 is encoded as 00 00
+			b = append(b, 0, byte(c.Ne>>8), byte(c.Ne))
+		default:
+			b = append(b, byte(c.Ne>>8), byte(c.Ne))
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a command in any of the cases in short or
+// extended form.
+func (c *Command) UnmarshalBinary(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("apdu: command too short (%d bytes)", len(b))
+	}
+	cmd := Command{CLA: b[0], INS: b[1], P1: b[2], P2: b[3]}
+	body := b[4:]
+
+	malformed := func() error {
+		return fmt.Errorf("apdu: malformed command % x", b)
+	}
+
+	switch {
+	case len(body) == 0:
+		// case 1
+	case len(body) == 1:
+		// case 2 short
+		cmd.Ne = shortNe(body[0])
+	case body[0] != 0:
+		// case 3 or 4 short
+		lc := int(body[0])
+		switch len(body) {
+		case 1 + lc:
+		case 2 + lc:
+			cmd.Ne = shortNe(body[1+lc])
+		default:
+			return malformed()
+		}
+		cmd.Data = body[1 : 1+lc]
+	case len(body) == 3:
+		// case 2 extended
+		cmd.Ne = extendedNe(body[1], body[2])
+		cmd.Extended = true
+	case len(body) > 3:
+		// case 3 or 4 extended
+		lc := int(body[1])<<8 | int(body[2])
+		if lc == 0 {
+			return malformed()
+		}
+		switch len(body) {
+		case 3 + lc:
+		case 5 + lc:
+			cmd.Ne = extendedNe(body[3+lc], body[4+lc])
+		default:
+			return malformed()
+		}
+		cmd.Data = body[3 : 3+lc]
+		cmd.Extended = true
+	default:
+		return malformed()
+	}
+
+	if cmd.Data != nil {
+		cmd.Data = append([]byte(nil), cmd.Data...)
+	}
+	*c = cmd
+	return nil
+}
+
+func shortNe(le byte) int {
+	if le == 0 {
+		return MaxShortNe
+	}
+	return int(le)
+}
+
+func extendedNe(hi, lo byte) int {
+	if le := int(hi)<<8 | int(lo); le != 0 {
+		return le
+	}
+	return MaxExtendedNe
+}
+
+func (c Command) String() string {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return fmt.Sprintf("apdu.Command{%02x %02x %02x %02x, %d bytes, Ne %d}", c.CLA, c.INS, c.P1, c.P2, len(c.Data), c.Ne)
+	}
+	return fmt.Sprintf("% x", b)
+}
+
+// Response is a response APDU.
+type Response struct {
+	Data []byte
+	SW1  byte
+	SW2  byte
+}
+
+// ParseResponse decodes a response APDU, which has at least the two status
+// bytes. Data refers to b.
+func ParseResponse(b []byte) (Response, error) {
+	if len(b) < 2 {
+		return Response{}, fmt.Errorf("apdu: response too short (%d bytes)", len(b))
+	}
+	n := len(b) - 2
+	return Response{Data: b[:n:n], SW1: b[n], SW2: b[n+1]}, nil
+}
+
+// SW returns the status word SW1-SW2.
+func (r Response) SW() uint16 {
+	return uint16(r.SW1)<<8 | uint16(r.SW2)
+}
+
+// IsSuccess reports whether the status word is 90 00.
+func (r Response) IsSuccess() bool {
+	return r.SW1 == 0x90 && r.SW2 == 0x00
+}
+
+// MarshalBinary encodes the response.
+func (r Response) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(r.Data)+2)
+	b = append(b, r.Data...)
+	return append(b, r.SW1, r.SW2), nil
+}
+
+func (r Response) String() string {
+	if len(r.Data) == 0 {
+		return fmt.Sprintf("%02x %02x", r.SW1, r.SW2)
+	}
+	return fmt.Sprintf("% x %02x %02x", r.Data, r.SW1, r.SW2)
+}
@@ -0,0 +1,120 @@
+package apdu
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommandEncoding(t *testing.T) {
+	data255 := bytes.Repeat([]byte{0xaa}, 255)
+	data256 := bytes.Repeat([]byte{0xbb}, 256)
+
+	tests := []struct {
+		cmd  Command
+		apdu []byte
+	}{
+		// case 1
+		{Command{CLA: 0x00, INS: 0xa4, P1: 0x00, P2: 0x0c}, []byte{0x00, 0xa4, 0x00, 0x0c}},
+		// case 2 short
+		{Command{INS: 0xb0, Ne: 1}, []byte{0, 0xb0, 0, 0, 0x01}},
+		{Command{INS: 0xb0, Ne: 256}, []byte{0, 0xb0, 0, 0, 0x00}},
+		// case 3 short
+		{Command{INS: 0xa4, P2: 0x0c, Data: []byte{0x3f, 0x00}}, []byte{0, 0xa4, 0, 0x0c, 0x02, 0x3f, 0x00}},
+		{Command{INS: 0xd6, Data: data255}, append([]byte{0, 0xd6, 0, 0, 0xff}, data255...)},
+		// case 4 short
+		{Command{INS: 0xa4, P1: 0x04, Data: []byte{0xa0, 0x00}, Ne: 256}, []byte{0, 0xa4, 0x04, 0, 0x02, 0xa0, 0x00, 0x00}},
+		// case 2 extended
+		{Command{INS: 0xb0, Ne: 257}, []byte{0, 0xb0, 0, 0, 0x00, 0x01, 0x01}},
+		{Command{INS: 0xb0, Ne: 65536}, []byte{0, 0xb0, 0, 0, 0x00, 0x00, 0x00}},
+		{Command{INS: 0xb0, Ne: 16, Extended: true}, []byte{0, 0xb0, 0, 0, 0x00, 0x00, 0x10}},
+		// case 3 extended
+		{Command{INS: 0xd6, Data: data256}, append([]byte{0, 0xd6, 0, 0, 0x00, 0x01, 0x00}, data256...)},
+		{Command{INS: 0xd6, Data: []byte{7}, Extended: true}, []byte{0, 0xd6, 0, 0, 0x00, 0x00, 0x01, 7}},
+		// case 4 extended
+		{Command{INS: 0x2a, Data: data256, Ne: 65536}, append(append([]byte{0, 0x2a, 0, 0, 0x00, 0x01, 0x00}, data256...), 0x00, 0x00)},
+		{Command{INS: 0x2a, Data: []byte{1, 2}, Ne: 257}, []byte{0, 0x2a, 0, 0, 0x00, 0x00, 0x02, 1, 2, 0x01, 0x01}},
+	}
+
+	for _, tt := range tests {
+		b, err := tt.cmd.MarshalBinary()
+		if err != nil {
+			t.Errorf("%v: MarshalBinary() = %v", tt.cmd, err)
+			continue
+		}
+		if !bytes.Equal(b, tt.apdu) {
+			t.Errorf("MarshalBinary() = % x; want % x", b, tt.apdu)
+		}
+
+		var cmd Command
+		if err := cmd.UnmarshalBinary(tt.apdu); err != nil {
+			t.Errorf("UnmarshalBinary(% x) = %v", tt.apdu, err)
+			continue
+		}
+		if cmd.CLA != tt.cmd.CLA || cmd.INS != tt.cmd.INS || cmd.P1 != tt.cmd.P1 || cmd.P2 != tt.cmd.P2 ||
+			!bytes.Equal(cmd.Data, tt.cmd.Data) || cmd.Ne != tt.cmd.Ne || cmd.IsExtended() != tt.cmd.IsExtended() {
+			t.Errorf("UnmarshalBinary(% x) = %+v; want %+v", tt.apdu, cmd, tt.cmd)
+		}
+	}
+}
+
+func TestCommandEncodingErrors(t *testing.T) {
+	tests := []struct {
+		cmd Command
+		err error
+	}{
+		{Command{Data: make([]byte, 65536)}, ErrDataTooLong},
+		{Command{Ne: 65537}, ErrInvalidNe},
+		{Command{Ne: -1}, ErrInvalidNe},
+	}
+	for _, tt := range tests {
+		if _, err := tt.cmd.MarshalBinary(); err != tt.err {
+			t.Errorf("MarshalBinary(%d bytes, Ne %d) = %v; want %v", len(tt.cmd.Data), tt.cmd.Ne, err, tt.err)
+		}
+	}
+}
+
+func TestCommandMalformed(t *testing.T) {
+	tests := [][]byte{
+		{0, 0xb0, 0},
+		{0, 0xd6, 0, 0, 0x03, 1, 2},
+		{0, 0xd6, 0, 0, 0x02, 1, 2, 0, 0},
+		{0, 0xd6, 0, 0, 0x00, 0x00},
+		{0, 0xd6, 0, 0, 0x00, 0x00, 0x00, 0x00},
+		{0, 0xd6, 0, 0, 0x00, 0x00, 0x02, 1},
+	}
+	for _, apdu := range tests {
+		var cmd Command
+		if err := cmd.UnmarshalBinary(apdu); err == nil {
+			t.Errorf("UnmarshalBinary(% x) = %+v; want error", apdu, cmd)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	rsp, err := ParseResponse([]byte{0x01, 0x02, 0x90, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp.Data, []byte{1, 2}) || rsp.SW() != 0x9000 || !rsp.IsSuccess() {
+		t.Errorf("ParseResponse() = %v", rsp)
+	}
+	b, _ := rsp.MarshalBinary()
+	if !bytes.Equal(b, []byte{0x01, 0x02, 0x90, 0x00}) {
+		t.Errorf("MarshalBinary() = % x", b)
+	}
+
+	rsp, err = ParseResponse([]byte{0x6a, 0x82})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Data) != 0 || rsp.SW1 != 0x6a || rsp.SW2 != 0x82 || rsp.IsSuccess() {
+		t.Errorf("ParseResponse() = %v", rsp)
+	}
+	if s := rsp.String(); s != "6a 82" {
+		t.Errorf("String() = %q", s)
+	}
+
+	if _, err := ParseResponse([]byte{0x90}); err == nil {
+		t.Errorf("ParseResponse(90) succeeded")
+	}
+}
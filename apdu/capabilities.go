@@ -0,0 +1,80 @@
+package apdu
+
+// Capabilities are the card capabilities announced in the historical bytes
+// of the ATR (ISO/IEC 7816-4, compact-TLV tag 7).
+type Capabilities struct {
+	// CommandChaining reports support for command chaining.
+	CommandChaining bool
+	// ExtendedLength reports support for extended Lc and Le fields.
+	ExtendedLength bool
+}
+
+// CapabilitiesFromATR returns the card capabilities in atr. It reports false
+// if the historical bytes cannot be found or do not contain the third byte
+// of the card capabilities.
+func CapabilitiesFromATR(atr []byte) (Capabilities, bool) {
+	hist, ok := historicalBytes(atr)
+	if !ok || len(hist) == 0 {
+		return Capabilities{}, false
+	}
+
+	var objects []byte
+	switch hist[0] {
+	case 0x00:
+		// the last 3 bytes are the status indicator
+		if len(hist) < 4 {
+			return Capabilities{}, false
+		}
+		objects = hist[1 : len(hist)-3]
+	case 0x80:
+		objects = hist[1:]
+	default:
+		return Capabilities{}, false
+	}
+
+	for len(objects) > 0 {
+		tag, n := objects[0]>>4, int(objects[0]&0x0f)
+		if 1+n > len(objects) {
+			break
+		}
+		if tag == 0x7 && n >= 3 {
+			b := objects[3]
+			return Capabilities{
+				CommandChaining: b&0x80 != 0,
+				ExtendedLength:  b&0x40 != 0,
+			}, true
+		}
+		objects = objects[1+n:]
+	}
+	return Capabilities{}, false
+}
+
+// historicalBytes returns the historical bytes of atr, skipping TS, T0 and
+// the interface bytes.
+func historicalBytes(atr []byte) ([]byte, bool) {
+	if len(atr) < 2 {
+		return nil, false
+	}
+	k := int(atr[1] & 0x0f)
+	y := atr[1] >> 4
+	i := 2
+	for {
+		// TAi, TBi, TCi, TDi
+		for bit := byte(0x1); bit <= 0x8; bit <<= 1 {
+			if y&bit != 0 {
+				i++
+			}
+		}
+		if y&0x8 == 0 {
+			break
+		}
+		if i > len(atr) {
+			return nil, false
+		}
+		y = atr[i-1] >> 4
+	}
+	if i+k > len(atr) {
+		return nil, false
+	}
+	return atr[i : i+k], true
+}
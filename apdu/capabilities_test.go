@@ -0,0 +1,34 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/ebfe/scard/sim"
+)
+
+func TestCapabilitiesFromATR(t *testing.T) {
+	tests := []struct {
+		atr  []byte
+		caps Capabilities
+		ok   bool
+	}{
+		{sim.DefaultATR, Capabilities{CommandChaining: true, ExtendedLength: true}, true},
+		// TA1, TC1, TD1, TD2, TA3, TB3; category 80, card capabilities 73 00 00 80
+		{[]byte{0x3b, 0xd5, 0x18, 0xff, 0x81, 0x31, 0xfe, 0x45, 0x80, 0x73, 0x00, 0x00, 0x80, 0x00}, Capabilities{CommandChaining: true}, true},
+		// no historical bytes
+		{[]byte{0x3b, 0x80, 0x80, 0x01, 0x01}, Capabilities{}, false},
+		// no card capabilities
+		{[]byte{0x3b, 0x84, 0x80, 0x01, 0x80, 0x31, 0xc0, 0x64, 0x00}, Capabilities{}, false},
+		// truncated card capabilities
+		{[]byte{0x3b, 0x84, 0x80, 0x01, 0x80, 0x73, 0x00, 0x00}, Capabilities{}, false},
+		// truncated interface bytes
+		{[]byte{0x3b, 0xf0, 0x11}, Capabilities{}, false},
+		{[]byte{0x3b}, Capabilities{}, false},
+	}
+	for _, tt := range tests {
+		caps, ok := CapabilitiesFromATR(tt.atr)
+		if caps != tt.caps || ok != tt.ok {
+			t.Errorf("CapabilitiesFromATR(% x) = %+v, %v; want %+v, %v", tt.atr, caps, ok, tt.caps, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package apdu
+
+import (
+	"errors"
+)
+
+// DefaultMaxIterations is the number of additional commands a Client sends
+// for a single command if MaxIterations is zero, enough to fetch 64 KiB with
+// GET RESPONSE.
+const DefaultMaxIterations = 256
+
+// ErrTooManyIterations is returned by Client.Do when the card asks for more
+// than MaxIterations additional commands.
+var ErrTooManyIterations = errors.New("apdu: too many GET RESPONSE or Le retry iterations")
+
+// ChainMode selects when Client sends a command with command chaining.
+type ChainMode int
+
+const (
+	// ChainAuto chains commands with more than MaxShortData bytes of data if
+	// the Client's Capabilities report command chaining but no extended
+	// length support.
+	ChainAuto ChainMode = iota
+	// ChainNever never chains commands; long data is sent with extended
+	// length.
+	ChainNever
+	// ChainAlways chains all commands with more data than fits in a
+	// segment.
+	ChainAlways
+)
+
+// Transmitter sends a command APDU and returns the response APDU. It is
+// implemented by scard.Card and scard.ResilientCard.
+type Transmitter interface {
+	Transmit(cmd []byte) ([]byte, error)
+}
+
+// Client sends commands over a Transmitter and handles the status words by
+// which a card asks for further commands, as is common with T=0:
+//
+//   - 61 XX: XX more bytes are available and are fetched with GET RESPONSE;
+//     the response data is concatenated.
+//   - 6C XX: Le was wrong; the command is sent again with Le XX.
+//
+// The Response returned by Do always has the final status word.
+//
+// Commands with long data can be sent with command chaining (ISO/IEC
+// 7816-4, 5.3.3) instead of extended length: the data is split into
+// segments that are sent with bit 0x10 set in CLA except for the last one.
+// A status word other than 90 00 for a segment ends the chain and is
+// returned.
+type Client struct {
+	Transmitter Transmitter
+
+	// Chaining selects when commands are chained by Do. DoChained always
+	// chains.
+	Chaining ChainMode
+	// Capabilities are the capabilities of the card used by ChainAuto, e.g.
+	// from CapabilitiesFromATR. If nil, ChainAuto never chains.
+	Capabilities *Capabilities
+	// MaxSegment is the maximum number of data bytes per chained command.
+	// If zero or larger than MaxShortData, MaxShortData is used.
+	MaxSegment int
+
+	// NoGetResponse disables GET RESPONSE on 61 XX.
+	NoGetResponse bool
+	// NoLeRetry disables sending the command again on 6C XX.
+	NoLeRetry bool
+	// MaxIterations is the maximum number of additional commands sent for
+	// a single command. If zero, DefaultMaxIterations is used.
+	MaxIterations int
+}
+
+// Do sends cmd, chained as selected by Chaining, and returns the complete
+// response.
+func (c *Client) Do(cmd Command) (Response, error) {
+	if c.chain(cmd) {
+		return c.DoChained(cmd)
+	}
+	return c.exchange(cmd)
+}
+
+// DoChained is like Do but sends cmd with command chaining if its data does
+// not fit in a single segment.
+func (c *Client) DoChained(cmd Command) (Response, error) {
+	size := c.segmentSize()
+	data := cmd.Data
+	for len(data) > size {
+		seg := cmd
+		seg.CLA |= 0x10
+		seg.Data = data[:size]
+		seg.Ne = 0
+		rsp, err := c.transmit(seg)
+		if err != nil {
+			return Response{}, err
+		}
+		if !rsp.IsSuccess() {
+			return rsp, nil
+		}
+		data = data[size:]
+	}
+	cmd.Data = data
+	return c.exchange(cmd)
+}
+
+func (c *Client) chain(cmd Command) bool {
+	switch c.Chaining {
+	case ChainNever:
+		return false
+	case ChainAlways:
+		return len(cmd.Data) > c.segmentSize()
+	}
+	caps := c.Capabilities
+	return len(cmd.Data) > MaxShortData && caps != nil && caps.CommandChaining && !caps.ExtendedLength
+}
+
+func (c *Client) segmentSize() int {
+	if c.MaxSegment > 0 && c.MaxSegment < MaxShortData {
+		return c.MaxSegment
+	}
+	return MaxShortData
+}
+
+// exchange sends cmd and handles 61 XX and 6C XX.
+func (c *Client) exchange(cmd Command) (Response, error) {
+	max := c.MaxIterations
+	if max == 0 {
+		max = DefaultMaxIterations
+	}
+
+	var data []byte
+	for i := 0; ; i++ {
+		rsp, err := c.transmit(cmd)
+		if err != nil {
+			return Response{}, err
+		}
+
+		switch {
+		case rsp.SW1 == 0x6c && !c.NoLeRetry:
+			cmd.Ne = shortNe(rsp.SW2)
+		case rsp.SW1 == 0x61 && !c.NoGetResponse:
+			data = append(data, rsp.Data...)
+			cmd = Command{CLA: getResponseCLA(cmd.CLA), INS: 0xc0, Ne: shortNe(rsp.SW2)}
+		default:
+			if data != nil {
+				rsp.Data = append(data, rsp.Data...)
+			}
+			return rsp, nil
+		}
+
+		if i == max {
+			return Response{}, ErrTooManyIterations
+		}
+	}
+}
+
+func (c *Client) transmit(cmd Command) (Response, error) {
+	b, err := cmd.MarshalBinary()
+	if err != nil {
+		return Response{}, err
+	}
+	rsp, err := c.Transmitter.Transmit(b)
+	if err != nil {
+		return Response{}, err
+	}
+	return ParseResponse(rsp)
+}
+
+// getResponseCLA returns the class byte of a GET RESPONSE following a
+// command with class cla: the logical channel is kept, secure messaging and
+// chaining are cleared. Proprietary classes are kept as they are, since
+// their coding is not defined by ISO/IEC 7816-4.
+func getResponseCLA(cla byte) byte {
+	switch {
+	case cla == 0xff:
+		// invalid class
+		return 0x00
+	case cla&0x80 != 0:
+		return cla
+	case cla&0x40 != 0:
+		// further interindustry class, channels 4 to 19
+		return cla & 0x4f
+	default:
+		return cla & 0x03
+	}
+}
@@ -0,0 +1,245 @@
+package apdu
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ebfe/scard/sim"
+)
+
+func newTestCard() *sim.Card {
+	c := sim.New(nil)
+	c.MF().AddEF(0x0101, []byte("hello, world"))
+	c.MF().AddRecordEF(0x0102, 0, []byte("hello, world"))
+	return c
+}
+
+func TestClientGetResponse(t *testing.T) {
+	card := newTestCard()
+	selectFCP := Command{INS: 0xa4, P2: 0x04, Data: []byte{0x01, 0x01}}
+
+	rsp, err := (&Client{Transmitter: card, NoGetResponse: true}).Do(selectFCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.SW1 != 0x61 || len(rsp.Data) != 0 {
+		t.Fatalf("Do() without GET RESPONSE = %v; want 61 XX", rsp)
+	}
+	n := int(rsp.SW2)
+
+	rsp, err = (&Client{Transmitter: card}).Do(selectFCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.IsSuccess() || len(rsp.Data) != n || rsp.Data[0] != 0x62 {
+		t.Fatalf("Do() = %v; want %d bytes FCP template and 90 00", rsp, n)
+	}
+}
+
+func TestClientLeRetry(t *testing.T) {
+	card := newTestCard()
+	if _, err := (&Client{Transmitter: card}).Do(Command{INS: 0xa4, P2: 0x0c, Data: []byte{0x01, 0x02}}); err != nil {
+		t.Fatal(err)
+	}
+	readRecord := Command{INS: 0xb2, P1: 0x01, P2: 0x04, Ne: 100}
+
+	rsp, err := (&Client{Transmitter: card, NoLeRetry: true}).Do(readRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.SW() != 0x6c0c {
+		t.Fatalf("Do() without Le retry = %v; want 6c 0c", rsp)
+	}
+
+	rsp, err = (&Client{Transmitter: card}).Do(readRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.IsSuccess() || string(rsp.Data) != "hello, world" {
+		t.Fatalf("Do() = %v", rsp)
+	}
+}
+
+// transmitFunc implements Transmitter.
+type transmitFunc func(cmd []byte) ([]byte, error)
+
+func (f transmitFunc) Transmit(cmd []byte) ([]byte, error) {
+	return f(cmd)
+}
+
+func TestClientConcatenation(t *testing.T) {
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	pending := data
+	var sent [][]byte
+	c := &Client{Transmitter: transmitFunc(func(cmd []byte) ([]byte, error) {
+		sent = append(sent, cmd)
+		n := 100
+		if cmd[1] == 0xc0 {
+			n = shortNe(cmd[4])
+		}
+		if n > len(pending) {
+			n = len(pending)
+		}
+		rsp := append([]byte(nil), pending[:n]...)
+		pending = pending[n:]
+		if len(pending) == 0 {
+			return append(rsp, 0x90, 0x00), nil
+		}
+		return append(rsp, 0x61, remaining(len(pending))), nil
+	})}
+
+	rsp, err := c.Do(Command{CLA: 0x0d, INS: 0xca, P1: 0x01, P2: 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.IsSuccess() || !bytes.Equal(rsp.Data, data) {
+		t.Fatalf("Do() = %d bytes, %02x %02x", len(rsp.Data), rsp.SW1, rsp.SW2)
+	}
+	want := [][]byte{
+		{0x0d, 0xca, 0x01, 0x02},
+		{0x01, 0xc0, 0x00, 0x00, 0x00},
+		{0x01, 0xc0, 0x00, 0x00, 0xf4},
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %d commands; want %d", len(sent), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(sent[i], want[i]) {
+			t.Errorf("command %d = % x; want % x", i, sent[i], want[i])
+		}
+	}
+}
+
+func remaining(n int) byte {
+	if n > 255 {
+		return 0
+	}
+	return byte(n)
+}
+
+func TestClientMaxIterations(t *testing.T) {
+	calls := 0
+	c := &Client{
+		Transmitter: transmitFunc(func(cmd []byte) ([]byte, error) {
+			calls++
+			return []byte{0x61, 0x10}, nil
+		}),
+		MaxIterations: 3,
+	}
+	if _, err := c.Do(Command{INS: 0xca}); !errors.Is(err, ErrTooManyIterations) {
+		t.Fatalf("Do() = %v; want %v", err, ErrTooManyIterations)
+	}
+	if calls != 4 {
+		t.Errorf("%d commands sent; want 4", calls)
+	}
+}
+
+func TestGetResponseCLA(t *testing.T) {
+	tests := []struct{ cla, want byte }{
+		{0x00, 0x00},
+		{0x03, 0x03},
+		{0x0c, 0x00},
+		{0x11, 0x01},
+		{0x4f, 0x4f},
+		{0x7f, 0x4f},
+		{0x80, 0x80},
+		{0x84, 0x84},
+		{0xa0, 0xa0},
+		{0xff, 0x00},
+	}
+	for _, tt := range tests {
+		if got := getResponseCLA(tt.cla); got != tt.want {
+			t.Errorf("getResponseCLA(%02x) = %02x; want %02x", tt.cla, got, tt.want)
+		}
+	}
+}
+
+func TestClientChaining(t *testing.T) {
+	card := sim.New(nil)
+	card.MF().AddEF(0x0101, make([]byte, 600))
+	c := &Client{Transmitter: card}
+	if _, err := c.Do(Command{INS: 0xa4, P2: 0x0c, Data: []byte{0x01, 0x01}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	rsp, err := c.DoChained(Command{INS: 0xd6, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.IsSuccess() {
+		t.Fatalf("DoChained(UPDATE BINARY) = %v", rsp)
+	}
+
+	rsp, err = c.Do(Command{INS: 0xb0, Ne: MaxExtendedNe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.IsSuccess() || !bytes.Equal(rsp.Data, data) {
+		t.Fatalf("READ BINARY = %d bytes, %02x %02x", len(rsp.Data), rsp.SW1, rsp.SW2)
+	}
+}
+
+func TestClientChainMode(t *testing.T) {
+	var sent []Command
+	sw := []byte{0x90, 0x00}
+	tr := transmitFunc(func(b []byte) ([]byte, error) {
+		var cmd Command
+		if err := cmd.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		sent = append(sent, cmd)
+		return sw, nil
+	})
+
+	cmd := Command{CLA: 0x01, INS: 0x2a, P1: 0x80, P2: 0x86, Data: make([]byte, 300), Ne: 256}
+	tests := []struct {
+		c    Client
+		segs []int
+	}{
+		{Client{}, []int{300}},
+		{Client{Capabilities: &Capabilities{CommandChaining: true}}, []int{255, 45}},
+		{Client{Capabilities: &Capabilities{CommandChaining: true, ExtendedLength: true}}, []int{300}},
+		{Client{Chaining: ChainNever, Capabilities: &Capabilities{CommandChaining: true}}, []int{300}},
+		{Client{Chaining: ChainAlways, MaxSegment: 128}, []int{128, 128, 44}},
+	}
+	for i, tt := range tests {
+		sent = nil
+		tt.c.Transmitter = tr
+		if _, err := tt.c.Do(cmd); err != nil {
+			t.Fatal(err)
+		}
+		if len(sent) != len(tt.segs) {
+			t.Errorf("%d: sent %d commands; want %d", i, len(sent), len(tt.segs))
+			continue
+		}
+		for j, seg := range sent {
+			last := j == len(sent)-1
+			wantCLA, wantNe := byte(0x11), 0
+			if last {
+				wantCLA, wantNe = 0x01, 256
+			}
+			if len(seg.Data) != tt.segs[j] || seg.CLA != wantCLA || seg.Ne != wantNe || seg.INS != cmd.INS || seg.P1 != cmd.P1 || seg.P2 != cmd.P2 {
+				t.Errorf("%d: segment %d = %02x %02x %02x %02x, %d bytes, Ne %d", i, j, seg.CLA, seg.INS, seg.P1, seg.P2, len(seg.Data), seg.Ne)
+			}
+		}
+	}
+
+	// an error ends the chain
+	sent = nil
+	sw = []byte{0x6a, 0x80}
+	rsp, err := (&Client{Transmitter: tr}).DoChained(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.SW() != 0x6a80 || len(sent) != 1 {
+		t.Errorf("DoChained() = %v after %d commands; want 6a 80 after 1", rsp, len(sent))
+	}
+}
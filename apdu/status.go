@@ -0,0 +1,162 @@
+package apdu
+
+import (
+	"fmt"
+)
+
+// StatusError is a status word other than 90 00, as returned by
+// Response.Err.
+//
+// StatusError values are comparable, so errors.Is matches the ErrXxx values
+// below. ErrVerificationFailed matches 63 CX with any counter; use Retries
+// to get the counter. Use errors.As to get the status word of any
+// StatusError.
+type StatusError struct {
+	SW1 byte
+	SW2 byte
+}
+
+// Status words of ISO/IEC 7816-4 as errors.
+var (
+	ErrEndOfFile                  = StatusError{0x62, 0x82}
+	ErrVerificationFailed         = StatusError{0x63, 0xc0}
+	ErrExecution                  = StatusError{0x64, 0x00}
+	ErrMemoryFailure              = StatusError{0x65, 0x81}
+	ErrWrongLength                = StatusError{0x67, 0x00}
+	ErrLogicalChannelNotSupported = StatusError{0x68, 0x81}
+	ErrSecureMessagingUnsupported = StatusError{0x68, 0x82}
+	ErrLastCommandExpected        = StatusError{0x68, 0x83}
+	ErrChainingNotSupported       = StatusError{0x68, 0x84}
+	ErrIncompatibleFile           = StatusError{0x69, 0x81}
+	ErrSecurityStatusNotSatisfied = StatusError{0x69, 0x82}
+	ErrAuthMethodBlocked          = StatusError{0x69, 0x83}
+	ErrReferenceDataNotUsable     = StatusError{0x69, 0x84}
+	ErrConditionsNotSatisfied     = StatusError{0x69, 0x85}
+	ErrNoCurrentEF                = StatusError{0x69, 0x86}
+	ErrSMObjectsMissing           = StatusError{0x69, 0x87}
+	ErrSMObjectsIncorrect         = StatusError{0x69, 0x88}
+	ErrIncorrectData              = StatusError{0x6a, 0x80}
+	ErrFunctionNotSupported       = StatusError{0x6a, 0x81}
+	ErrFileNotFound               = StatusError{0x6a, 0x82}
+	ErrRecordNotFound             = StatusError{0x6a, 0x83}
+	ErrNotEnoughMemory            = StatusError{0x6a, 0x84}
+	ErrIncorrectP1P2              = StatusError{0x6a, 0x86}
+	ErrReferenceDataNotFound      = StatusError{0x6a, 0x88}
+	ErrFileExists                 = StatusError{0x6a, 0x89}
+	ErrDFNameExists               = StatusError{0x6a, 0x8a}
+	ErrWrongP1P2                  = StatusError{0x6b, 0x00}
+	ErrINSNotSupported            = StatusError{0x6d, 0x00}
+	ErrCLANotSupported            = StatusError{0x6e, 0x00}
+	ErrNoPreciseDiagnosis         = StatusError{0x6f, 0x00}
+)
+
+var statusTexts = map[uint16]string{
+	0x6200: "warning, no information given",
+	0x6281: "part of returned data may be corrupted",
+	0x6282: "end of file or record reached before reading Ne bytes",
+	0x6283: "selected file deactivated",
+	0x6284: "file control information not formatted",
+	0x6285: "selected file in termination state",
+	0x6286: "no input data available from a sensor",
+	0x6300: "verification failed",
+	0x6381: "file filled up by the last write",
+	0x6400: "execution error",
+	0x6401: "immediate response required by the card",
+	0x6581: "memory failure",
+	0x6700: "wrong length",
+	0x6800: "functions in CLA not supported",
+	0x6881: "logical channel not supported",
+	0x6882: "secure messaging not supported",
+	0x6883: "last command of the chain expected",
+	0x6884: "command chaining not supported",
+	0x6900: "command not allowed",
+	0x6981: "command incompatible with file structure",
+	0x6982: "security status not satisfied",
+	0x6983: "authentication method blocked",
+	0x6984: "reference data not usable",
+	0x6985: "conditions of use not satisfied",
+	0x6986: "command not allowed (no current EF)",
+	0x6987: "expected secure messaging data objects missing",
+	0x6988: "incorrect secure messaging data objects",
+	0x6a00: "wrong parameters P1-P2",
+	0x6a80: "incorrect parameters in the command data field",
+	0x6a81: "function not supported",
+	0x6a82: "file or application not found",
+	0x6a83: "record not found",
+	0x6a84: "not enough memory space in the file",
+	0x6a85: "Nc inconsistent with TLV structure",
+	0x6a86: "incorrect parameters P1-P2",
+	0x6a87: "Nc inconsistent with parameters P1-P2",
+	0x6a88: "referenced data or reference data not found",
+	0x6a89: "file already exists",
+	0x6a8a: "DF name already exists",
+	0x6b00: "wrong parameters P1-P2",
+	0x6d00: "instruction code not supported or invalid",
+	0x6e00: "class not supported",
+	0x6f00: "no precise diagnosis",
+}
+
+// classTexts describe the status words by SW1 if there is no entry in
+// statusTexts.
+var classTexts = map[byte]string{
+	0x61: "more data available",
+	0x62: "warning, state of non-volatile memory unchanged",
+	0x63: "warning, state of non-volatile memory changed",
+	0x64: "execution error, state of non-volatile memory unchanged",
+	0x65: "execution error, state of non-volatile memory changed",
+	0x66: "security-related error",
+	0x67: "wrong length",
+	0x68: "functions in CLA not supported",
+	0x69: "command not allowed",
+	0x6a: "wrong parameters P1-P2",
+	0x6c: "wrong Le field",
+}
+
+// SW returns the status word SW1-SW2.
+func (e StatusError) SW() uint16 {
+	return uint16(e.SW1)<<8 | uint16(e.SW2)
+}
+
+// Retries returns the counter X of 63 CX, usually the number of further
+// allowed retries after a failed verification.
+func (e StatusError) Retries() (n int, ok bool) {
+	if e.SW1 != 0x63 || e.SW2&0xf0 != 0xc0 {
+		return 0, false
+	}
+	return int(e.SW2 & 0x0f), true
+}
+
+func (e StatusError) Error() string {
+	if n, ok := e.Retries(); ok {
+		return fmt.Sprintf("apdu: %02X %02X: verification failed, %d retries left", e.SW1, e.SW2, n)
+	}
+	desc, ok := statusTexts[e.SW()]
+	if !ok {
+		desc, ok = classTexts[e.SW1]
+	}
+	if !ok {
+		desc = "unknown status"
+	}
+	return fmt.Sprintf("apdu: %02X %02X: %s", e.SW1, e.SW2, desc)
+}
+
+// Is reports whether e is target, or both are 63 CX.
+func (e StatusError) Is(target error) bool {
+	t, ok := target.(StatusError)
+	if !ok {
+		return false
+	}
+	if _, ok := e.Retries(); ok {
+		_, ok = t.Retries()
+		return ok
+	}
+	return e == t
+}
+
+// Err returns the status word as a StatusError, or nil if it is 90 00.
+func (r Response) Err() error {
+	if r.IsSuccess() {
+		return nil
+	}
+	return StatusError{r.SW1, r.SW2}
+}
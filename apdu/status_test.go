@@ -0,0 +1,55 @@
+package apdu
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		rsp  Response
+		is   error
+		text string
+	}{
+		{Response{SW1: 0x69, SW2: 0x82}, ErrSecurityStatusNotSatisfied, "apdu: 69 82: security status not satisfied"},
+		{Response{SW1: 0x6a, SW2: 0x82}, ErrFileNotFound, "apdu: 6A 82: file or application not found"},
+		{Response{SW1: 0x69, SW2: 0x83}, ErrAuthMethodBlocked, "apdu: 69 83: authentication method blocked"},
+		{Response{SW1: 0x63, SW2: 0xc2}, ErrVerificationFailed, "apdu: 63 C2: verification failed, 2 retries left"},
+		{Response{SW1: 0x63, SW2: 0xc0}, ErrVerificationFailed, "apdu: 63 C0: verification failed, 0 retries left"},
+		{Response{SW1: 0x6a, SW2: 0x8f}, nil, "apdu: 6A 8F: wrong parameters P1-P2"},
+		{Response{SW1: 0x93, SW2: 0x01}, nil, "apdu: 93 01: unknown status"},
+	}
+	for _, tt := range tests {
+		err := tt.rsp.Err()
+		if err == nil {
+			t.Fatalf("%v: Err() = nil", tt.rsp)
+		}
+		if tt.is != nil && !errors.Is(err, tt.is) {
+			t.Errorf("errors.Is(%v, %v) = false", err, tt.is)
+		}
+		if s := err.Error(); s != tt.text {
+			t.Errorf("Error() = %q; want %q", s, tt.text)
+		}
+		var se StatusError
+		if !errors.As(fmt.Errorf("wrapped: %w", err), &se) || se.SW() != tt.rsp.SW() {
+			t.Errorf("errors.As(%v) = %v", err, se)
+		}
+	}
+
+	if err := (Response{SW1: 0x90, SW2: 0x00}).Err(); err != nil {
+		t.Errorf("Err() = %v; want nil", err)
+	}
+	if errors.Is(ErrFileNotFound, ErrRecordNotFound) || errors.Is(StatusError{0x63, 0x00}, ErrVerificationFailed) {
+		t.Errorf("errors.Is matched different status words")
+	}
+}
+
+func TestStatusErrorRetries(t *testing.T) {
+	if n, ok := (StatusError{0x63, 0xc3}).Retries(); !ok || n != 3 {
+		t.Errorf("Retries() = %d, %v; want 3, true", n, ok)
+	}
+	if _, ok := ErrSecurityStatusNotSatisfied.Retries(); ok {
+		t.Errorf("Retries() of 69 82 ok")
+	}
+}
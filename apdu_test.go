@@ -0,0 +1,67 @@
+package scard
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ebfe/scard/apdu"
+)
+
+func TestDo(t *testing.T) {
+	fake := newFakeBackend()
+	var sent []byte
+	fake.transmit = func(cmd []byte) ([]byte, error) {
+		sent = cmd
+		switch cmd[1] {
+		case 0xee:
+			return []byte{0x90}, nil
+		case 0xca:
+			return []byte{0x01, 0x02, 0x61, 0x02}, nil
+		case 0xc0:
+			return []byte{0x03, 0x04, 0x90, 0x00}, nil
+		}
+		return []byte{0x01, 0x02, 0x90, 0x00}, nil
+	}
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+	card, err := ctx.Connect(fake.reader, ShareShared, ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := card.Do(apdu.Command{INS: 0xb0, Ne: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, []byte{0x00, 0xb0, 0x00, 0x00, 0x02}) {
+		t.Errorf("sent % x", sent)
+	}
+	if !bytes.Equal(rsp.Data, []byte{1, 2}) || rsp.SW() != 0x9000 {
+		t.Errorf("Do() = %v", rsp)
+	}
+
+	rsp, err = card.Do(apdu.Command{INS: 0xca, Ne: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp.Data, []byte{1, 2, 3, 4}) || rsp.SW() != 0x9000 {
+		t.Errorf("Do() with GET RESPONSE = %v", rsp)
+	}
+
+	sent = nil
+	if _, err := card.Do(apdu.Command{INS: 0xb0, Ne: 65537}); !errors.Is(err, apdu.ErrInvalidNe) {
+		t.Errorf("Do(Ne 65537) = %v; want %v", err, apdu.ErrInvalidNe)
+	}
+	if sent != nil {
+		t.Errorf("invalid command was sent")
+	}
+
+	if _, err := card.Do(apdu.Command{INS: 0xee}); err == nil {
+		t.Errorf("Do() with 1 byte response succeeded")
+	}
+}
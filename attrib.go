@@ -0,0 +1,362 @@
+package scard
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// ChannelType is the type of the channel a reader is attached by, as
+// reported in the upper half of AttrChannelId.
+type ChannelType uint16
+
+const (
+	ChannelSerial   ChannelType = 0x01
+	ChannelParallel ChannelType = 0x02
+	ChannelPS2      ChannelType = 0x04
+	ChannelSCSI     ChannelType = 0x08
+	ChannelIDE      ChannelType = 0x10
+	ChannelUSB      ChannelType = 0x20
+)
+
+var channelTypeNames = map[ChannelType]string{
+	ChannelSerial:   "Serial",
+	ChannelParallel: "Parallel",
+	ChannelPS2:      "PS2",
+	ChannelSCSI:     "SCSI",
+	ChannelIDE:      "IDE",
+	ChannelUSB:      "USB",
+}
+
+func (t ChannelType) String() string {
+	if name, ok := channelTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ChannelType(%#x)", uint16(t))
+}
+
+// AttribFormatError is returned by the typed attribute accessors when the
+// value of an attribute cannot be decoded.
+type AttribFormatError struct {
+	Attrib Attrib
+	Value  []byte
+}
+
+func (e *AttribFormatError) Error() string {
+	return fmt.Sprintf("scard: attribute %#x: malformed value % x", uint32(e.Attrib), e.Value)
+}
+
+// GetAttribUint32 returns a numeric attribute. The value is a little endian
+// DWORD; shorter values and the 8 byte values of some drivers on 64 bit
+// platforms are accepted.
+func (card *Card) GetAttribUint32(id Attrib) (uint32, error) {
+	data, err := card.GetAttrib(id)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := decodeAttribUint(data)
+	if !ok {
+		return 0, &AttribFormatError{Attrib: id, Value: data}
+	}
+	return v, nil
+}
+
+// GetAttribString returns a string attribute. The value may be ASCII or
+// UTF-16LE, as returned for some attributes on Windows, with or without a
+// terminating NUL.
+func (card *Card) GetAttribString(id Attrib) (string, error) {
+	data, err := card.GetAttrib(id)
+	if err != nil {
+		return "", err
+	}
+	return decodeAttribString(data), nil
+}
+
+// VendorName returns AttrVendorName.
+func (card *Card) VendorName() (string, error) {
+	return card.GetAttribString(AttrVendorName)
+}
+
+// VendorIfdType returns AttrVendorIfdType, the vendor's name of the reader
+// model.
+func (card *Card) VendorIfdType() (string, error) {
+	return card.GetAttribString(AttrVendorIfdType)
+}
+
+// IfdSerialNo returns AttrVendorIfdSerialNo.
+func (card *Card) IfdSerialNo() (string, error) {
+	return card.GetAttribString(AttrVendorIfdSerialNo)
+}
+
+// IfdVersion returns AttrVendorIfdVersion, encoded as 0xMMmmbbbb. Major and
+// minor are BCD encoded by most drivers (e.g. from the USB bcdDevice) and
+// decoded as such if they are valid BCD.
+func (card *Card) IfdVersion() (major, minor, build int, err error) {
+	v, err := card.GetAttribUint32(AttrVendorIfdVersion)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, minor, build = splitIfdVersion(v)
+	return major, minor, build, nil
+}
+
+// ChannelID returns AttrChannelId, the type of the channel the reader is
+// attached by and the channel number. For USB readers the number is usually
+// the bus number in the upper and the device address in the lower byte.
+func (card *Card) ChannelID() (typ ChannelType, channel uint16, err error) {
+	v, err := card.GetAttribUint32(AttrChannelId)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ChannelType(v >> 16), uint16(v), nil
+}
+
+// DefaultClock returns AttrDefaultClk in kHz.
+func (card *Card) DefaultClock() (uint32, error) {
+	return card.GetAttribUint32(AttrDefaultClk)
+}
+
+// MaxClock returns AttrMaxClk in kHz.
+func (card *Card) MaxClock() (uint32, error) {
+	return card.GetAttribUint32(AttrMaxClk)
+}
+
+// CurrentClock returns AttrCurrentClk in kHz.
+func (card *Card) CurrentClock() (uint32, error) {
+	return card.GetAttribUint32(AttrCurrentClk)
+}
+
+// DefaultDataRate returns AttrDefaultDataRate in bps.
+func (card *Card) DefaultDataRate() (uint32, error) {
+	return card.GetAttribUint32(AttrDefaultDataRate)
+}
+
+// MaxDataRate returns AttrMaxDataRate in bps.
+func (card *Card) MaxDataRate() (uint32, error) {
+	return card.GetAttribUint32(AttrMaxDataRate)
+}
+
+// MaxIFSD returns AttrMaxIfsd, the maximum IFSD supported by the reader.
+func (card *Card) MaxIFSD() (uint32, error) {
+	return card.GetAttribUint32(AttrMaxIfsd)
+}
+
+// CurrentProtocol returns AttrCurrentProtocolType.
+func (card *Card) CurrentProtocol() (Protocol, error) {
+	v, err := card.GetAttribUint32(AttrCurrentProtocolType)
+	return Protocol(v), err
+}
+
+// CurrentIFSC returns AttrCurrentIfsc.
+func (card *Card) CurrentIFSC() (uint32, error) {
+	return card.GetAttribUint32(AttrCurrentIfsc)
+}
+
+// CurrentIFSD returns AttrCurrentIfsd.
+func (card *Card) CurrentIFSD() (uint32, error) {
+	return card.GetAttribUint32(AttrCurrentIfsd)
+}
+
+// CurrentBWT returns AttrCurrentBwt.
+func (card *Card) CurrentBWT() (uint32, error) {
+	return card.GetAttribUint32(AttrCurrentBwt)
+}
+
+// CurrentCWT returns AttrCurrentCwt.
+func (card *Card) CurrentCWT() (uint32, error) {
+	return card.GetAttribUint32(AttrCurrentCwt)
+}
+
+// AtrString returns AttrAtrString, the ATR of the card in the reader.
+func (card *Card) AtrString() ([]byte, error) {
+	return card.GetAttrib(AttrAtrString)
+}
+
+// DeviceFriendlyName returns AttrDeviceFriendlyName, the reader name.
+func (card *Card) DeviceFriendlyName() (string, error) {
+	return card.GetAttribString(AttrDeviceFriendlyName)
+}
+
+// DeviceSystemName returns AttrDeviceSystemName.
+func (card *Card) DeviceSystemName() (string, error) {
+	return card.GetAttribString(AttrDeviceSystemName)
+}
+
+// ReaderInfo gathers the attributes of a reader. Attributes that the reader
+// does not support are left zero and missing from Supported.
+type ReaderInfo struct {
+	VendorName    string
+	VendorIfdType string
+	IfdSerialNo   string
+	IfdMajor      int
+	IfdMinor      int
+	IfdBuild      int
+	ChannelType   ChannelType
+	Channel       uint16
+
+	DefaultClock    uint32 // kHz
+	MaxClock        uint32 // kHz
+	DefaultDataRate uint32 // bps
+	MaxDataRate     uint32 // bps
+	MaxIFSD         uint32
+
+	CurrentProtocol Protocol
+	CurrentClock    uint32 // kHz
+	CurrentIFSC     uint32
+	CurrentIFSD     uint32
+	Atr             []byte
+
+	FriendlyName string
+	SystemName   string
+
+	// Supported lists the attributes that were read.
+	Supported []Attrib
+}
+
+// ReaderInfo reads all attributes of ReaderInfo. It only fails if the card
+// or the reader cannot be accessed at all.
+func (card *Card) ReaderInfo() (*ReaderInfo, error) {
+	info := &ReaderInfo{}
+
+	var err error
+	read := func(id Attrib, decode func(data []byte) bool) {
+		if err != nil {
+			return
+		}
+		data, e := card.GetAttrib(id)
+		switch {
+		case e == nil && decode(data):
+			info.Supported = append(info.Supported, id)
+		case e != nil && !attribUnavailable(e):
+			err = e
+		}
+	}
+	str := func(p *string) func([]byte) bool {
+		return func(data []byte) bool {
+			*p = decodeAttribString(data)
+			return true
+		}
+	}
+	num := func(f func(v uint32)) func([]byte) bool {
+		return func(data []byte) bool {
+			v, ok := decodeAttribUint(data)
+			if ok {
+				f(v)
+			}
+			return ok
+		}
+	}
+	u32 := func(p *uint32) func([]byte) bool {
+		return num(func(v uint32) { *p = v })
+	}
+
+	read(AttrVendorName, str(&info.VendorName))
+	read(AttrVendorIfdType, str(&info.VendorIfdType))
+	read(AttrVendorIfdSerialNo, str(&info.IfdSerialNo))
+	read(AttrVendorIfdVersion, num(func(v uint32) {
+		info.IfdMajor, info.IfdMinor, info.IfdBuild = splitIfdVersion(v)
+	}))
+	read(AttrChannelId, num(func(v uint32) {
+		info.ChannelType, info.Channel = ChannelType(v>>16), uint16(v)
+	}))
+	read(AttrDefaultClk, u32(&info.DefaultClock))
+	read(AttrMaxClk, u32(&info.MaxClock))
+	read(AttrDefaultDataRate, u32(&info.DefaultDataRate))
+	read(AttrMaxDataRate, u32(&info.MaxDataRate))
+	read(AttrMaxIfsd, u32(&info.MaxIFSD))
+	read(AttrCurrentProtocolType, num(func(v uint32) {
+		info.CurrentProtocol = Protocol(v)
+	}))
+	read(AttrCurrentClk, u32(&info.CurrentClock))
+	read(AttrCurrentIfsc, u32(&info.CurrentIFSC))
+	read(AttrCurrentIfsd, u32(&info.CurrentIFSD))
+	read(AttrAtrString, func(data []byte) bool {
+		info.Atr = data
+		return len(data) > 0
+	})
+	read(AttrDeviceFriendlyName, str(&info.FriendlyName))
+	read(AttrDeviceSystemName, str(&info.SystemName))
+
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// attribUnavailable reports whether err means that a single attribute could
+// not be read, rather than the reader.
+func attribUnavailable(err error) bool {
+	switch errorCode(err) {
+	case ErrUnsupportedFeature, ErrInvalidParameter, ErrInvalidValue,
+		ErrNotTransacted, ErrInsufficientBuffer, ErrNoSmartcard, ErrUnpoweredCard,
+		errWinUnsupportedFeature, errWinNotSupported, errWinInvalidFunction:
+		return true
+	}
+	return false
+}
+
+// Win32 error codes returned by SCardGetAttrib for unsupported attributes.
+const (
+	errWinInvalidFunction Error = 1  // ERROR_INVALID_FUNCTION
+	errWinNotSupported    Error = 50 // ERROR_NOT_SUPPORTED
+)
+
+func decodeAttribUint(data []byte) (uint32, bool) {
+	switch len(data) {
+	case 1, 2, 4:
+	case 8:
+		if data[4]|data[5]|data[6]|data[7] != 0 {
+			return 0, false
+		}
+		data = data[:4]
+	default:
+		return 0, false
+	}
+	var v uint32
+	for i := len(data) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(data[i])
+	}
+	return v, true
+}
+
+func decodeAttribString(data []byte) string {
+	if isUTF16LE(data) {
+		u := make([]uint16, len(data)/2)
+		for i := range u {
+			u[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+		data = []byte(string(utf16.Decode(u)))
+	}
+	s := string(data)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// isUTF16LE reports whether data looks like an UTF-16LE encoded ASCII
+// string: every second byte is zero.
+func isUTF16LE(data []byte) bool {
+	if len(data) < 4 || len(data)%2 != 0 {
+		return false
+	}
+	for i := 1; i < len(data); i += 2 {
+		if data[i] != 0 {
+			return false
+		}
+	}
+	return data[0] != 0
+}
+
+func splitIfdVersion(v uint32) (major, minor, build int) {
+	return decodeBCD(byte(v >> 24)), decodeBCD(byte(v >> 16)), int(v & 0xffff)
+}
+
+// decodeBCD returns b decoded as two BCD digits, or b itself if it is not
+// valid BCD.
+func decodeBCD(b byte) int {
+	if b>>4 > 9 || b&0xf > 9 {
+		return int(b)
+	}
+	return int(b>>4)*10 + int(b&0xf)
+}
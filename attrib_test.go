@@ -0,0 +1,130 @@
+package scard
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeAttribString(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte("ACS\x00"), "ACS"},
+		{[]byte("ACS"), "ACS"},
+		{[]byte("a\x00"), "a"},
+		{[]byte{'A', 0, 'C', 0, 'S', 0, 0, 0}, "ACS"},
+		{[]byte{'A', 0, 0xe9, 0, 0, 0}, "Aé"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := decodeAttribString(tt.data); got != tt.want {
+			t.Errorf("decodeAttribString(% x) = %q; want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeAttribUint(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want uint32
+		ok   bool
+	}{
+		{[]byte{0x01}, 1, true},
+		{[]byte{0x34, 0x12}, 0x1234, true},
+		{[]byte{0x78, 0x56, 0x34, 0x12}, 0x12345678, true},
+		{[]byte{0x78, 0x56, 0x34, 0x12, 0, 0, 0, 0}, 0x12345678, true},
+		{[]byte{0x78, 0x56, 0x34, 0x12, 1, 0, 0, 0}, 0, false},
+		{[]byte{0x01, 0x02, 0x03}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := decodeAttribUint(tt.data)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("decodeAttribUint(% x) = %#x, %v; want %#x, %v", tt.data, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAttribAccessors(t *testing.T) {
+	fake := newFakeBackend()
+	fake.attribs = map[Attrib][]byte{
+		AttrVendorName:          []byte("ACS\x00"),
+		AttrVendorIfdType:       []byte("ACR122U\x00"),
+		AttrVendorIfdVersion:    {0x14, 0x02, 0x10, 0x02},
+		AttrChannelId:           {0x05, 0x01, 0x20, 0x00},
+		AttrMaxIfsd:             {0xfe, 0x00, 0x00, 0x00},
+		AttrCurrentProtocolType: {0x02, 0x00, 0x00, 0x00},
+		AttrCurrentClk:          {0xa0, 0x0f, 0x00, 0x00},
+		AttrDefaultClk:          {0x01, 0x02, 0x03},
+		AttrAtrString:           fake.atr,
+		AttrDeviceFriendlyName:  {'F', 0, 'a', 0, 'k', 0, 'e', 0, 0, 0},
+	}
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(fake.reader, ShareDirect, ProtocolUndefined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := card.VendorName(); err != nil || name != "ACS" {
+		t.Errorf("VendorName() = %q, %v", name, err)
+	}
+	if major, minor, build, err := card.IfdVersion(); err != nil || major != 2 || minor != 10 || build != 0x0214 {
+		t.Errorf("IfdVersion() = %d, %d, %#x, %v", major, minor, build, err)
+	}
+	if typ, ch, err := card.ChannelID(); err != nil || typ != ChannelUSB || ch != 0x0105 {
+		t.Errorf("ChannelID() = %v, %#x, %v", typ, ch, err)
+	}
+	if p, err := card.CurrentProtocol(); err != nil || p != ProtocolT1 {
+		t.Errorf("CurrentProtocol() = %v, %v", p, err)
+	}
+	if clk, err := card.CurrentClock(); err != nil || clk != 4000 {
+		t.Errorf("CurrentClock() = %d, %v", clk, err)
+	}
+	if name, err := card.DeviceFriendlyName(); err != nil || name != "Fake" {
+		t.Errorf("DeviceFriendlyName() = %q, %v", name, err)
+	}
+	if _, err := card.MaxClock(); !errors.Is(err, ErrUnsupportedFeature) {
+		t.Errorf("MaxClock() = %v; want %v", err, ErrUnsupportedFeature)
+	}
+	var fe *AttribFormatError
+	if _, err := card.DefaultClock(); !errors.As(err, &fe) || fe.Attrib != AttrDefaultClk {
+		t.Errorf("DefaultClock() = %v; want *AttribFormatError", err)
+	}
+
+	info, err := card.ReaderInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VendorName != "ACS" || info.VendorIfdType != "ACR122U" || info.IfdMajor != 2 || info.IfdMinor != 10 ||
+		info.ChannelType != ChannelUSB || info.MaxIFSD != 254 || info.CurrentProtocol != ProtocolT1 ||
+		info.FriendlyName != "Fake" || !bytes.Equal(info.Atr, fake.atr) {
+		t.Errorf("ReaderInfo() = %+v", info)
+	}
+	want := []Attrib{AttrVendorName, AttrVendorIfdType, AttrVendorIfdVersion, AttrChannelId, AttrMaxIfsd,
+		AttrCurrentProtocolType, AttrCurrentClk, AttrAtrString, AttrDeviceFriendlyName}
+	if len(info.Supported) != len(want) {
+		t.Fatalf("Supported = %#x; want %#x", info.Supported, want)
+	}
+	for i := range want {
+		if info.Supported[i] != want[i] {
+			t.Fatalf("Supported = %#x; want %#x", info.Supported, want)
+		}
+	}
+}
+
+func TestAttribUnavailable(t *testing.T) {
+	for _, err := range []error{ErrUnsupportedFeature, errWinUnsupportedFeature, errWinNotSupported, errWinInvalidFunction} {
+		if !attribUnavailable(&OpError{Op: "GetAttrib", Err: err}) {
+			t.Errorf("attribUnavailable(%v) = false", err)
+		}
+	}
+	if attribUnavailable(ErrRemovedCard) {
+		t.Errorf("attribUnavailable(%v) = true", ErrRemovedCard)
+	}
+}
@@ -0,0 +1,104 @@
+package scard
+
+import (
+	"time"
+)
+
+// Backend is the interface between Context/Card and a PC/SC implementation.
+//
+// Context and card handles are opaque to the caller and only need to be
+// meaningful to the Backend that issued them. Methods report failures with
+// an Error code where one applies, so that callers can compare against the
+// ErrXxx constants regardless of the backend in use.
+//
+// DefaultBackend returns the platform implementation (libpcsclite, the PCSC
+// framework or winscard.dll). Other implementations can be passed to
+// EstablishContextWithBackend, e.g. to inject fakes in tests.
+type Backend interface {
+	// EstablishContext wraps SCardEstablishContext.
+	EstablishContext(scope Scope) (uintptr, error)
+	// ReleaseContext wraps SCardReleaseContext.
+	ReleaseContext(ctx uintptr) error
+	// IsValidContext wraps SCardIsValidContext.
+	IsValidContext(ctx uintptr) error
+	// Cancel wraps SCardCancel. It must be safe to call while another
+	// goroutine is blocked in GetStatusChange on the same context.
+	Cancel(ctx uintptr) error
+	// ListReaders wraps SCardListReaders. A nil groups slice lists all
+	// readers.
+	ListReaders(ctx uintptr, groups []string) ([]string, error)
+	// ListReaderGroups wraps SCardListReaderGroups.
+	ListReaderGroups(ctx uintptr) ([]string, error)
+	// GetStatusChange wraps SCardGetStatusChange. It updates EventState and
+	// Atr of states in place. A negative timeout waits forever.
+	GetStatusChange(ctx uintptr, timeout time.Duration, states []ReaderState) error
+	// Connect wraps SCardConnect and returns the card handle and the active
+	// protocol.
+	Connect(ctx uintptr, reader string, mode ShareMode, proto Protocol) (uintptr, Protocol, error)
+	// Disconnect wraps SCardDisconnect.
+	Disconnect(card uintptr, d Disposition) error
+	// Reconnect wraps SCardReconnect and returns the new active protocol.
+	Reconnect(card uintptr, mode ShareMode, proto Protocol, d Disposition) (Protocol, error)
+	// BeginTransaction wraps SCardBeginTransaction.
+	BeginTransaction(card uintptr) error
+	// EndTransaction wraps SCardEndTransaction.
+	EndTransaction(card uintptr, d Disposition) error
+	// Status wraps SCardStatus.
+	Status(card uintptr) (*CardStatus, error)
+	// Transmit wraps SCardTransmit. The response is written to rsp and its
+	// length returned.
+	Transmit(card uintptr, proto Protocol, cmd, rsp []byte) (int, error)
+	// Control wraps SCardControl. The output is written to out and its
+	// length returned.
+	Control(card uintptr, ioctl uint32, in, out []byte) (int, error)
+	// GetAttrib wraps SCardGetAttrib.
+	GetAttrib(card uintptr, id Attrib) ([]byte, error)
+	// SetAttrib wraps SCardSetAttrib.
+	SetAttrib(card uintptr, id Attrib, data []byte) error
+}
+
+// IORequest is the protocol control information (SCARD_IO_REQUEST) sent with
+// a command or received with a response. Extra holds the protocol specific
+// data following the header.
+type IORequest struct {
+	Protocol Protocol
+	Extra    []byte
+}
+
+// PCITransmitter is implemented by Backends that can send custom protocol
+// control information with a command.
+type PCITransmitter interface {
+	// TransmitPCI is like Transmit but sends the command with send as the
+	// SCARD_IO_REQUEST and returns the one received with the response.
+	TransmitPCI(card uintptr, send *IORequest, cmd, rsp []byte) (int, *IORequest, error)
+}
+
+// ReaderStateLimiter is implemented by Backends that accept a limited
+// number of reader states per GetStatusChange call. Context.GetStatusChange
+// splits larger sets of reader states over several contexts.
+type ReaderStateLimiter interface {
+	// MaxReaderStates returns the maximum number of reader states of a
+	// GetStatusChange call.
+	MaxReaderStates() int
+}
+
+// DefaultBackend returns the platform PC/SC implementation used by
+// EstablishContext.
+func DefaultBackend() Backend {
+	return defaultBackend
+}
+
+// EstablishContextWithBackend is like EstablishContext but issues all calls
+// on the returned Context, and on Cards connected through it, to b.
+func EstablishContextWithBackend(b Backend) (*Context, error) {
+	return newContext(b, ScopeSystem)
+}
+
+func newContext(b Backend, scope Scope) (*Context, error) {
+	ctx, err := b.EstablishContext(scope)
+	if err != nil {
+		return nil, opError("EstablishContext", "", err)
+	}
+
+	return &Context{backend: b, ctx: ctx, scope: scope}, nil
+}
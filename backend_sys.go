@@ -0,0 +1,361 @@
+//go:build windows || darwin || (cgo && !purego)
+// +build windows darwin cgo,!purego
+
+package scard
+
+import (
+	"bytes"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// sysBackend implements Backend on top of the platform PC/SC library.
+type sysBackend struct{}
+
+var defaultBackend Backend = sysBackend{}
+
+// contextStates holds the reader states a context passes to
+// SCardGetStatusChange. They are kept between calls, so that they are
+// allocated once per context and outside of the Go heap where the platform
+// requires it, and freed by ReleaseContext. Concurrent calls on one context
+// each use their own states.
+type contextStates struct {
+	mu       sync.Mutex
+	idle     []*readerStates
+	released bool
+}
+
+var sysContexts = struct {
+	sync.Mutex
+	m map[uintptr]*contextStates
+}{m: make(map[uintptr]*contextStates)}
+
+func statesOf(ctx uintptr) *contextStates {
+	sysContexts.Lock()
+	defer sysContexts.Unlock()
+
+	cs, ok := sysContexts.m[ctx]
+	if !ok {
+		cs = &contextStates{}
+		sysContexts.m[ctx] = cs
+	}
+	return cs
+}
+
+// releaseStates frees the states of ctx. It is called by ReleaseContext
+// and when ctx turns out to be invalid, so that no entry is left for it.
+func releaseStates(ctx uintptr) {
+	sysContexts.Lock()
+	cs, ok := sysContexts.m[ctx]
+	delete(sysContexts.m, ctx)
+	sysContexts.Unlock()
+
+	if ok {
+		cs.mu.Lock()
+		cs.released = true
+		for _, s := range cs.idle {
+			s.free()
+		}
+		cs.idle = nil
+		cs.mu.Unlock()
+	}
+}
+
+// get returns idle states or new ones if all are in use.
+func (cs *contextStates) get() (*readerStates, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.released {
+		return nil, ErrInvalidHandle
+	}
+	if n := len(cs.idle); n > 0 {
+		s := cs.idle[n-1]
+		cs.idle = cs.idle[:n-1]
+		return s, nil
+	}
+	return &readerStates{}, nil
+}
+
+// put returns states obtained from get, freeing them if the context has
+// been released in the meantime.
+func (cs *contextStates) put(s *readerStates) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.released {
+		s.free()
+		return
+	}
+	cs.idle = append(cs.idle, s)
+}
+
+func (sysBackend) EstablishContext(scope Scope) (uintptr, error) {
+	ctx, r := scardEstablishContext(scope)
+	if r != ErrSuccess {
+		return 0, r
+	}
+	return ctx, nil
+}
+
+func (sysBackend) ReleaseContext(ctx uintptr) error {
+	r := scardReleaseContext(ctx)
+	releaseStates(ctx)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (sysBackend) IsValidContext(ctx uintptr) error {
+	r := scardIsValidContext(ctx)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (sysBackend) Cancel(ctx uintptr) error {
+	r := scardCancel(ctx)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (sysBackend) ListReaders(ctx uintptr, groups []string) ([]string, error) {
+	var pgroups unsafe.Pointer
+	if groups != nil {
+		buf, err := encodemstr(groups...)
+		if err != nil {
+			return nil, err
+		}
+		pgroups = buf.ptr()
+	}
+
+	needed, r := scardListReaders(ctx, pgroups, nil, 0)
+	if r != ErrSuccess {
+		return nil, r
+	}
+
+	buf := make(strbuf, needed)
+	n, r := scardListReaders(ctx, pgroups, buf.ptr(), uint32(len(buf)))
+	if r != ErrSuccess {
+		return nil, r
+	}
+	return decodemstr(buf[:n]), nil
+}
+
+func (sysBackend) ListReaderGroups(ctx uintptr) ([]string, error) {
+	needed, r := scardListReaderGroups(ctx, nil, 0)
+	if r != ErrSuccess {
+		return nil, r
+	}
+
+	buf := make(strbuf, needed)
+	n, r := scardListReaderGroups(ctx, buf.ptr(), uint32(len(buf)))
+	if r != ErrSuccess {
+		return nil, r
+	}
+	return decodemstr(buf[:n]), nil
+}
+
+func (sysBackend) GetStatusChange(ctx uintptr, timeout time.Duration, readerStates []ReaderState) error {
+	dwTimeout := durationToTimeout(timeout)
+
+	cs := statesOf(ctx)
+	states, err := cs.get()
+	if err != nil {
+		return err
+	}
+	defer cs.put(states)
+
+	if err := states.set(readerStates); err != nil {
+		return err
+	}
+
+	r := states.getStatusChange(ctx, dwTimeout, len(readerStates))
+	if r == ErrInvalidHandle {
+		// not established by us or released already
+		releaseStates(ctx)
+	}
+	if r != ErrSuccess {
+		return r
+	}
+
+	states.update(readerStates)
+	return nil
+}
+
+// stateAtr returns the ATR of a reader state after SCardGetStatusChange.
+// An ATR passed in that did not fit in rgbAtr is kept if the truncated copy
+// came back unchanged.
+func stateAtr(in, rgbAtr []byte, cbAtr int) []byte {
+	if cbAtr > len(rgbAtr) {
+		cbAtr = len(rgbAtr)
+	}
+	if cbAtr == len(rgbAtr) && len(in) > cbAtr && bytes.Equal(in[:cbAtr], rgbAtr) {
+		return in
+	}
+	return append([]byte(nil), rgbAtr[:cbAtr]...)
+}
+
+func (sysBackend) Connect(ctx uintptr, reader string, mode ShareMode, proto Protocol) (uintptr, Protocol, error) {
+	creader, err := encodestr(reader)
+	if err != nil {
+		return 0, 0, err
+	}
+	handle, activeProtocol, r := scardConnect(ctx, creader.ptr(), mode, proto)
+	if r != ErrSuccess {
+		return 0, 0, r
+	}
+	return handle, activeProtocol, nil
+}
+
+func (sysBackend) Disconnect(card uintptr, d Disposition) error {
+	r := scardDisconnect(card, d)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (sysBackend) Reconnect(card uintptr, mode ShareMode, proto Protocol, d Disposition) (Protocol, error) {
+	activeProtocol, r := scardReconnect(card, mode, proto, d)
+	if r != ErrSuccess {
+		return 0, r
+	}
+	return activeProtocol, nil
+}
+
+func (sysBackend) BeginTransaction(card uintptr) error {
+	r := scardBeginTransaction(card)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (sysBackend) EndTransaction(card uintptr, d Disposition) error {
+	r := scardEndTransaction(card, d)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (sysBackend) Status(card uintptr) (*CardStatus, error) {
+	var readerBuf = make(strbuf, maxReadername+1)
+	var atrBuf = make([]byte, maxAtrSize)
+
+	readerLen, state, proto, atrLen, err := scardCardStatus(card, readerBuf, atrBuf)
+	if err == ErrInsufficientBuffer {
+		if uint32(len(readerBuf)) < readerLen {
+			readerBuf = make(strbuf, readerLen)
+		}
+		if uint32(len(atrBuf)) < atrLen {
+			atrBuf = make([]byte, atrLen)
+		}
+		readerLen, state, proto, atrLen, err = scardCardStatus(card, readerBuf, atrBuf)
+	}
+
+	if err != ErrSuccess {
+		return nil, err
+	}
+
+	reader := decodemstr(readerBuf[:readerLen])
+
+	return &CardStatus{Reader: reader[0], State: state, ActiveProtocol: proto, Atr: atrBuf[:atrLen]}, nil
+}
+
+func (sysBackend) Transmit(card uintptr, proto Protocol, cmd, rsp []byte) (int, error) {
+	rspLen, r := scardTransmit(card, proto, cmd, rsp)
+	if r != ErrSuccess {
+		return 0, r
+	}
+	return int(rspLen), nil
+}
+
+// maxPCIExtra is the room for protocol specific data after the
+// SCARD_IO_REQUEST header received by TransmitPCI.
+const maxPCIExtra = 256
+
+func (sysBackend) TransmitPCI(card uintptr, send *IORequest, cmd, rsp []byte) (int, *IORequest, error) {
+	rspLen, recv, r := scardTransmitPCI(card, send, cmd, rsp)
+	if r != ErrSuccess {
+		return 0, nil, r
+	}
+	return int(rspLen), recv, nil
+}
+
+func (sysBackend) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	outLen, r := scardControl(card, ioctl, in, out)
+	if r != ErrSuccess {
+		return 0, r
+	}
+	return int(outLen), nil
+}
+
+func (sysBackend) GetAttrib(card uintptr, id Attrib) ([]byte, error) {
+	needed, r := scardGetAttrib(card, id, nil)
+	if r != ErrSuccess {
+		return nil, r
+	}
+
+	var attrib = make([]byte, needed)
+	n, r := scardGetAttrib(card, id, attrib)
+	if r != ErrSuccess {
+		return nil, r
+	}
+	return attrib[:n], nil
+}
+
+func (sysBackend) SetAttrib(card uintptr, id Attrib, data []byte) error {
+	r := scardSetAttrib(card, id, data)
+	if r != ErrSuccess {
+		return r
+	}
+	return nil
+}
+
+func (buf strbuf) ptr() unsafe.Pointer {
+	return unsafe.Pointer(&buf[0])
+}
+
+func (buf strbuf) split() []strbuf {
+	var chunks []strbuf
+	for len(buf) > 0 && buf[0] != 0 {
+		i := 0
+		for i = range buf {
+			if buf[i] == 0 {
+				break
+			}
+		}
+		chunks = append(chunks, buf[:i+1])
+		buf = buf[i+1:]
+	}
+
+	return chunks
+}
+
+func encodemstr(strings ...string) (strbuf, error) {
+	var buf strbuf
+	for _, s := range strings {
+		utf16, err := encodestr(s)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, utf16...)
+	}
+	buf = append(buf, 0)
+	return buf, nil
+}
+
+func decodemstr(buf strbuf) []string {
+	var strings []string
+	for _, chunk := range buf.split() {
+		strings = append(strings, decodestr(chunk))
+	}
+	return strings
+}
@@ -0,0 +1,443 @@
+package scard
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeBackend is a single reader, single card Backend used to test the
+// Context and Card wrappers without pcscd.
+type fakeBackend struct {
+	reader   string
+	atr      []byte
+	attribs  map[Attrib][]byte
+	transmit func(cmd []byte) ([]byte, error)
+	begin    func() error
+
+	calls       []string
+	scope       Scope
+	disposition Disposition
+}
+
+func (f *fakeBackend) called(name string) {
+	f.calls = append(f.calls, name)
+}
+
+func (f *fakeBackend) EstablishContext(scope Scope) (uintptr, error) {
+	f.called("EstablishContext")
+	f.scope = scope
+	return 1, nil
+}
+
+func (f *fakeBackend) ReleaseContext(ctx uintptr) error {
+	f.called("ReleaseContext")
+	return nil
+}
+
+func (f *fakeBackend) IsValidContext(ctx uintptr) error {
+	f.called("IsValidContext")
+	if ctx != 1 {
+		return ErrInvalidHandle
+	}
+	return nil
+}
+
+func (f *fakeBackend) Cancel(ctx uintptr) error {
+	f.called("Cancel")
+	return nil
+}
+
+func (f *fakeBackend) ListReaders(ctx uintptr, groups []string) ([]string, error) {
+	f.called("ListReaders")
+	if groups != nil && !containsString(groups, "SCard$DefaultReaders") {
+		return nil, ErrNoReadersAvailable
+	}
+	return []string{f.reader}, nil
+}
+
+func (f *fakeBackend) ListReaderGroups(ctx uintptr) ([]string, error) {
+	f.called("ListReaderGroups")
+	return []string{"SCard$DefaultReaders"}, nil
+}
+
+func (f *fakeBackend) GetStatusChange(ctx uintptr, timeout time.Duration, states []ReaderState) error {
+	f.called("GetStatusChange")
+	for i := range states {
+		if states[i].Reader != f.reader {
+			states[i].EventState = StateChanged | StateUnknown
+			continue
+		}
+		states[i].EventState = StatePresent
+		if states[i].CurrentState != StatePresent {
+			states[i].EventState |= StateChanged
+		}
+		states[i].Atr = f.atr
+	}
+	return nil
+}
+
+func (f *fakeBackend) Connect(ctx uintptr, reader string, mode ShareMode, proto Protocol) (uintptr, Protocol, error) {
+	f.called("Connect")
+	if reader != f.reader {
+		return 0, 0, ErrUnknownReader
+	}
+	return 2, ProtocolT1, nil
+}
+
+func (f *fakeBackend) Disconnect(card uintptr, d Disposition) error {
+	f.called("Disconnect")
+	return nil
+}
+
+func (f *fakeBackend) Reconnect(card uintptr, mode ShareMode, proto Protocol, d Disposition) (Protocol, error) {
+	f.called("Reconnect")
+	return ProtocolT0, nil
+}
+
+func (f *fakeBackend) BeginTransaction(card uintptr) error {
+	f.called("BeginTransaction")
+	if f.begin != nil {
+		return f.begin()
+	}
+	return nil
+}
+
+func (f *fakeBackend) EndTransaction(card uintptr, d Disposition) error {
+	f.called("EndTransaction")
+	f.disposition = d
+	return nil
+}
+
+func (f *fakeBackend) Status(card uintptr) (*CardStatus, error) {
+	f.called("Status")
+	return &CardStatus{Reader: f.reader, State: Present | Powered | Specific, ActiveProtocol: ProtocolT1, Atr: f.atr}, nil
+}
+
+func (f *fakeBackend) Transmit(card uintptr, proto Protocol, cmd, rsp []byte) (int, error) {
+	f.called("Transmit")
+	if f.transmit == nil {
+		return copy(rsp, []byte{0x90, 0x00}), nil
+	}
+	r, err := f.transmit(cmd)
+	if err != nil {
+		return 0, err
+	}
+	if len(r) > len(rsp) {
+		return 0, ErrInsufficientBuffer
+	}
+	return copy(rsp, r), nil
+}
+
+func (f *fakeBackend) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	f.called("Control")
+	return copy(out, in), nil
+}
+
+func (f *fakeBackend) GetAttrib(card uintptr, id Attrib) ([]byte, error) {
+	f.called("GetAttrib")
+	v, ok := f.attribs[id]
+	if !ok {
+		return nil, ErrUnsupportedFeature
+	}
+	return v, nil
+}
+
+func (f *fakeBackend) SetAttrib(card uintptr, id Attrib, data []byte) error {
+	f.called("SetAttrib")
+	if f.attribs == nil {
+		f.attribs = make(map[Attrib][]byte)
+	}
+	f.attribs[id] = data
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		reader: "Fake Reader 00 00",
+		atr:    []byte{0x3b, 0x80, 0x80, 0x01, 0x01},
+	}
+}
+
+func TestEstablishContextWithBackend(t *testing.T) {
+	fake := newFakeBackend()
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, err := ctx.IsValid()
+	if err != nil || !valid {
+		t.Fatalf("IsValid() = %v, %v; want true, nil", valid, err)
+	}
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 || readers[0] != fake.reader {
+		t.Fatalf("ListReaders() = %q", readers)
+	}
+
+	rs := []ReaderState{{Reader: fake.reader, CurrentState: StateUnaware}}
+	if err := ctx.GetStatusChange(rs, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&StatePresent == 0 || !bytes.Equal(rs[0].Atr, fake.atr) {
+		t.Fatalf("GetStatusChange: %+v", rs[0])
+	}
+
+	if _, err := ctx.Connect("no such reader", ShareShared, ProtocolAny); !errors.Is(err, ErrUnknownReader) {
+		t.Fatalf("Connect(unknown reader) = %v; want %v", err, ErrUnknownReader)
+	}
+
+	card, err := ctx.Connect(fake.reader, ShareShared, ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.ActiveProtocol() != ProtocolT1 {
+		t.Fatalf("ActiveProtocol() = %v; want %v", card.ActiveProtocol(), ProtocolT1)
+	}
+
+	rsp, err := card.Transmit([]byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, []byte{0x90, 0x00}) {
+		t.Fatalf("Transmit: rsp = % x", rsp)
+	}
+
+	out, err := card.Control(CtlCode(3400), []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte{1, 2, 3}) {
+		t.Fatalf("Control: out = % x", out)
+	}
+
+	if err := card.Reconnect(ShareShared, ProtocolAny, ResetCard); err != nil {
+		t.Fatal(err)
+	}
+	if card.ActiveProtocol() != ProtocolT0 {
+		t.Fatalf("ActiveProtocol() after Reconnect = %v; want %v", card.ActiveProtocol(), ProtocolT0)
+	}
+
+	if err := card.Disconnect(LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"EstablishContext", "IsValidContext", "ListReaders", "GetStatusChange", "Connect", "Connect", "Transmit", "Control", "Reconnect", "Disconnect", "ReleaseContext"}
+	if len(fake.calls) != len(want) {
+		t.Fatalf("calls = %q; want %q", fake.calls, want)
+	}
+	for i := range want {
+		if fake.calls[i] != want[i] {
+			t.Fatalf("calls = %q; want %q", fake.calls, want)
+		}
+	}
+}
+
+func TestContextScope(t *testing.T) {
+	fake := newFakeBackend()
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.scope != ScopeSystem {
+		t.Errorf("EstablishContextWithBackend: scope = %d; want %d", fake.scope, ScopeSystem)
+	}
+	ctx.Release()
+
+	ctx, err = newContext(fake, ScopeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.scope != ScopeUser {
+		t.Errorf("newContext: scope = %d; want %d", fake.scope, ScopeUser)
+	}
+	ctx.Release()
+}
+
+func TestListReadersInGroups(t *testing.T) {
+	fake := newFakeBackend()
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	for _, groups := range [][]string{nil, {"SCard$DefaultReaders"}, {"Kiosk", "SCard$DefaultReaders"}} {
+		readers, err := ctx.ListReadersInGroups(groups...)
+		if err != nil || len(readers) != 1 || readers[0] != fake.reader {
+			t.Errorf("ListReadersInGroups(%q) = %q, %v; want [%q]", groups, readers, err, fake.reader)
+		}
+	}
+	if readers, err := ctx.ListReadersInGroups("Kiosk"); !errors.Is(err, ErrNoReadersAvailable) {
+		t.Errorf("ListReadersInGroups(\"Kiosk\") = %q, %v; want %v", readers, err, ErrNoReadersAvailable)
+	}
+}
+
+func TestTransmitInto(t *testing.T) {
+	fake := newFakeBackend()
+	fake.transmit = func(cmd []byte) ([]byte, error) {
+		return []byte{0x01, 0x02, 0x03, 0x90, 0x00}, nil
+	}
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(fake.reader, ShareShared, ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := []byte{0x00, 0xb0, 0x00, 0x00, 0x03}
+	rsp := make([]byte, 5)
+	n, err := card.TransmitInto(cmd, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp[:n], []byte{0x01, 0x02, 0x03, 0x90, 0x00}) {
+		t.Fatalf("TransmitInto() rsp = % x", rsp[:n])
+	}
+	if _, err := card.TransmitInto(cmd, rsp[:4]); !errors.Is(err, ErrInsufficientBuffer) {
+		t.Fatalf("TransmitInto(short buffer) = %v; want %v", err, ErrInsufficientBuffer)
+	}
+
+	out := make([]byte, 3)
+	n, err = card.ControlInto(CtlCode(3400), []byte{1, 2, 3}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out[:n], []byte{1, 2, 3}) {
+		t.Fatalf("ControlInto() out = % x", out[:n])
+	}
+}
+
+// benchBackend answers Transmit and Control without recording the calls.
+type benchBackend struct {
+	*fakeBackend
+}
+
+func (benchBackend) Transmit(card uintptr, proto Protocol, cmd, rsp []byte) (int, error) {
+	return copy(rsp, []byte{0x90, 0x00}), nil
+}
+
+func (benchBackend) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	return copy(out, in), nil
+}
+
+func benchCard(b testing.TB) *Card {
+	ctx, err := EstablishContextWithBackend(benchBackend{newFakeBackend()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	card, err := ctx.Connect("Fake Reader 00 00", ShareShared, ProtocolAny)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return card
+}
+
+func TestTransmitIntoAllocs(t *testing.T) {
+	card := benchCard(t)
+	rsp := make([]byte, 258)
+	out := make([]byte, 256)
+
+	if allocs := testing.AllocsPerRun(100, func() { card.TransmitInto(benchCmd, rsp) }); allocs != 0 {
+		t.Errorf("TransmitInto() allocs = %v; want 0", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { card.ControlInto(CtlCode(3400), nil, out) }); allocs != 0 {
+		t.Errorf("ControlInto() allocs = %v; want 0", allocs)
+	}
+}
+
+var benchCmd = []byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00}
+
+func BenchmarkTransmit(b *testing.B) {
+	card := benchCard(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := card.Transmit(benchCmd); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransmitInto(b *testing.B) {
+	card := benchCard(b)
+	rsp := make([]byte, 258)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := card.TransmitInto(benchCmd, rsp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkControl(b *testing.B) {
+	card := benchCard(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := card.Control(CtlCode(3400), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkControlInto(b *testing.B) {
+	card := benchCard(b)
+	out := make([]byte, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := card.ControlInto(CtlCode(3400), nil, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTransmitPCI(t *testing.T) {
+	fake := newFakeBackend()
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(fake.reader, ShareDirect, ProtocolUndefined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := make([]byte, 2)
+	n, recv, err := card.TransmitPCI(&IORequest{Protocol: ProtocolRaw}, []byte{0x00}, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp[:n], []byte{0x90, 0x00}) {
+		t.Fatalf("TransmitPCI() rsp = % x", rsp[:n])
+	}
+	if recv.Protocol != ProtocolRaw {
+		t.Fatalf("TransmitPCI() recv.Protocol = %v; want %v", recv.Protocol, ProtocolRaw)
+	}
+
+	// fakeBackend is not a PCITransmitter and cannot pass on protocol data
+	send := &IORequest{Protocol: ProtocolT1, Extra: []byte{0x01}}
+	if _, _, err := card.TransmitPCI(send, []byte{0x00}, rsp); !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("TransmitPCI(Extra) = %v; want %v", err, ErrUnsupportedFeature)
+	}
+}
@@ -127,6 +127,8 @@ const (
 	ProtocolUndefined Protocol = C.SCARD_PROTOCOL_UNDEFINED
 	ProtocolT0        Protocol = C.SCARD_PROTOCOL_T0
 	ProtocolT1        Protocol = C.SCARD_PROTOCOL_T1
+	ProtocolRaw       Protocol = C.SCARD_PROTOCOL_RAW
+	ProtocolT15       Protocol = C.SCARD_PROTOCOL_T15
 	ProtocolAny       Protocol = ProtocolT0 | ProtocolT1
 )
 
@@ -0,0 +1,191 @@
+package scard
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type errorText struct {
+	name string
+	desc string
+}
+
+// errorTexts holds the symbolic names and descriptions of the Error codes.
+// It is used on all platforms so that the messages do not depend on the
+// PC/SC implementation.
+var errorTexts = map[Error]errorText{
+	ErrSuccess:                {"SCARD_S_SUCCESS", "Command successful."},
+	ErrInternalError:          {"SCARD_F_INTERNAL_ERROR", "Internal error."},
+	ErrCancelled:              {"SCARD_E_CANCELLED", "Command cancelled."},
+	ErrInvalidHandle:          {"SCARD_E_INVALID_HANDLE", "Invalid handle."},
+	ErrInvalidParameter:       {"SCARD_E_INVALID_PARAMETER", "Invalid parameter given."},
+	ErrInvalidTarget:          {"SCARD_E_INVALID_TARGET", "Invalid target given."},
+	ErrNoMemory:               {"SCARD_E_NO_MEMORY", "Not enough memory."},
+	ErrWaitedTooLong:          {"SCARD_F_WAITED_TOO_LONG", "Waited too long."},
+	ErrInsufficientBuffer:     {"SCARD_E_INSUFFICIENT_BUFFER", "Insufficient buffer."},
+	ErrUnknownReader:          {"SCARD_E_UNKNOWN_READER", "Unknown reader specified."},
+	ErrTimeout:                {"SCARD_E_TIMEOUT", "Command timeout."},
+	ErrSharingViolation:       {"SCARD_E_SHARING_VIOLATION", "Sharing violation."},
+	ErrNoSmartcard:            {"SCARD_E_NO_SMARTCARD", "No smart card inserted."},
+	ErrUnknownCard:            {"SCARD_E_UNKNOWN_CARD", "Unknown card."},
+	ErrCantDispose:            {"SCARD_E_CANT_DISPOSE", "Cannot dispose handle."},
+	ErrProtoMismatch:          {"SCARD_E_PROTO_MISMATCH", "Card protocol mismatch."},
+	ErrNotReady:               {"SCARD_E_NOT_READY", "Subsystem not ready."},
+	ErrInvalidValue:           {"SCARD_E_INVALID_VALUE", "Invalid value given."},
+	ErrSystemCancelled:        {"SCARD_E_SYSTEM_CANCELLED", "System cancelled."},
+	ErrCommError:              {"SCARD_F_COMM_ERROR", "RPC transport error."},
+	ErrUnknownError:           {"SCARD_F_UNKNOWN_ERROR", "Unknown error."},
+	ErrInvalidAtr:             {"SCARD_E_INVALID_ATR", "Invalid ATR."},
+	ErrNotTransacted:          {"SCARD_E_NOT_TRANSACTED", "Transaction failed."},
+	ErrReaderUnavailable:      {"SCARD_E_READER_UNAVAILABLE", "Reader is unavailable."},
+	ErrShutdown:               {"SCARD_P_SHUTDOWN", "Operation aborted to allow the server to exit."},
+	ErrPciTooSmall:            {"SCARD_E_PCI_TOO_SMALL", "PCI struct too small."},
+	ErrReaderUnsupported:      {"SCARD_E_READER_UNSUPPORTED", "Reader is unsupported."},
+	ErrDuplicateReader:        {"SCARD_E_DUPLICATE_READER", "Reader already exists."},
+	ErrCardUnsupported:        {"SCARD_E_CARD_UNSUPPORTED", "Card is unsupported."},
+	ErrNoService:              {"SCARD_E_NO_SERVICE", "Service not available."},
+	ErrServiceStopped:         {"SCARD_E_SERVICE_STOPPED", "Service was stopped."},
+	ErrUnsupportedFeature:     {"SCARD_E_UNSUPPORTED_FEATURE", "Feature not supported."},
+	ErrIccInstallation:        {"SCARD_E_ICC_INSTALLATION", "No primary provider found for the card."},
+	ErrIccCreateorder:         {"SCARD_E_ICC_CREATEORDER", "Requested order of object creation is not supported."},
+	ErrFileNotFound:           {"SCARD_E_FILE_NOT_FOUND", "File not found on the card."},
+	ErrNoDir:                  {"SCARD_E_NO_DIR", "Path is not a directory."},
+	ErrNoFile:                 {"SCARD_E_NO_FILE", "Path is not a file."},
+	ErrNoAccess:               {"SCARD_E_NO_ACCESS", "Access is denied to the file."},
+	ErrWriteTooMany:           {"SCARD_E_WRITE_TOO_MANY", "Card cannot be written, it is out of memory."},
+	ErrBadSeek:                {"SCARD_E_BAD_SEEK", "Error trying to set the file pointer."},
+	ErrInvalidChv:             {"SCARD_E_INVALID_CHV", "PIN is invalid."},
+	ErrUnknownResMng:          {"SCARD_E_UNKNOWN_RES_MNG", "Unrecognized error code from a layered component."},
+	ErrNoSuchCertificate:      {"SCARD_E_NO_SUCH_CERTIFICATE", "Certificate does not exist."},
+	ErrCertificateUnavailable: {"SCARD_E_CERTIFICATE_UNAVAILABLE", "Certificate could not be obtained."},
+	ErrNoReadersAvailable:     {"SCARD_E_NO_READERS_AVAILABLE", "Cannot find a smart card reader."},
+	ErrCommDataLost:           {"SCARD_E_COMM_DATA_LOST", "Communications error with the card, retry."},
+	ErrNoKeyContainer:         {"SCARD_E_NO_KEY_CONTAINER", "Key container does not exist."},
+	ErrServerTooBusy:          {"SCARD_E_SERVER_TOO_BUSY", "Resource manager is too busy."},
+	ErrUnsupportedCard:        {"SCARD_W_UNSUPPORTED_CARD", "Card is not supported."},
+	ErrUnresponsiveCard:       {"SCARD_W_UNRESPONSIVE_CARD", "Card is unresponsive."},
+	ErrUnpoweredCard:          {"SCARD_W_UNPOWERED_CARD", "Card is unpowered."},
+	ErrResetCard:              {"SCARD_W_RESET_CARD", "Card was reset."},
+	ErrRemovedCard:            {"SCARD_W_REMOVED_CARD", "Card was removed."},
+	ErrSecurityViolation:      {"SCARD_W_SECURITY_VIOLATION", "Access denied."},
+	ErrWrongChv:               {"SCARD_W_WRONG_CHV", "Wrong PIN."},
+	ErrChvBlocked:             {"SCARD_W_CHV_BLOCKED", "PIN is blocked."},
+	ErrEof:                    {"SCARD_W_EOF", "End of file reached."},
+	ErrCancelledByUser:        {"SCARD_W_CANCELLED_BY_USER", "Cancelled by the user."},
+	ErrCardNotAuthenticated:   {"SCARD_W_CARD_NOT_AUTHENTICATED", "No PIN was presented to the card."},
+	errWinUnsupportedFeature:  {"SCARD_E_UNSUPPORTED_FEATURE", "Feature not supported."},
+}
+
+// Name returns the symbolic name of the error code, e.g.
+// "SCARD_E_NO_SMARTCARD", or its value in hex if it is not known.
+//
+// ErrUnexpected and ErrUnsupportedFeature share the same value; its name is
+// SCARD_E_UNSUPPORTED_FEATURE like in pcsc-lite.
+func (e Error) Name() string {
+	if t, ok := errorTexts[e]; ok {
+		return t.name
+	}
+	return fmt.Sprintf("0x%08X", uint32(e))
+}
+
+// Error returns the description of the error code. The messages are the
+// same on all platforms and backends. Codes that are not PC/SC errors are
+// described by the system on Windows.
+func (e Error) Error() string {
+	if t, ok := errorTexts[e]; ok {
+		return "scard: " + t.desc
+	}
+	if s, ok := sysErrorText(e); ok {
+		return fmt.Sprintf("scard: error(0x%08X): %s", uint32(e), s)
+	}
+	return fmt.Sprintf("scard: error(0x%08X)", uint32(e))
+}
+
+// OpError is the error returned by the methods of Context and Card. It
+// records the operation and, where known, the reader it was issued for.
+//
+// The underlying Error code can be tested for with errors.Is, e.g.
+// errors.Is(err, ErrResetCard), or extracted with errors.As.
+type OpError struct {
+	// Op is the name of the method that failed, e.g. "Transmit".
+	Op string
+	// Reader is the name of the reader, or empty if the operation is not
+	// specific to one.
+	Reader string
+	// Err is the error reported by the Backend, usually an Error code.
+	Err error
+}
+
+func (e *OpError) Error() string {
+	s := "scard: " + e.Op
+	if e.Reader != "" {
+		s += " " + strconv.Quote(e.Reader)
+	}
+	return s + ": " + strings.TrimPrefix(e.Err.Error(), "scard: ")
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+func opError(op, reader string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Reader: reader, Err: err}
+}
+
+// errorCode returns the Error code in the chain of err, or ErrSuccess if
+// there is none.
+func errorCode(err error) Error {
+	var code Error
+	if errors.As(err, &code) {
+		return code
+	}
+	return ErrSuccess
+}
+
+// IsTransient reports whether err is a temporary condition, after which
+// the operation may succeed if it is tried again, after reconnecting the
+// card in case of ErrResetCard or ErrUnpoweredCard.
+func IsTransient(err error) bool {
+	switch errorCode(err) {
+	case ErrTimeout, ErrSharingViolation, ErrNotReady, ErrServerTooBusy,
+		ErrCommDataLost, ErrResetCard, ErrUnpoweredCard:
+		return true
+	}
+	return false
+}
+
+// IsCardGone reports whether err means that the card is no longer
+// available, because it or its reader was removed.
+func IsCardGone(err error) bool {
+	switch errorCode(err) {
+	case ErrRemovedCard, ErrNoSmartcard, ErrReaderUnavailable:
+		return true
+	}
+	return false
+}
+
+// IsServiceDown reports whether err means that the PC/SC service (pcscd,
+// the PCSC framework or the Smart Card service) is not running or went away.
+// A new Context has to be established once it is available again.
+func IsServiceDown(err error) bool {
+	switch errorCode(err) {
+	case ErrNoService, ErrServiceStopped, ErrShutdown:
+		return true
+	}
+	return false
+}
+
+// IsPermission reports whether err means that access was denied, to the
+// service, the reader or data on the card.
+func IsPermission(err error) bool {
+	switch errorCode(err) {
+	case ErrNoAccess, ErrSecurityViolation, ErrCardNotAuthenticated:
+		return true
+	}
+	return false
+}
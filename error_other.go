@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package scard
+
+// errWinUnsupportedFeature is SCARD_E_UNSUPPORTED_FEATURE of winscard. It
+// is not used by pcsc-lite.
+const errWinUnsupportedFeature Error = 0x80100022
+
+func sysErrorText(e Error) (string, bool) {
+	return "", false
+}
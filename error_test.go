@@ -0,0 +1,156 @@
+package scard
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestErrorTexts(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "zconst.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every Error constant in zconst.go has a name and description
+	n := 0
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if id, ok := vs.Type.(*ast.Ident); !ok || id.Name != "Error" {
+				continue
+			}
+			v, err := strconv.ParseUint(vs.Values[0].(*ast.BasicLit).Value, 0, 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := Error(v)
+			if !strings.HasPrefix(e.Name(), "SCARD_") {
+				t.Errorf("%s.Name() = %q", vs.Names[0], e.Name())
+			}
+			if strings.HasPrefix(e.Error(), "scard: error(") {
+				t.Errorf("%s.Error() = %q", vs.Names[0], e.Error())
+			}
+			n++
+		}
+	}
+	if n < 50 {
+		t.Fatalf("found %d Error constants in zconst.go", n)
+	}
+
+	if got, want := ErrNoSmartcard.Name(), "SCARD_E_NO_SMARTCARD"; got != want {
+		t.Errorf("Name() = %q; want %q", got, want)
+	}
+	if got, want := ErrResetCard.Error(), "scard: Card was reset."; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+	if got, want := Error(0x80100099).Name(), "0x80100099"; got != want {
+		t.Errorf("Name() = %q; want %q", got, want)
+	}
+	if got, want := Error(0x80100022).Name(), "SCARD_E_UNSUPPORTED_FEATURE"; got != want {
+		t.Errorf("Name() = %q; want %q", got, want)
+	}
+	if runtime.GOOS == "windows" {
+		// described by the system
+		if got, prefix := Error(50).Error(), "scard: error(0x00000032): "; !strings.HasPrefix(got, prefix) || got == prefix {
+			t.Errorf("Error() = %q; want %q and the system description", got, prefix)
+		}
+	} else if got, want := Error(1).Error(), "scard: error(0x00000001)"; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+}
+
+func TestOpError(t *testing.T) {
+	fake := newFakeBackend()
+	fake.transmit = func(cmd []byte) ([]byte, error) {
+		return nil, ErrResetCard
+	}
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(fake.reader, ShareShared, ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = card.Transmit([]byte{0x00, 0xa4, 0x04, 0x00})
+	if !errors.Is(err, ErrResetCard) {
+		t.Fatalf("Transmit() = %v; want %v", err, ErrResetCard)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Transmit() = %T; want *OpError", err)
+	}
+	if opErr.Op != "Transmit" || opErr.Reader != fake.reader || opErr.Err != ErrResetCard {
+		t.Fatalf("OpError = %+v", opErr)
+	}
+
+	_, err = card.GetAttrib(AttrVendorName)
+	if !errors.As(err, &opErr) || opErr.Op != "GetAttrib" || opErr.Reader != fake.reader {
+		t.Fatalf("GetAttrib() = %v", err)
+	}
+}
+
+func TestOpErrorString(t *testing.T) {
+	err := &OpError{Op: "Transmit", Reader: "Reader 00 00", Err: errors.New("scard: failed")}
+	if got, want := err.Error(), `scard: Transmit "Reader 00 00": failed`; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+	err = &OpError{Op: "ListReaders", Err: errors.New("failed")}
+	if got, want := err.Error(), `scard: ListReaders: failed`; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err                                    error
+		transient, cardGone, serviceDown, perm bool
+	}{
+		{ErrResetCard, true, false, false, false},
+		{ErrSharingViolation, true, false, false, false},
+		{ErrTimeout, true, false, false, false},
+		{ErrRemovedCard, false, true, false, false},
+		{ErrNoSmartcard, false, true, false, false},
+		{ErrReaderUnavailable, false, true, false, false},
+		{ErrNoService, false, false, true, false},
+		{ErrServiceStopped, false, false, true, false},
+		{ErrNoAccess, false, false, false, true},
+		{ErrSecurityViolation, false, false, false, true},
+		{ErrInvalidParameter, false, false, false, false},
+		{errors.New("other"), false, false, false, false},
+		{nil, false, false, false, false},
+	}
+	for _, tt := range tests {
+		errs := []error{tt.err}
+		if tt.err != nil {
+			errs = append(errs, &OpError{Op: "Transmit", Err: tt.err}, fmt.Errorf("wrapped: %w", tt.err))
+		}
+		for _, err := range errs {
+			if got := IsTransient(err); got != tt.transient {
+				t.Errorf("IsTransient(%v) = %v", err, got)
+			}
+			if got := IsCardGone(err); got != tt.cardGone {
+				t.Errorf("IsCardGone(%v) = %v", err, got)
+			}
+			if got := IsServiceDown(err); got != tt.serviceDown {
+				t.Errorf("IsServiceDown(%v) = %v", err, got)
+			}
+			if got := IsPermission(err); got != tt.perm {
+				t.Errorf("IsPermission(%v) = %v", err, got)
+			}
+		}
+	}
+}
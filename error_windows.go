@@ -0,0 +1,15 @@
+package scard
+
+import (
+	"syscall"
+)
+
+// errWinUnsupportedFeature is SCARD_E_UNSUPPORTED_FEATURE of winscard,
+// which uses 0x8010001F for SCARD_E_UNEXPECTED instead.
+const errWinUnsupportedFeature Error = 0x80100022
+
+// sysErrorText returns the system description of codes not in errorTexts,
+// such as the Win32 error codes some winscard functions return.
+func sysErrorText(e Error) (string, bool) {
+	return syscall.Errno(e).Error(), true
+}
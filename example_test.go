@@ -1,8 +1,10 @@
 package scard_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/ebfe/scard"
+	"github.com/ebfe/scard/apdu"
 	"os"
 )
 
@@ -74,17 +76,39 @@ func Example() {
 			die(err)
 		}
 
-		fmt.Printf("\treader: %s\n\tstate: %x\n\tactive protocol: %x\n\tatr: % x\n",
+		fmt.Printf("\treader: %s\n\tstate: %v\n\tactive protocol: %v\n\tatr: % x\n",
 			status.Reader, status.State, status.ActiveProtocol, status.Atr)
 
-		var cmd = []byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00} // SELECT MF
+		// SELECT MF
+		cmd := apdu.Command{CLA: 0x00, INS: 0xa4, P1: 0x00, P2: 0x0c, Data: []byte{0x3f, 0x00}}
 
 		fmt.Println("Transmit:")
-		fmt.Printf("\tc-apdu: % x\n", cmd)
-		rsp, err := card.Transmit(cmd)
+		fmt.Printf("\tc-apdu: %v\n", cmd)
+		rsp, err := card.Do(cmd)
 		if err != nil {
 			die(err)
 		}
-		fmt.Printf("\tr-apdu: % x\n", rsp)
+		fmt.Printf("\tr-apdu: %v\n", rsp)
+	}
+}
+
+func ExampleNewMonitor() {
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		die(err)
+	}
+	defer ctx.Release()
+
+	m := scard.NewMonitor(context.Background(), ctx)
+	for ev := range m.C {
+		switch ev.Type {
+		case scard.CardInserted:
+			fmt.Printf("%s: card inserted, atr: % x\n", ev.Reader, ev.Atr)
+		default:
+			fmt.Printf("%s: %v\n", ev.Reader, ev.Type)
+		}
+	}
+	if err := m.Err(); err != nil {
+		die(err)
 	}
 }
@@ -0,0 +1,287 @@
+package scard
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ebfe/scard/internal/hexbytes"
+)
+
+type flagName struct {
+	flag uint32
+	name string
+}
+
+var stateNames = []flagName{
+	{uint32(Unknown), "Unknown"},
+	{uint32(Absent), "Absent"},
+	{uint32(Present), "Present"},
+	{uint32(Swallowed), "Swallowed"},
+	{uint32(Powered), "Powered"},
+	{uint32(Negotiable), "Negotiable"},
+	{uint32(Specific), "Specific"},
+}
+
+var stateFlagNames = []flagName{
+	{uint32(StateIgnore), "Ignore"},
+	{uint32(StateChanged), "Changed"},
+	{uint32(StateUnknown), "Unknown"},
+	{uint32(StateUnavailable), "Unavailable"},
+	{uint32(StateEmpty), "Empty"},
+	{uint32(StatePresent), "Present"},
+	{uint32(StateAtrmatch), "Atrmatch"},
+	{uint32(StateExclusive), "Exclusive"},
+	{uint32(StateInuse), "Inuse"},
+	{uint32(StateMute), "Mute"},
+	{uint32(StateUnpowered), "Unpowered"},
+}
+
+var protocolNames = []flagName{
+	{uint32(ProtocolT0), "T0"},
+	{uint32(ProtocolT1), "T1"},
+	{uint32(ProtocolRaw), "Raw"},
+	{uint32(ProtocolT15), "T15"},
+}
+
+var shareModeNames = []flagName{
+	{uint32(ShareExclusive), "Exclusive"},
+	{uint32(ShareShared), "Shared"},
+	{uint32(ShareDirect), "Direct"},
+}
+
+var dispositionNames = []flagName{
+	{uint32(LeaveCard), "LeaveCard"},
+	{uint32(ResetCard), "ResetCard"},
+	{uint32(UnpowerCard), "UnpowerCard"},
+	{uint32(EjectCard), "EjectCard"},
+}
+
+// formatFlags returns the names of the flags set in v separated by "|".
+// Bits without a name are appended in hex.
+func formatFlags(v uint32, names []flagName, zero string) string {
+	if v == 0 {
+		return zero
+	}
+	var parts []string
+	for _, f := range names {
+		if v&f.flag != 0 {
+			parts = append(parts, f.name)
+			v &^= f.flag
+		}
+	}
+	if v != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", v))
+	}
+	return strings.Join(parts, "|")
+}
+
+// parseFlags parses the output of formatFlags.
+func parseFlags(typ, s string, names []flagName, zero string) (uint32, error) {
+	if s == zero {
+		return 0, nil
+	}
+	var v uint32
+	for _, part := range strings.Split(s, "|") {
+		f, err := parseName(typ, part, names)
+		if err != nil {
+			return 0, err
+		}
+		v |= f
+	}
+	return v, nil
+}
+
+func formatName(typ string, v uint32, names []flagName) string {
+	for _, f := range names {
+		if f.flag == v {
+			return f.name
+		}
+	}
+	return fmt.Sprintf("%s(%d)", typ, v)
+}
+
+// parseName parses a name of names or a number.
+func parseName(typ, s string, names []flagName) (uint32, error) {
+	for _, f := range names {
+		if f.name == s {
+			return f.flag, nil
+		}
+	}
+	if v, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(s, typ+"("), ")"), 0, 32); err == nil {
+		return uint32(v), nil
+	}
+	return 0, fmt.Errorf("scard: invalid %s %q", typ, s)
+}
+
+// String returns the set flags, e.g. "Present|Powered|Specific".
+func (s State) String() string {
+	return formatFlags(uint32(s), stateNames, "0")
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	v, err := parseFlags("State", string(text), stateNames, "0")
+	*s = State(v)
+	return err
+}
+
+// eventCountShift is the position of the event counter pcsc-lite keeps in
+// the upper 16 bits of the event state.
+const eventCountShift = 16
+
+// EventCount returns the number of card insertions and removals pcsc-lite
+// counted for the reader, stored in the upper 16 bits of the event state.
+// It is always zero on Windows and macOS.
+func (f StateFlag) EventCount() int {
+	return int(f >> eventCountShift)
+}
+
+// WithEventCount returns f with the event counter set to n.
+func (f StateFlag) WithEventCount(n int) StateFlag {
+	return f&(1<<eventCountShift-1) | StateFlag(n)<<eventCountShift
+}
+
+// String returns the set flags and the event counter, if not zero, e.g.
+// "Changed|Present|Inuse|EventCount=3".
+func (f StateFlag) String() string {
+	s := formatFlags(uint32(f&(1<<eventCountShift-1)), stateFlagNames, "Unaware")
+	if n := f.EventCount(); n != 0 {
+		s += "|EventCount=" + strconv.Itoa(n)
+	}
+	return s
+}
+
+func (f StateFlag) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *StateFlag) UnmarshalText(text []byte) error {
+	s := string(text)
+	n := 0
+	if i := strings.LastIndex(s, "|EventCount="); i >= 0 {
+		var err error
+		if n, err = strconv.Atoi(s[i+len("|EventCount="):]); err != nil || n < 0 || n > 0xffff {
+			return fmt.Errorf("scard: invalid StateFlag %q", s)
+		}
+		s = s[:i]
+	}
+	v, err := parseFlags("StateFlag", s, stateFlagNames, "Unaware")
+	if err != nil {
+		return err
+	}
+	*f = StateFlag(v).WithEventCount(n)
+	return nil
+}
+
+// String returns the protocols, e.g. "T0|T1" for ProtocolAny.
+func (p Protocol) String() string {
+	return formatFlags(uint32(p), protocolNames, "Undefined")
+}
+
+func (p Protocol) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Protocol) UnmarshalText(text []byte) error {
+	v, err := parseFlags("Protocol", string(text), protocolNames, "Undefined")
+	*p = Protocol(v)
+	return err
+}
+
+// UnmarshalJSON accepts the text form as well as a number, as written by
+// versions that did not implement MarshalText.
+func (p *Protocol) UnmarshalJSON(data []byte) error {
+	var v uint32
+	if err := json.Unmarshal(data, &v); err == nil {
+		*p = Protocol(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return p.UnmarshalText([]byte(s))
+}
+
+func (m ShareMode) String() string {
+	return formatName("ShareMode", uint32(m), shareModeNames)
+}
+
+func (m ShareMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *ShareMode) UnmarshalText(text []byte) error {
+	v, err := parseName("ShareMode", string(text), shareModeNames)
+	*m = ShareMode(v)
+	return err
+}
+
+func (d Disposition) String() string {
+	return formatName("Disposition", uint32(d), dispositionNames)
+}
+
+func (d Disposition) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Disposition) UnmarshalText(text []byte) error {
+	v, err := parseName("Disposition", string(text), dispositionNames)
+	*d = Disposition(v)
+	return err
+}
+
+type cardStatusJSON struct {
+	Reader         string         `json:"reader"`
+	State          State          `json:"state"`
+	ActiveProtocol Protocol       `json:"activeProtocol"`
+	Atr            hexbytes.Bytes `json:"atr"`
+}
+
+// MarshalJSON encodes the status with the flags as strings and the ATR in
+// hex.
+func (s CardStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cardStatusJSON{s.Reader, s.State, s.ActiveProtocol, s.Atr})
+}
+
+func (s *CardStatus) UnmarshalJSON(data []byte) error {
+	var v cardStatusJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = CardStatus{v.Reader, v.State, v.ActiveProtocol, v.Atr}
+	return nil
+}
+
+type readerStateJSON struct {
+	Reader       string         `json:"reader"`
+	CurrentState StateFlag      `json:"currentState"`
+	EventState   StateFlag      `json:"eventState"`
+	EventCount   int            `json:"eventCount"`
+	Atr          hexbytes.Bytes `json:"atr,omitempty"`
+}
+
+// MarshalJSON encodes the reader state with the flags as strings, the event
+// counter as a separate number and the ATR in hex. UserData is not encoded.
+func (rs ReaderState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(readerStateJSON{rs.Reader, rs.CurrentState, rs.EventState, rs.EventState.EventCount(), rs.Atr})
+}
+
+// UnmarshalJSON decodes the output of MarshalJSON, leaving UserData
+// unchanged.
+func (rs *ReaderState) UnmarshalJSON(data []byte) error {
+	var v readerStateJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	rs.Reader = v.Reader
+	rs.CurrentState = v.CurrentState
+	rs.EventState = v.EventState
+	rs.Atr = v.Atr
+	return nil
+}
@@ -0,0 +1,140 @@
+package scard
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestFlagStrings(t *testing.T) {
+	tests := []struct {
+		v    interface{ String() string }
+		want string
+	}{
+		{Present | Powered | Specific, "Present|Powered|Specific"},
+		{State(0), "0"},
+		{Absent | State(0x100), "Absent|0x100"},
+		{StateUnaware, "Unaware"},
+		{StateChanged | StatePresent | StateInuse, "Changed|Present|Inuse"},
+		{(StateChanged | StateEmpty).WithEventCount(3), "Changed|Empty|EventCount=3"},
+		{ProtocolUndefined, "Undefined"},
+		{ProtocolAny, "T0|T1"},
+		{ProtocolRaw, "Raw"},
+		{ShareDirect, "Direct"},
+		{ShareMode(7), "ShareMode(7)"},
+		{LeaveCard, "LeaveCard"},
+		{EjectCard, "EjectCard"},
+	}
+	for _, tt := range tests {
+		if got := tt.v.String(); got != tt.want {
+			t.Errorf("%T.String() = %q; want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFlagText(t *testing.T) {
+	states := []State{0, Present | Powered | Negotiable, Absent | State(0x100)}
+	for _, v := range states {
+		text, _ := v.MarshalText()
+		var got State
+		if err := got.UnmarshalText(text); err != nil || got != v {
+			t.Errorf("State.UnmarshalText(%q) = %#x, %v; want %#x", text, uint32(got), err, uint32(v))
+		}
+	}
+
+	flags := []StateFlag{StateUnaware, StateChanged | StatePresent, StateMute.WithEventCount(0xffff)}
+	for _, v := range flags {
+		text, _ := v.MarshalText()
+		var got StateFlag
+		if err := got.UnmarshalText(text); err != nil || got != v {
+			t.Errorf("StateFlag.UnmarshalText(%q) = %#x, %v; want %#x", text, uint32(got), err, uint32(v))
+		}
+	}
+
+	for _, v := range []Protocol{ProtocolUndefined, ProtocolAny, ProtocolT15} {
+		text, _ := v.MarshalText()
+		var got Protocol
+		if err := got.UnmarshalText(text); err != nil || got != v {
+			t.Errorf("Protocol.UnmarshalText(%q) = %#x, %v; want %#x", text, uint32(got), err, uint32(v))
+		}
+	}
+
+	var m ShareMode
+	if err := m.UnmarshalText([]byte("Exclusive")); err != nil || m != ShareExclusive {
+		t.Errorf("ShareMode.UnmarshalText(Exclusive) = %d, %v", uint32(m), err)
+	}
+	var d Disposition
+	if err := d.UnmarshalText([]byte("UnpowerCard")); err != nil || d != UnpowerCard {
+		t.Errorf("Disposition.UnmarshalText(UnpowerCard) = %d, %v", uint32(d), err)
+	}
+
+	var s State
+	if err := s.UnmarshalText([]byte("Present|Bogus")); err == nil {
+		t.Errorf("State.UnmarshalText(Present|Bogus) succeeded")
+	}
+	var f StateFlag
+	if err := f.UnmarshalText([]byte("Present|EventCount=x")); err == nil {
+		t.Errorf("StateFlag.UnmarshalText(Present|EventCount=x) succeeded")
+	}
+}
+
+func TestProtocolJSONNumber(t *testing.T) {
+	var p Protocol
+	if err := json.Unmarshal([]byte(`2`), &p); err != nil || p != ProtocolT1 {
+		t.Errorf("Unmarshal(2) = %v, %v", p, err)
+	}
+	if err := json.Unmarshal([]byte(`"T0|T1"`), &p); err != nil || p != ProtocolAny {
+		t.Errorf(`Unmarshal("T0|T1") = %v, %v`, p, err)
+	}
+}
+
+func TestCardStatusJSON(t *testing.T) {
+	status := CardStatus{
+		Reader:         "Reader 00 00",
+		State:          Present | Powered | Specific,
+		ActiveProtocol: ProtocolT1,
+		Atr:            []byte{0x3b, 0x80, 0x80, 0x01, 0x01},
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"reader":"Reader 00 00","state":"Present|Powered|Specific","activeProtocol":"T1","atr":"3b80800101"}`
+	if string(data) != want {
+		t.Fatalf("Marshal() = %s; want %s", data, want)
+	}
+
+	var got CardStatus
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Reader != status.Reader || got.State != status.State || got.ActiveProtocol != status.ActiveProtocol || !bytes.Equal(got.Atr, status.Atr) {
+		t.Fatalf("Unmarshal() = %+v; want %+v", got, status)
+	}
+}
+
+func TestReaderStateJSON(t *testing.T) {
+	rs := ReaderState{
+		Reader:       "Reader 00 00",
+		UserData:     42,
+		CurrentState: StateEmpty.WithEventCount(1),
+		EventState:   (StateChanged | StatePresent).WithEventCount(2),
+		Atr:          []byte{0x3b, 0x00},
+	}
+	data, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"reader":"Reader 00 00","currentState":"Empty|EventCount=1","eventState":"Changed|Present|EventCount=2","eventCount":2,"atr":"3b00"}`
+	if string(data) != want {
+		t.Fatalf("Marshal() = %s; want %s", data, want)
+	}
+
+	var got ReaderState
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Reader != rs.Reader || got.CurrentState != rs.CurrentState || got.EventState != rs.EventState || !bytes.Equal(got.Atr, rs.Atr) || got.UserData != nil {
+		t.Fatalf("Unmarshal() = %+v; want %+v", got, rs)
+	}
+}
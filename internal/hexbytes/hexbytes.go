@@ -0,0 +1,25 @@
+// Package hexbytes implements a byte slice that is marshalled as a hex
+// string, shared by the JSON encodings of scard and trace.
+package hexbytes
+
+import (
+	"encoding/hex"
+)
+
+// Bytes is a byte slice that is marshalled as a hex string.
+type Bytes []byte
+
+func (h Bytes) MarshalText() ([]byte, error) {
+	b := make([]byte, hex.EncodedLen(len(h)))
+	hex.Encode(b, h)
+	return b, nil
+}
+
+func (h *Bytes) UnmarshalText(text []byte) error {
+	b := make([]byte, hex.DecodedLen(len(text)))
+	if _, err := hex.Decode(b, text); err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
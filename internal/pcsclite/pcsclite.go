@@ -0,0 +1,326 @@
+// Package pcsclite implements the messages exchanged between libpcsclite
+// and pcscd over the pcscd UNIX domain socket.
+//
+// Every request starts with a Header followed by a fixed size message
+// struct and, for some commands, a variable length payload. Replies carry
+// the same struct (without a header) and an optional payload. All integers
+// are in host byte order; only little endian hosts are supported.
+package pcsclite
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// Protocol version spoken by this package.
+const (
+	ProtocolVersionMajor = 4
+	ProtocolVersionMinor = 4
+)
+
+// Command codes (enum pcsc_msg_commands in winscard_msg.h).
+const (
+	CmdEstablishContext             = 0x01
+	CmdReleaseContext               = 0x02
+	CmdListReaders                  = 0x03
+	CmdConnect                      = 0x04
+	CmdReconnect                    = 0x05
+	CmdDisconnect                   = 0x06
+	CmdBeginTransaction             = 0x07
+	CmdEndTransaction               = 0x08
+	CmdTransmit                     = 0x09
+	CmdControl                      = 0x0a
+	CmdStatus                       = 0x0b
+	CmdGetStatusChange              = 0x0c
+	CmdCancel                       = 0x0d
+	CmdCancelTransaction            = 0x0e
+	CmdGetAttrib                    = 0x0f
+	CmdSetAttrib                    = 0x10
+	CmdVersion                      = 0x11
+	CmdGetReadersState              = 0x12
+	CmdWaitReaderStateChange        = 0x13
+	CmdStopWaitingReaderStateChange = 0x14
+)
+
+// Sizes fixed by the protocol.
+const (
+	MaxReaderName         = 128
+	MaxAtrSize            = 33
+	MaxBufferSize         = 264
+	MaxBufferSizeExtended = 4 + 3 + (1 << 16) + 3 + 2
+	MaxReaders            = 16 // PCSCLITE_MAX_READERS_CONTEXTS
+)
+
+// IORequestSize is sizeof(SCARD_IO_REQUEST), two unsigned longs. libpcsclite
+// sends it as the PCI lengths of Transmit and pcscd echoes it.
+const IORequestSize = 2 * unsafe.Sizeof(uintptr(0))
+
+// Return codes used by the server side. They match the scard.ErrXxx values.
+const (
+	Success               = 0x00000000
+	ErrInternalError      = 0x80100001
+	ErrCancelled          = 0x80100002
+	ErrInvalidHandle      = 0x80100003
+	ErrInvalidParameter   = 0x80100004
+	ErrInvalidValue       = 0x80100011
+	ErrInsufficientBuffer = 0x80100008
+	ErrUnknownReader      = 0x80100009
+	ErrTimeout            = 0x8010000a
+	ErrSharingViolation   = 0x8010000b
+	ErrNoSmartcard        = 0x8010000c
+	ErrProtoMismatch      = 0x8010000f
+	ErrCommError          = 0x80100013
+	ErrNotTransacted      = 0x80100016
+	ErrReaderUnavailable  = 0x80100017
+	ErrNoService          = 0x8010001d
+	ErrServiceStopped     = 0x8010001e
+	ErrUnsupportedFeature = 0x8010001f
+	ErrNoReadersAvailable = 0x8010002e
+	ErrUnresponsiveCard   = 0x80100066
+	ErrUnpoweredCard      = 0x80100067
+	ErrResetCard          = 0x80100068
+	ErrRemovedCard        = 0x80100069
+)
+
+// Reader state bits stored in ReaderState.State (SCARD_ABSENT etc.).
+const (
+	StateUnknown    = 0x0001
+	StateAbsent     = 0x0002
+	StatePresent    = 0x0004
+	StateSwallowed  = 0x0008
+	StatePowered    = 0x0010
+	StateNegotiable = 0x0020
+	StateSpecific   = 0x0040
+)
+
+// Values of ReaderState.Sharing.
+const (
+	SharingExclusive = -1
+	SharingNone      = 0
+)
+
+// DefaultSocket is the path of the pcscd socket unless overridden by the
+// PCSCLITE_CSOCK_NAME environment variable.
+const DefaultSocket = "/run/pcscd/pcscd.comm"
+
+// SocketPath returns the pcscd socket path, honoring PCSCLITE_CSOCK_NAME.
+func SocketPath() string {
+	if p := os.Getenv("PCSCLITE_CSOCK_NAME"); p != "" {
+		return p
+	}
+	return DefaultSocket
+}
+
+var byteOrder = binary.LittleEndian
+
+// Header precedes every request (struct rxHeader).
+type Header struct {
+	Size    uint32
+	Command uint32
+}
+
+type Version struct {
+	Major int32
+	Minor int32
+	Rv    uint32
+}
+
+type EstablishContext struct {
+	Scope    uint32
+	HContext uint32
+	Rv       uint32
+}
+
+type ReleaseContext struct {
+	HContext uint32
+	Rv       uint32
+}
+
+type Connect struct {
+	HContext           uint32
+	Reader             [MaxReaderName]byte
+	ShareMode          uint32
+	PreferredProtocols uint32
+	HCard              int32
+	ActiveProtocol     uint32
+	Rv                 uint32
+}
+
+type Reconnect struct {
+	HCard              int32
+	ShareMode          uint32
+	PreferredProtocols uint32
+	Initialization     uint32
+	ActiveProtocol     uint32
+	Rv                 uint32
+}
+
+type Disconnect struct {
+	HCard       int32
+	Disposition uint32
+	Rv          uint32
+}
+
+type BeginTransaction struct {
+	HCard int32
+	Rv    uint32
+}
+
+type EndTransaction struct {
+	HCard       int32
+	Disposition uint32
+	Rv          uint32
+}
+
+type Cancel struct {
+	HContext int32
+	Rv       uint32
+}
+
+type Status struct {
+	HCard int32
+	Rv    uint32
+}
+
+// Transmit is followed by SendLength bytes of command in the request and,
+// on success, RecvLength bytes of response in the reply.
+type Transmit struct {
+	HCard           int32
+	SendPciProtocol uint32
+	SendPciLength   uint32
+	SendLength      uint32
+	RecvPciProtocol uint32
+	RecvPciLength   uint32
+	RecvLength      uint32
+	Rv              uint32
+}
+
+// Control is followed by SendLength bytes of input in the request and, on
+// success, BytesReturned bytes of output in the reply.
+type Control struct {
+	HCard         int32
+	ControlCode   uint32
+	SendLength    uint32
+	RecvLength    uint32
+	BytesReturned uint32
+	Rv            uint32
+}
+
+// GetSetAttrib is used by both CmdGetAttrib and CmdSetAttrib.
+type GetSetAttrib struct {
+	HCard   int32
+	AttrID  uint32
+	Attr    [MaxBufferSize]byte
+	AttrLen uint32
+	Rv      uint32
+}
+
+// WaitReaderStateChange is sent by the server when a reader changes or a
+// wait is cancelled, and in reply to CmdStopWaitingReaderStateChange, in
+// which case Timeout is echoed from the request.
+type WaitReaderStateChange struct {
+	Timeout uint32
+	Rv      uint32
+}
+
+// ReaderState is one entry of the reader state table the server sends in
+// reply to CmdGetReadersState and CmdWaitReaderStateChange (struct
+// pubReaderStatesList). An empty Name marks an unused slot.
+type ReaderState struct {
+	Name         [MaxReaderName]byte
+	EventCounter uint32
+	State        uint32
+	Sharing      int32
+	Atr          [MaxAtrSize]byte
+	_            [3]byte
+	AtrLength    uint32
+	Protocol     uint32
+}
+
+// ReaderName returns the reader name as a Go string.
+func (rs *ReaderState) ReaderName() string {
+	return CString(rs.Name[:])
+}
+
+// ReaderStates is the full reader state table.
+type ReaderStates [MaxReaders]ReaderState
+
+// CString decodes a NUL terminated string.
+func CString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// Size returns the encoded size of msg.
+func Size(msg interface{}) uint32 {
+	return uint32(binary.Size(msg))
+}
+
+// WriteRequest writes a header for cmd, msg and payload to w. msg may be
+// nil for commands without a body.
+func WriteRequest(w io.Writer, cmd uint32, msg interface{}, payload []byte) error {
+	var size uint32
+	if msg != nil {
+		size = Size(msg)
+	}
+	buf := make([]byte, 0, 8+int(size)+len(payload))
+	buf = byteOrder.AppendUint32(buf, size)
+	buf = byteOrder.AppendUint32(buf, cmd)
+	if msg != nil {
+		var err error
+		buf, err = appendMessage(buf, msg)
+		if err != nil {
+			return err
+		}
+	}
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WriteReply writes msg followed by payload to w.
+func WriteReply(w io.Writer, msg interface{}, payload []byte) error {
+	buf, err := appendMessage(nil, msg)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, payload...)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadHeader reads a request header from r.
+func ReadHeader(r io.Reader) (Header, error) {
+	var h Header
+	err := binary.Read(r, byteOrder, &h)
+	return h, err
+}
+
+// ReadMessage reads a fixed size message into msg, which must be a
+// pointer to one of the message types.
+func ReadMessage(r io.Reader, msg interface{}) error {
+	return binary.Read(r, byteOrder, msg)
+}
+
+func appendMessage(buf []byte, msg interface{}) ([]byte, error) {
+	w := appendWriter{buf}
+	if err := binary.Write(&w, byteOrder, msg); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
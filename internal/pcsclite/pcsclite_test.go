@@ -0,0 +1,74 @@
+package pcsclite
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sizes of the corresponding C structs in winscard_msg.h and eventhandler.h
+func TestMessageSizes(t *testing.T) {
+	tests := []struct {
+		msg  interface{}
+		size uint32
+	}{
+		{Header{}, 8},
+		{Version{}, 12},
+		{EstablishContext{}, 12},
+		{ReleaseContext{}, 8},
+		{Connect{}, 152},
+		{Reconnect{}, 24},
+		{Disconnect{}, 12},
+		{BeginTransaction{}, 8},
+		{EndTransaction{}, 12},
+		{Cancel{}, 8},
+		{Status{}, 8},
+		{Transmit{}, 32},
+		{Control{}, 24},
+		{GetSetAttrib{}, 280},
+		{WaitReaderStateChange{}, 8},
+		{ReaderState{}, 184},
+		{ReaderStates{}, 184 * MaxReaders},
+	}
+
+	for _, tt := range tests {
+		if got := Size(tt.msg); got != tt.size {
+			t.Errorf("Size(%T) = %d; want %d", tt.msg, got, tt.size)
+		}
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	tx := Transmit{HCard: 7, SendPciProtocol: 2, SendPciLength: 8, SendLength: 3, RecvLength: 258}
+	if err := WriteRequest(&buf, CmdTransmit, &tx, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Command != CmdTransmit || h.Size != Size(tx) {
+		t.Fatalf("header = %+v", h)
+	}
+
+	var got Transmit
+	if err := ReadMessage(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != tx {
+		t.Fatalf("got %+v; want %+v", got, tx)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{1, 2, 3}) {
+		t.Fatalf("payload = % x", buf.Bytes())
+	}
+}
+
+func TestReaderName(t *testing.T) {
+	var rs ReaderState
+	copy(rs.Name[:], "Virtual Reader 00 00")
+	if got := rs.ReaderName(); got != "Virtual Reader 00 00" {
+		t.Fatalf("ReaderName() = %q", got)
+	}
+}
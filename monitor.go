@@ -0,0 +1,242 @@
+package scard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// EventType is the kind of an Event reported by a Monitor.
+type EventType int
+
+const (
+	// ReaderAdded reports a reader that appeared, or was present when the
+	// Monitor started.
+	ReaderAdded EventType = iota + 1
+	// ReaderRemoved reports a reader that went away.
+	ReaderRemoved
+	// CardInserted reports a card inserted into the reader; Event.Atr is
+	// set.
+	CardInserted
+	// CardRemoved reports a card removed from the reader.
+	CardRemoved
+	// ReaderMute reports an unresponsive card in the reader.
+	ReaderMute
+	// ExclusiveInUse reports that the card was connected to in exclusive
+	// mode by some application.
+	ExclusiveInUse
+)
+
+var eventTypeNames = map[EventType]string{
+	ReaderAdded:    "ReaderAdded",
+	ReaderRemoved:  "ReaderRemoved",
+	CardInserted:   "CardInserted",
+	CardRemoved:    "CardRemoved",
+	ReaderMute:     "ReaderMute",
+	ExclusiveInUse: "ExclusiveInUse",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a change reported by a Monitor.
+type Event struct {
+	Type   EventType
+	Reader string
+	Atr    []byte
+}
+
+// Monitor watches the readers of a Context and the cards in them.
+//
+// It runs the GetStatusChange loop, waiting on the PnPNotification pseudo
+// reader and rescanning ListReaders when readers come and go. The readers
+// present at start are reported with ReaderAdded, followed by CardInserted
+// for the cards in them.
+type Monitor struct {
+	// C delivers the events. It is closed when the Monitor stops.
+	C <-chan Event
+
+	ctx    *Context
+	c      context.Context
+	events chan Event
+	states []ReaderState
+	err    error
+}
+
+// NewMonitor starts monitoring the readers of ctx. The Monitor stops when
+// c is done or ctx fails; ctx must not be used for other calls that could
+// block in the meantime.
+func NewMonitor(c context.Context, ctx *Context) *Monitor {
+	events := make(chan Event)
+	m := &Monitor{
+		C:      events,
+		ctx:    ctx,
+		c:      c,
+		events: events,
+		states: []ReaderState{{Reader: PnPNotification}},
+	}
+	go m.run()
+	return m
+}
+
+// Err returns the error that stopped the Monitor, or nil if it was stopped
+// by its context. It must only be called after C was closed.
+func (m *Monitor) Err() error {
+	return m.err
+}
+
+func (m *Monitor) run() {
+	defer close(m.events)
+
+	err := m.rescan()
+	for err == nil {
+		err = m.ctx.GetStatusChangeContext(m.c, m.states)
+		switch code := errorCode(err); {
+		case err == nil:
+			err = m.update()
+		case code == ErrTimeout:
+			err = nil
+		case code == ErrUnknownReader, code == ErrReaderUnavailable:
+			// a reader went away before we were notified
+			err = m.rescan()
+		}
+	}
+
+	if m.c.Err() != nil && errors.Is(err, m.c.Err()) {
+		err = nil
+	}
+	m.err = err
+}
+
+func (m *Monitor) emit(ev Event) error {
+	select {
+	case m.events <- ev:
+		return nil
+	case <-m.c.Done():
+		return m.c.Err()
+	}
+}
+
+// rescan updates the monitored readers from ListReaders.
+func (m *Monitor) rescan() error {
+	readers, err := m.ctx.ListReaders()
+	if err != nil && !errors.Is(err, ErrNoReadersAvailable) {
+		return err
+	}
+
+	present := make(map[string]bool, len(readers))
+	for _, r := range readers {
+		present[r] = true
+	}
+
+	states := m.states[:1]
+	for _, rs := range m.states[1:] {
+		if present[rs.Reader] {
+			delete(present, rs.Reader)
+			states = append(states, rs)
+			continue
+		}
+		if err := m.removed(&rs); err != nil {
+			return err
+		}
+	}
+	m.states = states
+
+	for _, r := range readers {
+		if !present[r] {
+			continue
+		}
+		if err := m.emit(Event{Type: ReaderAdded, Reader: r}); err != nil {
+			return err
+		}
+		m.states = append(m.states, ReaderState{Reader: r, CurrentState: StateUnaware})
+	}
+
+	return nil
+}
+
+// removed reports the removal of the reader of rs.
+func (m *Monitor) removed(rs *ReaderState) error {
+	if rs.CurrentState&StatePresent != 0 {
+		if err := m.emit(Event{Type: CardRemoved, Reader: rs.Reader}); err != nil {
+			return err
+		}
+	}
+	return m.emit(Event{Type: ReaderRemoved, Reader: rs.Reader})
+}
+
+// update reports the changes found by GetStatusChange.
+func (m *Monitor) update() error {
+	rescan := false
+
+	states := m.states[:0]
+	for _, rs := range m.states {
+		if rs.EventState&StateChanged == 0 {
+			states = append(states, rs)
+			continue
+		}
+		prev := rs.CurrentState
+		rs.CurrentState = rs.EventState &^ StateChanged
+
+		switch {
+		case rs.Reader == PnPNotification:
+			rescan = true
+		case rs.EventState&(StateUnknown|StateUnavailable) != 0:
+			rs.CurrentState = prev
+			if err := m.removed(&rs); err != nil {
+				return err
+			}
+			rescan = true
+			continue
+		default:
+			for _, ev := range stateEvents(prev, &rs) {
+				if err := m.emit(ev); err != nil {
+					return err
+				}
+			}
+		}
+		states = append(states, rs)
+	}
+	m.states = states
+
+	if rescan {
+		return m.rescan()
+	}
+	return nil
+}
+
+// stateEvents returns the events for the transition of rs from prev to its
+// EventState.
+func stateEvents(prev StateFlag, rs *ReaderState) []Event {
+	var events []Event
+	cur := rs.EventState
+
+	wasPresent := prev&StatePresent != 0
+	isPresent := cur&StatePresent != 0
+	// a different event count means the card was swapped in between
+	swapped := wasPresent && isPresent && prev.EventCount() != cur.EventCount() && prev.EventCount() != 0
+
+	if wasPresent && (!isPresent || swapped) {
+		events = append(events, Event{Type: CardRemoved, Reader: rs.Reader})
+	}
+	if isPresent && (!wasPresent || swapped) {
+		if cur&StateMute != 0 {
+			events = append(events, Event{Type: ReaderMute, Reader: rs.Reader})
+		} else {
+			events = append(events, Event{Type: CardInserted, Reader: rs.Reader, Atr: append([]byte(nil), rs.Atr...)})
+		}
+	} else if isPresent && cur&StateMute != 0 && prev&StateMute == 0 {
+		events = append(events, Event{Type: ReaderMute, Reader: rs.Reader})
+	} else if isPresent && prev&StateMute != 0 && cur&StateMute == 0 {
+		events = append(events, Event{Type: CardInserted, Reader: rs.Reader, Atr: append([]byte(nil), rs.Atr...)})
+	}
+	if isPresent && cur&StateExclusive != 0 && (prev&StateExclusive == 0 || swapped) {
+		events = append(events, Event{Type: ExclusiveInUse, Reader: rs.Reader})
+	}
+
+	return events
+}
@@ -0,0 +1,139 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package scard
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ebfe/scard/pcscdtest"
+	"github.com/ebfe/scard/sim"
+)
+
+// muteCard is a card that does not answer to reset.
+type muteCard struct{}
+
+func (muteCard) ATR() []byte                         { return nil }
+func (muteCard) Transmit(cmd []byte) ([]byte, error) { return nil, ErrUnresponsiveCard }
+
+func nextEvent(t *testing.T, m *Monitor, typ EventType, reader string) Event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-m.C:
+		if !ok {
+			t.Fatalf("monitor stopped: %v", m.Err())
+		}
+		if ev.Type != typ || ev.Reader != reader {
+			t.Fatalf("event %v %q; want %v %q", ev.Type, ev.Reader, typ, reader)
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event; want %v %q", typ, reader)
+	}
+	panic("unreachable")
+}
+
+func TestMonitor(t *testing.T) {
+	s, err := pcscdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	r0, err := s.AddReader("Reader 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r0.Insert(sim.New(nil))
+
+	mctx, err := EstablishContextWithBackend(NewPCSCLiteBackend(s.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mctx.Release()
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMonitor(c, mctx)
+
+	nextEvent(t, m, ReaderAdded, "Reader 0")
+	ev := nextEvent(t, m, CardInserted, "Reader 0")
+	if !bytes.Equal(ev.Atr, sim.DefaultATR) {
+		t.Fatalf("Atr = % x; want % x", ev.Atr, sim.DefaultATR)
+	}
+
+	r1, err := s.AddReader("Reader 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, m, ReaderAdded, "Reader 1")
+
+	r1.Insert(muteCard{})
+	nextEvent(t, m, ReaderMute, "Reader 1")
+	r1.Eject()
+	nextEvent(t, m, CardRemoved, "Reader 1")
+
+	ctx, err := EstablishContextWithBackend(NewPCSCLiteBackend(s.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+	card, err := ctx.Connect("Reader 0", ShareExclusive, ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, m, ExclusiveInUse, "Reader 0")
+	card.Disconnect(LeaveCard)
+
+	// swapping the card is a removal and an insertion
+	r0.Insert(sim.New(nil))
+	nextEvent(t, m, CardRemoved, "Reader 0")
+	nextEvent(t, m, CardInserted, "Reader 0")
+
+	s.RemoveReader(r0)
+	nextEvent(t, m, CardRemoved, "Reader 0")
+	nextEvent(t, m, ReaderRemoved, "Reader 0")
+
+	cancel()
+	for ev := range m.C {
+		t.Errorf("unexpected event %v %q", ev.Type, ev.Reader)
+	}
+	if err := m.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+}
+
+func TestStateEvents(t *testing.T) {
+	tests := []struct {
+		prev, cur StateFlag
+		want      []EventType
+	}{
+		{StateUnaware, StateEmpty, nil},
+		{StateUnaware, StatePresent, []EventType{CardInserted}},
+		{StateEmpty, StatePresent | StateExclusive, []EventType{CardInserted, ExclusiveInUse}},
+		{StatePresent, StatePresent | StateInuse, nil},
+		{StatePresent, StatePresent | StateExclusive, []EventType{ExclusiveInUse}},
+		{StatePresent, StateEmpty, []EventType{CardRemoved}},
+		{StateEmpty, StatePresent | StateMute, []EventType{ReaderMute}},
+		{StatePresent | StateMute, StatePresent, []EventType{CardInserted}},
+		{1<<16 | StatePresent, 3<<16 | StatePresent, []EventType{CardRemoved, CardInserted}},
+	}
+
+	for _, tt := range tests {
+		rs := ReaderState{Reader: "r", EventState: tt.cur}
+		events := stateEvents(tt.prev, &rs)
+		if len(events) != len(tt.want) {
+			t.Errorf("stateEvents(%v, %v) = %v; want %v", tt.prev, tt.cur, events, tt.want)
+			continue
+		}
+		for i := range events {
+			if events[i].Type != tt.want[i] {
+				t.Errorf("stateEvents(%v, %v) = %v; want %v", tt.prev, tt.cur, events, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build !windows && !darwin && cgo && !purego
+// +build !windows,!darwin,cgo,!purego
+
+package pcscdtest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ebfe/scard"
+	"github.com/ebfe/scard/pcscdtest"
+)
+
+// TestDefaultBackend runs libpcsclite against the fake pcscd.
+func TestDefaultBackend(t *testing.T) {
+	s, err := pcscdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r := addReader(t, s, "Virtual Reader 00 00")
+
+	// libpcsclite reads the socket name once, on first use
+	t.Setenv("PCSCLITE_CSOCK_NAME", s.Path())
+
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		t.Skipf("libpcsclite unavailable: %v", err)
+	}
+	defer ctx.Release()
+
+	readers, err := ctx.ListReaders()
+	if err != nil || len(readers) != 1 || readers[0] != r.Name() {
+		t.Skipf("libpcsclite not talking to the fake pcscd: ListReaders() = %q, %v", readers, err)
+	}
+
+	rs := []scard.ReaderState{{Reader: r.Name(), CurrentState: scard.StateEmpty}}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Insert(&echoCard{atr: testATR})
+	}()
+	if err := ctx.GetStatusChange(rs, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StatePresent == 0 || !bytes.Equal(rs[0].Atr, testATR) {
+		t.Fatalf("EventState = %v, Atr = % x", rs[0].EventState, rs[0].Atr)
+	}
+
+	card, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer card.Disconnect(scard.LeaveCard)
+
+	rsp, err := card.Transmit([]byte{0x00, 0xca, 0x00, 0x00, 0x02, 0x12, 0x34})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, []byte{0x12, 0x34, 0x90, 0x00}) {
+		t.Fatalf("Transmit() = % x", rsp)
+	}
+
+	status, err := card.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Reader != r.Name() || !bytes.Equal(status.Atr, testATR) {
+		t.Fatalf("Status() = %+v", status)
+	}
+}
@@ -0,0 +1,247 @@
+package pcscdtest
+
+import (
+	"fmt"
+
+	"github.com/ebfe/scard/internal/pcsclite"
+)
+
+// Card is a virtual card that can be inserted into a Reader.
+//
+// Transmit is never called concurrently for the same reader.
+type Card interface {
+	// ATR returns the answer to reset. A card with an empty ATR is
+	// reported as mute and cannot be connected to.
+	ATR() []byte
+	// Transmit processes a command APDU and returns the response APDU.
+	Transmit(cmd []byte) ([]byte, error)
+}
+
+// Resetter is implemented by cards that want to be notified when a client
+// resets or unpowers them.
+type Resetter interface {
+	Reset()
+}
+
+// ReturnCode is an error that a Card or control handler can return to have
+// the server reply with the given PC/SC return code instead of
+// SCARD_F_COMM_ERROR, e.g. ReturnCode(scard.ErrRemovedCard).
+type ReturnCode uint32
+
+func (rc ReturnCode) Error() string {
+	return fmt.Sprintf("pcscdtest: return code %#x", uint32(rc))
+}
+
+// Attribute ids with a built-in value (SCARD_ATTR_*).
+const (
+	attrVendorName          = 0x10100
+	attrCurrentProtocolType = 0x80201
+	attrAtrString           = 0x90303
+	attrDeviceFriendlyName  = 0x7fff0003
+)
+
+// Protocol bits (SCARD_PROTOCOL_*).
+const (
+	protocolT0 = 0x1
+	protocolT1 = 0x2
+)
+
+// Share modes (SCARD_SHARE_*).
+const (
+	shareExclusive = 0x1
+	shareShared    = 0x2
+	shareDirect    = 0x3
+)
+
+// Dispositions (SCARD_*_CARD).
+const (
+	leaveCard = 0x0
+)
+
+// Reader is a virtual reader attached to a Server.
+type Reader struct {
+	s    *Server
+	name string
+
+	// The fields below are guarded by s.mu.
+	removed      bool
+	card         Card
+	cardGen      uint64
+	resetGen     uint64
+	eventCounter uint32
+	protocol     uint32
+	handles      map[*handle]struct{}
+	lock         *handle
+	lockCount    int
+	attribs      map[uint32][]byte
+	control      func(code uint32, in []byte) ([]byte, error)
+}
+
+// Name returns the reader name as reported by SCardListReaders.
+func (r *Reader) Name() string {
+	return r.name
+}
+
+// Insert inserts card into the reader, replacing any card already present.
+// Clients connected to the reader get SCARD_W_REMOVED_CARD until they
+// reconnect.
+func (r *Reader) Insert(card Card) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.changeCard(card)
+}
+
+// Eject removes the card from the reader.
+func (r *Reader) Eject() {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.card != nil {
+		r.changeCard(nil)
+	}
+}
+
+// Card returns the card currently in the reader, or nil.
+func (r *Reader) Card() Card {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.card
+}
+
+// SetAttrib sets the value returned by SCardGetAttrib for id.
+func (r *Reader) SetAttrib(id uint32, value []byte) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.attribs[id] = append([]byte(nil), value...)
+}
+
+// HandleControl sets the function called for SCardControl requests. By
+// default SCardControl fails with SCARD_E_UNSUPPORTED_FEATURE.
+func (r *Reader) HandleControl(f func(code uint32, in []byte) ([]byte, error)) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.control = f
+}
+
+func (r *Reader) changeCard(card Card) {
+	r.card = card
+	r.cardGen++
+	r.eventCounter++
+	r.protocol = 0
+	r.lock = nil
+	r.lockCount = 0
+	r.s.signal()
+}
+
+// reset warm resets the card on behalf of h, which will not see
+// SCARD_W_RESET_CARD itself.
+func (r *Reader) reset(h *handle) {
+	if r.card == nil {
+		return
+	}
+	if c, ok := r.card.(Resetter); ok {
+		c.Reset()
+	}
+	r.resetGen++
+	r.lock = nil
+	r.lockCount = 0
+	if h != nil {
+		h.resetGen = r.resetGen
+	}
+}
+
+// sharing returns the value of the readerSharing field of the reader
+// state table.
+func (r *Reader) sharing() int32 {
+	for h := range r.handles {
+		if h.mode == shareExclusive {
+			return pcsclite.SharingExclusive
+		}
+	}
+	return int32(len(r.handles))
+}
+
+func (r *Reader) atr() []byte {
+	if r.card == nil {
+		return nil
+	}
+	return r.card.ATR()
+}
+
+func (r *Reader) state() pcsclite.ReaderState {
+	var rs pcsclite.ReaderState
+
+	copy(rs.Name[:len(rs.Name)-1], r.name)
+	rs.EventCounter = r.eventCounter
+	rs.Sharing = r.sharing()
+	rs.Protocol = r.protocol
+
+	atr := r.atr()
+	switch {
+	case r.card == nil:
+		rs.State = pcsclite.StateAbsent
+	case len(atr) == 0:
+		rs.State = pcsclite.StatePresent | pcsclite.StateSwallowed
+	case r.protocol != 0:
+		rs.State = pcsclite.StatePresent | pcsclite.StatePowered | pcsclite.StateSpecific
+	default:
+		rs.State = pcsclite.StatePresent | pcsclite.StatePowered | pcsclite.StateNegotiable
+	}
+	rs.AtrLength = uint32(copy(rs.Atr[:], atr))
+
+	return rs
+}
+
+func (r *Reader) attrib(id uint32) ([]byte, bool) {
+	switch id {
+	case attrAtrString:
+		atr := r.atr()
+		return atr, len(atr) > 0
+	case attrCurrentProtocolType:
+		if r.protocol == 0 {
+			return nil, false
+		}
+		return []byte{byte(r.protocol), 0, 0, 0}, true
+	}
+	v, ok := r.attribs[id]
+	return v, ok
+}
+
+// atrProtocols returns the protocols indicated by the TDi bytes of atr.
+// T=0 is implied if no protocol is indicated.
+func atrProtocols(atr []byte) uint32 {
+	var protos uint32
+
+	if len(atr) < 2 {
+		return protocolT0
+	}
+
+	y := atr[1] >> 4
+	i := 2
+	for {
+		for _, bit := range []byte{1, 2, 4} {
+			if y&bit != 0 {
+				i++
+			}
+		}
+		if y&8 == 0 || i >= len(atr) {
+			break
+		}
+		td := atr[i]
+		i++
+		if t := td & 0x0f; t < 32 {
+			protos |= 1 << t
+		}
+		y = td >> 4
+	}
+
+	protos &= protocolT0 | protocolT1
+	if protos == 0 {
+		protos = protocolT0
+	}
+	return protos
+}
@@ -0,0 +1,857 @@
+// Package pcscdtest provides an in-process pcscd for hermetic tests.
+//
+// A Server listens on a temporary UNIX socket and implements the server
+// side of the pcsc-lite protocol on top of virtual readers and cards. Point
+// PCSCLITE_CSOCK_NAME at Server.Path before establishing a context to have
+// libpcsclite (and the pure Go backend of package scard) talk to it:
+//
+//	s, err := pcscdtest.NewServer()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer s.Close()
+//	t.Setenv("PCSCLITE_CSOCK_NAME", s.Path())
+//
+//	r, _ := s.AddReader("Virtual Reader 00 00")
+//	r.Insert(card)
+package pcscdtest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ebfe/scard/internal/pcsclite"
+)
+
+// ErrTooManyReaders is returned by AddReader if all reader slots are in
+// use.
+var ErrTooManyReaders = errors.New("pcscdtest: too many readers")
+
+// ErrDuplicateReader is returned by AddReader if a reader with the same
+// name exists.
+var ErrDuplicateReader = errors.New("pcscdtest: duplicate reader name")
+
+// Server is a fake pcscd.
+type Server struct {
+	dir  string
+	path string
+	ln   net.Listener
+	wg   sync.WaitGroup
+
+	mu          sync.Mutex
+	closed      bool
+	readers     [pcsclite.MaxReaders]*Reader
+	clients     map[*client]struct{}
+	contexts    map[uint32]*client
+	handles     map[int32]*handle
+	nextContext uint32
+	nextHandle  int32
+}
+
+type client struct {
+	s    *Server
+	conn net.Conn
+
+	wmu sync.Mutex // serializes writes to conn
+
+	// guarded by s.mu
+	contexts map[uint32]struct{}
+	waiting  bool
+}
+
+type handle struct {
+	id       int32
+	ctx      uint32
+	client   *client
+	reader   *Reader
+	mode     uint32
+	cardGen  uint64
+	resetGen uint64
+}
+
+// NewServer starts a Server listening on a socket in a new temporary
+// directory.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "pcscdtest")
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "pcscd.comm")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{
+		dir:         dir,
+		path:        path,
+		ln:          ln,
+		clients:     make(map[*client]struct{}),
+		contexts:    make(map[uint32]*client),
+		handles:     make(map[int32]*handle),
+		nextContext: 0x1000,
+		nextHandle:  0x2000,
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Path returns the path of the socket the server listens on.
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Close stops the server, closes all client connections and removes the
+// socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// AddReader attaches a new empty reader.
+func (s *Server) AddReader(name string) (*Reader, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(name) >= pcsclite.MaxReaderName {
+		return nil, errors.New("pcscdtest: reader name too long")
+	}
+
+	slot := -1
+	for i, r := range s.readers {
+		switch {
+		case r == nil:
+			if slot < 0 {
+				slot = i
+			}
+		case r.name == name:
+			return nil, ErrDuplicateReader
+		}
+	}
+	if slot < 0 {
+		return nil, ErrTooManyReaders
+	}
+
+	r := &Reader{
+		s:       s,
+		name:    name,
+		handles: make(map[*handle]struct{}),
+		attribs: map[uint32][]byte{
+			attrVendorName:         []byte("pcscdtest"),
+			attrDeviceFriendlyName: append([]byte(name), 0),
+		},
+	}
+	s.readers[slot] = r
+	s.signal()
+
+	return r, nil
+}
+
+// RemoveReader detaches r. Clients connected to it get
+// SCARD_E_READER_UNAVAILABLE.
+func (s *Server) RemoveReader(r *Reader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.readers {
+		if s.readers[i] == r {
+			s.readers[i] = nil
+			r.removed = true
+			s.signal()
+			return
+		}
+	}
+}
+
+// Readers returns the attached readers.
+func (s *Server) Readers() []*Reader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var readers []*Reader
+	for _, r := range s.readers {
+		if r != nil {
+			readers = append(readers, r)
+		}
+	}
+	return readers
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{s: s, conn: conn, contexts: make(map[uint32]struct{})}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// signal notifies clients waiting for a reader state change. s.mu must be
+// held.
+func (s *Server) signal() {
+	for c := range s.clients {
+		if c.waiting {
+			c.waiting = false
+			c.write(&pcsclite.WaitReaderStateChange{}, nil)
+		}
+	}
+}
+
+func (s *Server) readerStates() *pcsclite.ReaderStates {
+	var states pcsclite.ReaderStates
+	for i, r := range s.readers {
+		if r != nil {
+			states[i] = r.state()
+		}
+	}
+	return &states
+}
+
+func (s *Server) reader(name string) *Reader {
+	for _, r := range s.readers {
+		if r != nil && r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (c *client) write(msg interface{}, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return pcsclite.WriteReply(c.conn, msg, payload)
+}
+
+// handle looks up a card handle owned by c. s.mu must be held.
+func (c *client) handle(id int32) (*handle, uint32) {
+	h, ok := c.s.handles[id]
+	if !ok || h.client != c {
+		return nil, pcsclite.ErrInvalidHandle
+	}
+	return h, pcsclite.Success
+}
+
+// check reports reader removal, card removal and card reset to h. s.mu
+// must be held.
+func (h *handle) check() uint32 {
+	r := h.reader
+	switch {
+	case r.removed:
+		return pcsclite.ErrReaderUnavailable
+	case h.cardGen != r.cardGen:
+		return pcsclite.ErrRemovedCard
+	case h.resetGen != r.resetGen:
+		return pcsclite.ErrResetCard
+	}
+	return pcsclite.Success
+}
+
+// checkLock is like check but also fails if another handle holds a
+// transaction. s.mu must be held.
+func (h *handle) checkLock() uint32 {
+	if rv := h.check(); rv != pcsclite.Success {
+		return rv
+	}
+	if r := h.reader; r.lock != nil && r.lock != h {
+		return pcsclite.ErrSharingViolation
+	}
+	return pcsclite.Success
+}
+
+func (c *client) serve() {
+	s := c.s
+
+	defer func() {
+		s.mu.Lock()
+		for ctx := range c.contexts {
+			s.releaseContext(c, ctx)
+		}
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	for {
+		h, err := pcsclite.ReadHeader(c.conn)
+		if err != nil {
+			return
+		}
+		if err := c.dispatch(h); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch handles one request. An error closes the connection.
+func (c *client) dispatch(h pcsclite.Header) error {
+	s := c.s
+
+	read := func(msg interface{}) error {
+		if h.Size != pcsclite.Size(msg) {
+			return errors.New("pcscdtest: bad message size")
+		}
+		return pcsclite.ReadMessage(c.conn, msg)
+	}
+
+	switch h.Command {
+	case pcsclite.CmdVersion:
+		var msg pcsclite.Version
+		if err := read(&msg); err != nil {
+			return err
+		}
+		if msg.Major != pcsclite.ProtocolVersionMajor {
+			msg.Rv = pcsclite.ErrServiceStopped
+		}
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdEstablishContext:
+		var msg pcsclite.EstablishContext
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.nextContext++
+		msg.HContext = s.nextContext
+		s.contexts[msg.HContext] = c
+		c.contexts[msg.HContext] = struct{}{}
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdReleaseContext:
+		var msg pcsclite.ReleaseContext
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		msg.Rv = s.releaseContext(c, msg.HContext)
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdGetReadersState:
+		s.mu.Lock()
+		states := s.readerStates()
+		s.mu.Unlock()
+		return c.write(states, nil)
+
+	case pcsclite.CmdWaitReaderStateChange:
+		// Send the table and register atomically, so no change is lost.
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c.waiting = true
+		return c.write(s.readerStates(), nil)
+
+	case pcsclite.CmdStopWaitingReaderStateChange:
+		var msg pcsclite.WaitReaderStateChange
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if c.waiting {
+			c.waiting = false
+			msg.Rv = pcsclite.Success
+		} else {
+			msg.Rv = pcsclite.ErrInternalError
+		}
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdCancel:
+		var msg pcsclite.Cancel
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if target, ok := s.contexts[uint32(msg.HContext)]; !ok {
+			msg.Rv = pcsclite.ErrInvalidHandle
+		} else if target.waiting {
+			target.waiting = false
+			target.write(&pcsclite.WaitReaderStateChange{Rv: pcsclite.ErrCancelled}, nil)
+		}
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdConnect:
+		var msg pcsclite.Connect
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.connect(c, &msg)
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdReconnect:
+		var msg pcsclite.Reconnect
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.reconnect(c, &msg)
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdDisconnect:
+		var msg pcsclite.Disconnect
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if hd, rv := c.handle(msg.HCard); rv != pcsclite.Success {
+			msg.Rv = rv
+		} else {
+			s.disconnect(hd, msg.Disposition)
+		}
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdBeginTransaction:
+		var msg pcsclite.BeginTransaction
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		msg.Rv = s.beginTransaction(c, msg.HCard)
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdEndTransaction:
+		var msg pcsclite.EndTransaction
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		msg.Rv = s.endTransaction(c, msg.HCard, msg.Disposition)
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdStatus:
+		var msg pcsclite.Status
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if hd, rv := c.handle(msg.HCard); rv != pcsclite.Success {
+			msg.Rv = rv
+		} else {
+			msg.Rv = hd.check()
+		}
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+
+	case pcsclite.CmdTransmit:
+		var msg pcsclite.Transmit
+		if err := read(&msg); err != nil {
+			return err
+		}
+		if msg.SendLength > pcsclite.MaxBufferSizeExtended || msg.RecvLength > pcsclite.MaxBufferSizeExtended {
+			return errors.New("pcscdtest: buffer overflow")
+		}
+		cmd := make([]byte, msg.SendLength)
+		if _, err := io.ReadFull(c.conn, cmd); err != nil {
+			return err
+		}
+		rsp := s.transmit(c, &msg, cmd)
+		return c.write(&msg, rsp)
+
+	case pcsclite.CmdControl:
+		var msg pcsclite.Control
+		if err := read(&msg); err != nil {
+			return err
+		}
+		if msg.SendLength > pcsclite.MaxBufferSizeExtended || msg.RecvLength > pcsclite.MaxBufferSizeExtended {
+			return errors.New("pcscdtest: buffer overflow")
+		}
+		in := make([]byte, msg.SendLength)
+		if _, err := io.ReadFull(c.conn, in); err != nil {
+			return err
+		}
+		out := s.control(c, &msg, in)
+		return c.write(&msg, out)
+
+	case pcsclite.CmdGetAttrib, pcsclite.CmdSetAttrib:
+		var msg pcsclite.GetSetAttrib
+		if err := read(&msg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.getSetAttrib(c, h.Command, &msg)
+		s.mu.Unlock()
+		return c.write(&msg, nil)
+	}
+
+	return errors.New("pcscdtest: unsupported command")
+}
+
+// releaseContext disconnects all cards of ctx and forgets it. s.mu must be
+// held.
+func (s *Server) releaseContext(c *client, ctx uint32) uint32 {
+	if _, ok := c.contexts[ctx]; !ok {
+		return pcsclite.ErrInvalidHandle
+	}
+	for _, h := range s.handles {
+		if h.client == c && h.ctx == ctx {
+			s.disconnect(h, leaveCard)
+		}
+	}
+	delete(c.contexts, ctx)
+	delete(s.contexts, ctx)
+	return pcsclite.Success
+}
+
+// negotiate picks the active protocol for a connection to r. s.mu must be
+// held.
+func (r *Reader) negotiate(mode, preferred uint32) (uint32, uint32) {
+	if mode == shareDirect && r.card == nil {
+		return 0, pcsclite.Success
+	}
+	if r.protocol != 0 {
+		if preferred&r.protocol == 0 && mode != shareDirect {
+			return 0, pcsclite.ErrProtoMismatch
+		}
+		return r.protocol, pcsclite.Success
+	}
+
+	supported := atrProtocols(r.atr())
+	switch {
+	case preferred&supported&protocolT1 != 0:
+		return protocolT1, pcsclite.Success
+	case preferred&supported&protocolT0 != 0:
+		return protocolT0, pcsclite.Success
+	case mode == shareDirect:
+		return 0, pcsclite.Success
+	}
+	return 0, pcsclite.ErrProtoMismatch
+}
+
+func (s *Server) connect(c *client, msg *pcsclite.Connect) {
+	if _, ok := c.contexts[msg.HContext]; !ok {
+		msg.Rv = pcsclite.ErrInvalidHandle
+		return
+	}
+
+	r := s.reader(pcsclite.CString(msg.Reader[:]))
+	if r == nil {
+		msg.Rv = pcsclite.ErrUnknownReader
+		return
+	}
+
+	switch msg.ShareMode {
+	case shareExclusive, shareShared, shareDirect:
+	default:
+		msg.Rv = pcsclite.ErrInvalidValue
+		return
+	}
+
+	if msg.ShareMode != shareDirect {
+		if r.card == nil {
+			msg.Rv = pcsclite.ErrNoSmartcard
+			return
+		}
+		if len(r.atr()) == 0 {
+			msg.Rv = pcsclite.ErrUnresponsiveCard
+			return
+		}
+	}
+
+	switch sharing := r.sharing(); {
+	case sharing == pcsclite.SharingExclusive:
+		msg.Rv = pcsclite.ErrSharingViolation
+		return
+	case sharing > 0 && msg.ShareMode == shareExclusive:
+		msg.Rv = pcsclite.ErrSharingViolation
+		return
+	}
+
+	proto, rv := r.negotiate(msg.ShareMode, msg.PreferredProtocols)
+	if rv != pcsclite.Success {
+		msg.Rv = rv
+		return
+	}
+
+	s.nextHandle++
+	h := &handle{
+		id:       s.nextHandle,
+		ctx:      msg.HContext,
+		client:   c,
+		reader:   r,
+		mode:     msg.ShareMode,
+		cardGen:  r.cardGen,
+		resetGen: r.resetGen,
+	}
+	s.handles[h.id] = h
+	r.handles[h] = struct{}{}
+	r.protocol = proto
+	s.signal()
+
+	msg.HCard = h.id
+	msg.ActiveProtocol = proto
+}
+
+func (s *Server) reconnect(c *client, msg *pcsclite.Reconnect) {
+	h, rv := c.handle(msg.HCard)
+	if rv != pcsclite.Success {
+		msg.Rv = rv
+		return
+	}
+	r := h.reader
+
+	if r.removed {
+		msg.Rv = pcsclite.ErrReaderUnavailable
+		return
+	}
+	if r.lock != nil && r.lock != h {
+		msg.Rv = pcsclite.ErrSharingViolation
+		return
+	}
+	if msg.ShareMode != shareDirect && r.card == nil {
+		msg.Rv = pcsclite.ErrNoSmartcard
+		return
+	}
+	if msg.ShareMode == shareExclusive && len(r.handles) > 1 {
+		msg.Rv = pcsclite.ErrSharingViolation
+		return
+	}
+
+	h.mode = msg.ShareMode
+	h.cardGen = r.cardGen
+	h.resetGen = r.resetGen
+	if r.lock == h {
+		r.lock = nil
+		r.lockCount = 0
+	}
+	if msg.Initialization != leaveCard {
+		r.reset(h)
+		r.protocol = 0
+	}
+
+	proto, rv := r.negotiate(msg.ShareMode, msg.PreferredProtocols)
+	if rv != pcsclite.Success {
+		msg.Rv = rv
+		return
+	}
+	r.protocol = proto
+	s.signal()
+
+	msg.ActiveProtocol = proto
+}
+
+// disconnect closes h. s.mu must be held.
+func (s *Server) disconnect(h *handle, disposition uint32) {
+	r := h.reader
+
+	if r.lock == h {
+		r.lock = nil
+		r.lockCount = 0
+	}
+	if disposition != leaveCard && !r.removed && h.cardGen == r.cardGen {
+		r.reset(h)
+	}
+
+	delete(r.handles, h)
+	delete(s.handles, h.id)
+	if len(r.handles) == 0 {
+		r.protocol = 0
+	}
+	s.signal()
+}
+
+func (s *Server) beginTransaction(c *client, id int32) uint32 {
+	h, rv := c.handle(id)
+	if rv != pcsclite.Success {
+		return rv
+	}
+	if rv := h.checkLock(); rv != pcsclite.Success {
+		return rv
+	}
+	h.reader.lock = h
+	h.reader.lockCount++
+	return pcsclite.Success
+}
+
+func (s *Server) endTransaction(c *client, id int32, disposition uint32) uint32 {
+	h, rv := c.handle(id)
+	if rv != pcsclite.Success {
+		return rv
+	}
+	r := h.reader
+
+	if rv := h.check(); rv != pcsclite.Success {
+		if r.lock == h {
+			r.lock = nil
+			r.lockCount = 0
+		}
+		return rv
+	}
+	if r.lock != h {
+		return pcsclite.ErrNotTransacted
+	}
+
+	r.lockCount--
+	if r.lockCount == 0 {
+		r.lock = nil
+	}
+	if disposition != leaveCard {
+		r.reset(h)
+	}
+	return pcsclite.Success
+}
+
+func returnCode(err error) uint32 {
+	var rc ReturnCode
+	if errors.As(err, &rc) {
+		return uint32(rc)
+	}
+	return pcsclite.ErrCommError
+}
+
+func (s *Server) transmit(c *client, msg *pcsclite.Transmit, cmd []byte) []byte {
+	s.mu.Lock()
+	h, rv := c.handle(msg.HCard)
+	if rv == pcsclite.Success {
+		rv = h.checkLock()
+	}
+	var card Card
+	if rv == pcsclite.Success {
+		card = h.reader.card
+		switch {
+		case card == nil:
+			rv = pcsclite.ErrNoSmartcard
+		case msg.SendPciProtocol != h.reader.protocol:
+			rv = pcsclite.ErrProtoMismatch
+		}
+	}
+	s.mu.Unlock()
+
+	if rv != pcsclite.Success {
+		msg.Rv = rv
+		return nil
+	}
+
+	rsp, err := card.Transmit(cmd)
+	if err != nil {
+		msg.Rv = returnCode(err)
+		return nil
+	}
+	if len(rsp) > int(msg.RecvLength) {
+		msg.Rv = pcsclite.ErrInsufficientBuffer
+		msg.RecvLength = uint32(len(rsp))
+		return nil
+	}
+
+	msg.RecvPciProtocol = msg.SendPciProtocol
+	msg.RecvPciLength = msg.SendPciLength
+	msg.RecvLength = uint32(len(rsp))
+	return rsp
+}
+
+func (s *Server) control(c *client, msg *pcsclite.Control, in []byte) []byte {
+	s.mu.Lock()
+	h, rv := c.handle(msg.HCard)
+	var f func(uint32, []byte) ([]byte, error)
+	if rv == pcsclite.Success {
+		switch {
+		case h.reader.removed:
+			rv = pcsclite.ErrReaderUnavailable
+		case h.reader.lock != nil && h.reader.lock != h:
+			rv = pcsclite.ErrSharingViolation
+		case h.reader.control == nil:
+			rv = pcsclite.ErrUnsupportedFeature
+		default:
+			f = h.reader.control
+		}
+	}
+	s.mu.Unlock()
+
+	if rv != pcsclite.Success {
+		msg.Rv = rv
+		return nil
+	}
+
+	out, err := f(msg.ControlCode, in)
+	if err != nil {
+		msg.Rv = returnCode(err)
+		return nil
+	}
+	if len(out) > int(msg.RecvLength) {
+		msg.Rv = pcsclite.ErrInsufficientBuffer
+		return nil
+	}
+
+	msg.BytesReturned = uint32(len(out))
+	return out
+}
+
+func (s *Server) getSetAttrib(c *client, cmd uint32, msg *pcsclite.GetSetAttrib) {
+	h, rv := c.handle(msg.HCard)
+	if rv != pcsclite.Success {
+		msg.Rv = rv
+		return
+	}
+	r := h.reader
+
+	switch {
+	case r.removed:
+		msg.Rv = pcsclite.ErrReaderUnavailable
+		return
+	case r.lock != nil && r.lock != h:
+		msg.Rv = pcsclite.ErrSharingViolation
+		return
+	}
+
+	if cmd == pcsclite.CmdSetAttrib {
+		if msg.AttrLen > uint32(len(msg.Attr)) {
+			msg.Rv = pcsclite.ErrInsufficientBuffer
+			return
+		}
+		r.attribs[msg.AttrID] = append([]byte(nil), msg.Attr[:msg.AttrLen]...)
+		return
+	}
+
+	v, ok := r.attrib(msg.AttrID)
+	switch {
+	case !ok:
+		msg.Rv = pcsclite.ErrUnsupportedFeature
+	case len(v) > int(msg.AttrLen) || len(v) > len(msg.Attr):
+		msg.Rv = pcsclite.ErrInsufficientBuffer
+		msg.AttrLen = uint32(len(v))
+	default:
+		msg.AttrLen = uint32(copy(msg.Attr[:], v))
+	}
+}
@@ -0,0 +1,457 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package pcscdtest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ebfe/scard"
+	"github.com/ebfe/scard/pcscdtest"
+)
+
+// echoCard answers every command with its data field and 90 00.
+type echoCard struct {
+	atr    []byte
+	resets int32
+}
+
+func (c *echoCard) ATR() []byte {
+	return c.atr
+}
+
+func (c *echoCard) Transmit(cmd []byte) ([]byte, error) {
+	var data []byte
+	if len(cmd) > 5 {
+		data = cmd[5:]
+	}
+	return append(append([]byte(nil), data...), 0x90, 0x00), nil
+}
+
+func (c *echoCard) Reset() {
+	atomic.AddInt32(&c.resets, 1)
+}
+
+var testATR = []byte{0x3b, 0x80, 0x80, 0x01, 0x01}
+
+func newServer(t *testing.T) (*pcscdtest.Server, *scard.Context) {
+	t.Helper()
+
+	s, err := pcscdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	ctx, err := scard.EstablishContextWithBackend(scard.NewPCSCLiteBackend(s.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ctx.Release() })
+
+	return s, ctx
+}
+
+func addReader(t *testing.T, s *pcscdtest.Server, name string) *pcscdtest.Reader {
+	t.Helper()
+
+	r, err := s.AddReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestTransmit(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+	r.Insert(&echoCard{atr: testATR})
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 || readers[0] != r.Name() {
+		t.Fatalf("ListReaders() = %q", readers)
+	}
+
+	card, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer card.Disconnect(scard.LeaveCard)
+
+	if card.ActiveProtocol() != scard.ProtocolT1 {
+		t.Errorf("ActiveProtocol() = %v; want %v", card.ActiveProtocol(), scard.ProtocolT1)
+	}
+
+	rsp, err := card.Transmit([]byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x3f, 0x00, 0x90, 0x00}; !bytes.Equal(rsp, want) {
+		t.Fatalf("Transmit() = % x; want % x", rsp, want)
+	}
+
+	status, err := card.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Reader != r.Name() || !bytes.Equal(status.Atr, testATR) || status.ActiveProtocol != scard.ProtocolT1 {
+		t.Fatalf("Status() = %+v", status)
+	}
+
+	atr, err := card.GetAttrib(scard.AttrAtrString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(atr, testATR) {
+		t.Fatalf("GetAttrib(AttrAtrString) = % x", atr)
+	}
+
+	if _, err := card.Control(scard.CtlCode(3400), nil); !errors.Is(err, scard.ErrUnsupportedFeature) {
+		t.Fatalf("Control() = %v; want %v", err, scard.ErrUnsupportedFeature)
+	}
+	r.HandleControl(func(code uint32, in []byte) ([]byte, error) {
+		return []byte{0x01, 0x02}, nil
+	})
+	out, err := card.Control(scard.CtlCode(3400), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte{0x01, 0x02}) {
+		t.Fatalf("Control() = % x", out)
+	}
+}
+
+func TestNoCard(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+
+	if _, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny); !errors.Is(err, scard.ErrNoSmartcard) {
+		t.Fatalf("Connect() = %v; want %v", err, scard.ErrNoSmartcard)
+	}
+	if _, err := ctx.Connect("Unknown Reader", scard.ShareShared, scard.ProtocolAny); !errors.Is(err, scard.ErrUnknownReader) {
+		t.Fatalf("Connect() = %v; want %v", err, scard.ErrUnknownReader)
+	}
+
+	card, err := ctx.Connect(r.Name(), scard.ShareDirect, scard.ProtocolUndefined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card.Disconnect(scard.LeaveCard)
+}
+
+func TestSharingViolation(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+	r.Insert(&echoCard{atr: testATR})
+
+	card, err := ctx.Connect(r.Name(), scard.ShareExclusive, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny); !errors.Is(err, scard.ErrSharingViolation) {
+		t.Fatalf("Connect() = %v; want %v", err, scard.ErrSharingViolation)
+	}
+
+	rs := []scard.ReaderState{{Reader: r.Name()}}
+	if err := ctx.GetStatusChange(rs, 0); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StateExclusive == 0 {
+		t.Fatalf("EventState = %v; want StateExclusive", rs[0].EventState)
+	}
+
+	if err := card.Disconnect(scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+	card, err = ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card.Disconnect(scard.LeaveCard)
+}
+
+func TestTransaction(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+	r.Insert(&echoCard{atr: testATR})
+
+	card1, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer card1.Disconnect(scard.LeaveCard)
+
+	card2, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer card2.Disconnect(scard.LeaveCard)
+
+	if err := card1.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := card2.Transmit([]byte{0x00, 0x00, 0x00, 0x00}); !errors.Is(err, scard.ErrSharingViolation) {
+		t.Fatalf("Transmit() = %v; want %v", err, scard.ErrSharingViolation)
+	}
+	if err := card2.EndTransaction(scard.LeaveCard); !errors.Is(err, scard.ErrNotTransacted) {
+		t.Fatalf("EndTransaction() = %v; want %v", err, scard.ErrNotTransacted)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- card2.BeginTransaction()
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("BeginTransaction() = %v; want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := card1.EndTransaction(scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := card2.EndTransaction(scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResetAndRemoval(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+	vc := &echoCard{atr: testATR}
+	r.Insert(vc)
+
+	card1, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card2, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := card2.Disconnect(scard.ResetCard); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&vc.resets); n != 1 {
+		t.Fatalf("resets = %d; want 1", n)
+	}
+
+	if _, err := card1.Transmit([]byte{0x00, 0x00, 0x00, 0x00}); !errors.Is(err, scard.ErrResetCard) {
+		t.Fatalf("Transmit() = %v; want %v", err, scard.ErrResetCard)
+	}
+	if err := card1.Reconnect(scard.ShareShared, scard.ProtocolAny, scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := card1.Transmit([]byte{0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Eject()
+	if _, err := card1.Transmit([]byte{0x00, 0x00, 0x00, 0x00}); !errors.Is(err, scard.ErrRemovedCard) {
+		t.Fatalf("Transmit() = %v; want %v", err, scard.ErrRemovedCard)
+	}
+
+	s.RemoveReader(r)
+	if _, err := card1.Transmit([]byte{0x00, 0x00, 0x00, 0x00}); !errors.Is(err, scard.ErrReaderUnavailable) {
+		t.Fatalf("Transmit() = %v; want %v", err, scard.ErrReaderUnavailable)
+	}
+}
+
+func TestResilientCard(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+	r.Insert(&echoCard{atr: testATR})
+
+	card, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inits := 0
+	rc := scard.NewResilientCard(card, func(*scard.Card) error {
+		inits++
+		return nil
+	})
+
+	other, err := ctx.Connect(r.Name(), scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Disconnect(scard.ResetCard); err != nil {
+		t.Fatal(err)
+	}
+	selectMF := []byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00}
+	if _, err := rc.Transmit(selectMF); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Eject()
+	r.Insert(&echoCard{atr: testATR})
+	if _, err := rc.Transmit(selectMF); err != nil {
+		t.Fatal(err)
+	}
+	if inits != 2 {
+		t.Fatalf("inits = %d; want 2", inits)
+	}
+
+	r.Eject()
+	if _, err := rc.Transmit(selectMF); !errors.Is(err, scard.ErrNoSmartcard) {
+		t.Fatalf("Transmit() without card = %v; want %v", err, scard.ErrNoSmartcard)
+	}
+}
+
+func TestGetStatusChange(t *testing.T) {
+	s, ctx := newServer(t)
+
+	rs := []scard.ReaderState{
+		{Reader: scard.PnPNotification},
+		{Reader: "Virtual Reader 00 00", CurrentState: scard.StateUnknown},
+	}
+
+	if err := ctx.GetStatusChange(rs, 10*time.Millisecond); !errors.Is(err, scard.ErrTimeout) {
+		t.Fatalf("GetStatusChange() = %v; want %v", err, scard.ErrTimeout)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.AddReader("Virtual Reader 00 00")
+	}()
+
+	if err := ctx.GetStatusChange(rs, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StateChanged == 0 || rs[0].EventState>>16 != 1 {
+		t.Fatalf("PnP EventState = %v", rs[0].EventState)
+	}
+	if rs[1].EventState&scard.StateEmpty == 0 {
+		t.Fatalf("EventState = %v; want StateEmpty", rs[1].EventState)
+	}
+
+	for i := range rs {
+		rs[i].CurrentState = rs[i].EventState
+	}
+
+	r := s.Readers()[0]
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Insert(&echoCard{atr: testATR})
+	}()
+
+	if err := ctx.GetStatusChange(rs, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if rs[1].EventState&scard.StatePresent == 0 || !bytes.Equal(rs[1].Atr, testATR) {
+		t.Fatalf("EventState = %v, Atr = % x", rs[1].EventState, rs[1].Atr)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+
+	rs := []scard.ReaderState{{Reader: r.Name(), CurrentState: scard.StateEmpty}}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ctx.Cancel()
+	}()
+
+	if err := ctx.GetStatusChange(rs, -1); !errors.Is(err, scard.ErrCancelled) {
+		t.Fatalf("GetStatusChange() = %v; want %v", err, scard.ErrCancelled)
+	}
+
+	// the context is still usable
+	if _, err := ctx.ListReaders(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatusChangeNoCounter(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+	r.Insert(&echoCard{atr: testATR})
+	r.Eject()
+
+	// waiting for insertion without the event counter
+	rs := []scard.ReaderState{{Reader: r.Name(), CurrentState: scard.StateEmpty}}
+	if err := ctx.GetStatusChange(rs, 100*time.Millisecond); !errors.Is(err, scard.ErrTimeout) {
+		t.Fatalf("GetStatusChange() = %v, EventState = %v; want %v", err, rs[0].EventState, scard.ErrTimeout)
+	}
+}
+
+func TestGetStatusChangeContextCancelRace(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+
+	rs := []scard.ReaderState{{Reader: r.Name(), CurrentState: scard.StateEmpty}}
+
+	// cancel around the time the wait starts; a lost cancel hangs
+	for i := 0; i < 200; i++ {
+		c, cancel := context.WithCancel(context.Background())
+		delay := time.Duration(rand.Intn(200)) * time.Microsecond
+		go func() {
+			time.Sleep(delay)
+			cancel()
+		}()
+		if err := ctx.GetStatusChangeContext(c, rs); !errors.Is(err, context.Canceled) {
+			t.Fatalf("GetStatusChangeContext() = %v; want context.Canceled", err)
+		}
+	}
+}
+
+func TestGetStatusChangeContext(t *testing.T) {
+	s, ctx := newServer(t)
+	r := addReader(t, s, "Virtual Reader 00 00")
+
+	rs := []scard.ReaderState{{Reader: r.Name(), CurrentState: scard.StateEmpty}}
+
+	c, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := ctx.GetStatusChangeContext(c, rs)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, scard.ErrCancelled) {
+		t.Fatalf("GetStatusChangeContext() = %v; want context.Canceled and ErrCancelled", err)
+	}
+
+	c, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = ctx.GetStatusChangeContext(c, rs)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, scard.ErrCancelled) {
+		t.Fatalf("GetStatusChangeContext() = %v; want context.DeadlineExceeded and ErrCancelled", err)
+	}
+	if err := ctx.GetStatusChangeContext(c, rs); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetStatusChangeContext(done) = %v; want context.DeadlineExceeded", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Insert(&echoCard{atr: testATR})
+	}()
+	if err := ctx.GetStatusChangeContext(context.Background(), rs); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StatePresent == 0 {
+		t.Fatalf("EventState = %v; want StatePresent", rs[0].EventState)
+	}
+
+	// the context is still usable
+	if _, err := ctx.ListReaders(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,728 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package scard
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ebfe/scard/internal/pcsclite"
+)
+
+// NewPCSCLiteBackend returns a Backend that speaks the pcsc-lite protocol
+// to pcscd over its UNIX domain socket, without libpcsclite or cgo.
+//
+// If socket is empty, the path is taken from the PCSCLITE_CSOCK_NAME
+// environment variable, falling back to /run/pcscd/pcscd.comm, each time a
+// context is established.
+func NewPCSCLiteBackend(socket string) Backend {
+	return &pcscliteBackend{
+		socket:   socket,
+		contexts: make(map[uintptr]*pcscliteContext),
+		cards:    make(map[uintptr]*pcscliteCard),
+	}
+}
+
+type pcscliteBackend struct {
+	socket string
+
+	mu       sync.Mutex
+	contexts map[uintptr]*pcscliteContext
+	cards    map[uintptr]*pcscliteCard
+}
+
+// pcscliteContext is a context established over its own connection to
+// pcscd. mu serializes requests on conn; it is held for the whole duration
+// of GetStatusChange, so Cancel uses a separate connection.
+type pcscliteContext struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	handle uint32
+}
+
+type pcscliteCard struct {
+	ctx    *pcscliteContext
+	handle int32
+	reader string
+}
+
+// stopWaitingTag is sent in CmdStopWaitingReaderStateChange. pcscd echoes
+// it in its reply, which tells the reply apart from an event notification
+// that was already in flight.
+const stopWaitingTag = 0xffffffff
+
+// pcscliteLockPollRate is PCSCLITE_LOCK_POLL_RATE.
+const pcscliteLockPollRate = 100 * time.Millisecond
+
+func (b *pcscliteBackend) socketPath() string {
+	if b.socket != "" {
+		return b.socket
+	}
+	return pcsclite.SocketPath()
+}
+
+func (b *pcscliteBackend) dial() (net.Conn, error) {
+	conn, err := net.Dial("unix", b.socketPath())
+	if err != nil {
+		return nil, ErrNoService
+	}
+	return conn, nil
+}
+
+func (b *pcscliteBackend) context(ctx uintptr) (*pcscliteContext, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.contexts[ctx]
+	if !ok {
+		return nil, ErrInvalidHandle
+	}
+	return c, nil
+}
+
+func (b *pcscliteBackend) card(card uintptr) (*pcscliteCard, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.cards[card]
+	if !ok {
+		return nil, ErrInvalidHandle
+	}
+	return c, nil
+}
+
+func rvError(rv uint32) error {
+	if rv != pcsclite.Success {
+		return Error(rv)
+	}
+	return nil
+}
+
+// call sends a request and reads the reply into msg. Any payload of the
+// reply is left for the caller to read.
+func (c *pcscliteContext) call(cmd uint32, msg interface{}, payload []byte) error {
+	if err := pcsclite.WriteRequest(c.conn, cmd, msg, payload); err != nil {
+		return ErrNoService
+	}
+	if err := pcsclite.ReadMessage(c.conn, msg); err != nil {
+		return ErrNoService
+	}
+	return nil
+}
+
+func (c *pcscliteContext) readerStates(cmd uint32) (*pcsclite.ReaderStates, error) {
+	var states pcsclite.ReaderStates
+	if err := pcsclite.WriteRequest(c.conn, cmd, nil, nil); err != nil {
+		return nil, ErrNoService
+	}
+	if err := pcsclite.ReadMessage(c.conn, &states); err != nil {
+		return nil, ErrNoService
+	}
+	return &states, nil
+}
+
+// waitEvent waits for an event notification until deadline (zero means no
+// deadline). It reports ErrTimeout if none arrived in time.
+func (c *pcscliteContext) waitEvent(deadline time.Time) error {
+	var wait pcsclite.WaitReaderStateChange
+
+	c.conn.SetReadDeadline(deadline)
+	err := pcsclite.ReadMessage(c.conn, &wait)
+	c.conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return ErrTimeout
+		}
+		return ErrNoService
+	}
+	return rvError(wait.Rv)
+}
+
+// stopWaiting unregisters from event notifications, discarding any
+// notification sent before pcscd processed the request.
+func (c *pcscliteContext) stopWaiting() error {
+	wait := pcsclite.WaitReaderStateChange{Timeout: stopWaitingTag}
+	if err := pcsclite.WriteRequest(c.conn, pcsclite.CmdStopWaitingReaderStateChange, &wait, nil); err != nil {
+		return ErrNoService
+	}
+	for {
+		if err := pcsclite.ReadMessage(c.conn, &wait); err != nil {
+			return ErrNoService
+		}
+		if wait.Timeout == stopWaitingTag {
+			return nil
+		}
+	}
+}
+
+func (b *pcscliteBackend) EstablishContext(scope Scope) (uintptr, error) {
+	conn, err := b.dial()
+	if err != nil {
+		return 0, err
+	}
+
+	c := &pcscliteContext{conn: conn}
+
+	ver := pcsclite.Version{Major: pcsclite.ProtocolVersionMajor, Minor: pcsclite.ProtocolVersionMinor}
+	if err := c.call(pcsclite.CmdVersion, &ver, nil); err != nil {
+		conn.Close()
+		return 0, err
+	}
+	if err := rvError(ver.Rv); err != nil {
+		conn.Close()
+		return 0, err
+	}
+
+	est := pcsclite.EstablishContext{Scope: uint32(scope)}
+	if err := c.call(pcsclite.CmdEstablishContext, &est, nil); err != nil {
+		conn.Close()
+		return 0, err
+	}
+	if err := rvError(est.Rv); err != nil {
+		conn.Close()
+		return 0, err
+	}
+	c.handle = est.HContext
+
+	b.mu.Lock()
+	b.contexts[uintptr(c.handle)] = c
+	b.mu.Unlock()
+
+	return uintptr(c.handle), nil
+}
+
+func (b *pcscliteBackend) ReleaseContext(ctx uintptr) error {
+	c, err := b.context(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	delete(b.contexts, ctx)
+	for h, card := range b.cards {
+		if card.ctx == c {
+			delete(b.cards, h)
+		}
+	}
+	b.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.conn.Close()
+
+	rel := pcsclite.ReleaseContext{HContext: c.handle}
+	if err := c.call(pcsclite.CmdReleaseContext, &rel, nil); err != nil {
+		return err
+	}
+	return rvError(rel.Rv)
+}
+
+func (b *pcscliteBackend) IsValidContext(ctx uintptr) error {
+	_, err := b.context(ctx)
+	return err
+}
+
+func (b *pcscliteBackend) Cancel(ctx uintptr) error {
+	c, err := b.context(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cancel := pcsclite.Cancel{HContext: int32(c.handle)}
+	if err := pcsclite.WriteRequest(conn, pcsclite.CmdCancel, &cancel, nil); err != nil {
+		return ErrNoService
+	}
+	if err := pcsclite.ReadMessage(conn, &cancel); err != nil {
+		return ErrNoService
+	}
+	return rvError(cancel.Rv)
+}
+
+// ListReaders ignores groups: pcsc-lite puts all readers in
+// SCard$DefaultReaders.
+func (b *pcscliteBackend) ListReaders(ctx uintptr, groups []string) ([]string, error) {
+	c, err := b.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	states, err := c.readerStates(pcsclite.CmdGetReadersState)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var readers []string
+	for i := range states {
+		if name := states[i].ReaderName(); name != "" {
+			readers = append(readers, name)
+		}
+	}
+	if len(readers) == 0 {
+		return nil, ErrNoReadersAvailable
+	}
+	return readers, nil
+}
+
+func (b *pcscliteBackend) ListReaderGroups(ctx uintptr) ([]string, error) {
+	if _, err := b.context(ctx); err != nil {
+		return nil, err
+	}
+	return []string{"SCard$DefaultReaders"}, nil
+}
+
+func (b *pcscliteBackend) GetStatusChange(ctx uintptr, timeout time.Duration, states []ReaderState) error {
+	c, err := b.context(ctx)
+	if err != nil {
+		return err
+	}
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		table, err := c.readerStates(pcsclite.CmdWaitReaderStateChange)
+		if err != nil {
+			return err
+		}
+
+		if pcscliteUpdateStates(states, table) {
+			return c.stopWaiting()
+		}
+
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			if err := c.stopWaiting(); err != nil {
+				return err
+			}
+			return ErrTimeout
+		}
+
+		switch err := c.waitEvent(deadline); err {
+		case nil:
+		case ErrTimeout:
+			if err := c.stopWaiting(); err != nil {
+				return err
+			}
+			return ErrTimeout
+		default:
+			// pcscd unregisters the context before signalling
+			// SCARD_E_CANCELLED.
+			return err
+		}
+	}
+}
+
+// pcscliteUpdateStates computes EventState and Atr for states from the
+// reader table the way libpcsclite does, and reports whether any of them
+// changed.
+func pcscliteUpdateStates(states []ReaderState, table *pcsclite.ReaderStates) bool {
+	changed := false
+
+	nreaders := 0
+	for i := range table {
+		if table[i].Name[0] != 0 {
+			nreaders++
+		}
+	}
+
+	for i := range states {
+		rs := &states[i]
+
+		if rs.CurrentState&StateIgnore != 0 {
+			rs.EventState = StateIgnore
+			continue
+		}
+
+		if rs.Reader == PnPNotification {
+			rs.EventState = StateFlag(nreaders << 16)
+			if rs.CurrentState&0xffff0000 != rs.EventState {
+				rs.EventState |= StateChanged
+				changed = true
+			}
+			continue
+		}
+
+		var entry *pcsclite.ReaderState
+		for j := range table {
+			if table[j].Name[0] != 0 && table[j].ReaderName() == rs.Reader {
+				entry = &table[j]
+				break
+			}
+		}
+
+		if entry == nil {
+			rs.EventState = StateUnknown | StateUnavailable
+			rs.Atr = nil
+			if rs.CurrentState&StateUnknown == 0 {
+				rs.EventState |= StateChanged
+				changed = true
+			}
+			continue
+		}
+
+		event := StateFlag(entry.EventCounter&0xffff) << 16
+		var atr []byte
+
+		switch {
+		case entry.State&pcsclite.StateUnknown != 0:
+			event |= StateUnavailable
+		case entry.State&pcsclite.StateAbsent != 0:
+			event |= StateEmpty
+		case entry.State&(pcsclite.StatePresent|pcsclite.StateSwallowed) != 0:
+			event |= StatePresent
+			if entry.State&pcsclite.StateSwallowed != 0 && entry.State&pcsclite.StatePowered == 0 {
+				event |= StateMute
+			}
+			if n := int(entry.AtrLength); n > 0 && n <= len(entry.Atr) {
+				atr = append([]byte(nil), entry.Atr[:n]...)
+			}
+		}
+
+		switch {
+		case entry.Sharing == pcsclite.SharingExclusive:
+			event |= StateExclusive
+		case entry.Sharing > pcsclite.SharingNone:
+			event |= StateInuse
+		}
+
+		// like libpcsclite, the event counter is only compared if the
+		// caller passed one
+		mask := ^(StateChanged | StateAtrmatch)
+		if rs.CurrentState>>16 == 0 {
+			mask &= 0xffff
+		}
+		if rs.CurrentState&mask != event&mask {
+			event |= StateChanged
+			changed = true
+		}
+
+		rs.EventState = event
+		rs.Atr = atr
+	}
+
+	return changed
+}
+
+func (b *pcscliteBackend) Connect(ctx uintptr, reader string, mode ShareMode, proto Protocol) (uintptr, Protocol, error) {
+	c, err := b.context(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	msg := pcsclite.Connect{
+		HContext:           c.handle,
+		ShareMode:          uint32(mode),
+		PreferredProtocols: uint32(proto),
+	}
+	if len(reader) >= len(msg.Reader) {
+		return 0, 0, ErrInvalidValue
+	}
+	copy(msg.Reader[:], reader)
+
+	c.mu.Lock()
+	err = c.call(pcsclite.CmdConnect, &msg, nil)
+	c.mu.Unlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return 0, 0, err
+	}
+
+	handle := uintptr(uint32(msg.HCard))
+
+	b.mu.Lock()
+	b.cards[handle] = &pcscliteCard{ctx: c, handle: msg.HCard, reader: reader}
+	b.mu.Unlock()
+
+	return handle, Protocol(msg.ActiveProtocol), nil
+}
+
+func (b *pcscliteBackend) Disconnect(card uintptr, d Disposition) error {
+	h, err := b.card(card)
+	if err != nil {
+		return err
+	}
+
+	msg := pcsclite.Disconnect{HCard: h.handle, Disposition: uint32(d)}
+
+	h.ctx.mu.Lock()
+	err = h.ctx.call(pcsclite.CmdDisconnect, &msg, nil)
+	h.ctx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	delete(b.cards, card)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *pcscliteBackend) Reconnect(card uintptr, mode ShareMode, proto Protocol, d Disposition) (Protocol, error) {
+	h, err := b.card(card)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := pcsclite.Reconnect{
+		HCard:              h.handle,
+		ShareMode:          uint32(mode),
+		PreferredProtocols: uint32(proto),
+		Initialization:     uint32(d),
+	}
+
+	h.ctx.mu.Lock()
+	err = h.ctx.call(pcsclite.CmdReconnect, &msg, nil)
+	h.ctx.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return 0, err
+	}
+	return Protocol(msg.ActiveProtocol), nil
+}
+
+func (b *pcscliteBackend) BeginTransaction(card uintptr) error {
+	h, err := b.card(card)
+	if err != nil {
+		return err
+	}
+
+	// pcscd fails with SCARD_E_SHARING_VIOLATION while another handle
+	// holds a transaction. Like libpcsclite, poll until it is released.
+	for {
+		msg := pcsclite.BeginTransaction{HCard: h.handle}
+
+		h.ctx.mu.Lock()
+		err = h.ctx.call(pcsclite.CmdBeginTransaction, &msg, nil)
+		h.ctx.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if msg.Rv != pcsclite.ErrSharingViolation {
+			return rvError(msg.Rv)
+		}
+		time.Sleep(pcscliteLockPollRate)
+	}
+}
+
+func (b *pcscliteBackend) EndTransaction(card uintptr, d Disposition) error {
+	h, err := b.card(card)
+	if err != nil {
+		return err
+	}
+
+	msg := pcsclite.EndTransaction{HCard: h.handle, Disposition: uint32(d)}
+
+	h.ctx.mu.Lock()
+	err = h.ctx.call(pcsclite.CmdEndTransaction, &msg, nil)
+	h.ctx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return rvError(msg.Rv)
+}
+
+func (b *pcscliteBackend) Status(card uintptr) (*CardStatus, error) {
+	h, err := b.card(card)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := pcsclite.Status{HCard: h.handle}
+
+	h.ctx.mu.Lock()
+	defer h.ctx.mu.Unlock()
+
+	if err := h.ctx.call(pcsclite.CmdStatus, &msg, nil); err != nil {
+		return nil, err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return nil, err
+	}
+
+	states, err := h.ctx.readerStates(pcsclite.CmdGetReadersState)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range states {
+		entry := &states[i]
+		if entry.Name[0] == 0 || entry.ReaderName() != h.reader {
+			continue
+		}
+		n := int(entry.AtrLength)
+		if n > len(entry.Atr) {
+			n = len(entry.Atr)
+		}
+		return &CardStatus{
+			Reader:         h.reader,
+			State:          State(entry.State),
+			ActiveProtocol: Protocol(entry.Protocol),
+			Atr:            append([]byte(nil), entry.Atr[:n]...),
+		}, nil
+	}
+
+	return nil, ErrReaderUnavailable
+}
+
+// readPayload reads a reply payload of n bytes into buf. If buf is too
+// small the payload is discarded and ErrInsufficientBuffer returned.
+func (c *pcscliteContext) readPayload(buf []byte, n uint32) (int, error) {
+	if int(n) > len(buf) {
+		if _, err := io.CopyN(io.Discard, c.conn, int64(n)); err != nil {
+			return 0, ErrNoService
+		}
+		return 0, ErrInsufficientBuffer
+	}
+	if _, err := io.ReadFull(c.conn, buf[:n]); err != nil {
+		return 0, ErrNoService
+	}
+	return int(n), nil
+}
+
+func (b *pcscliteBackend) Transmit(card uintptr, proto Protocol, cmd, rsp []byte) (int, error) {
+	n, _, err := b.TransmitPCI(card, &IORequest{Protocol: proto}, cmd, rsp)
+	return n, err
+}
+
+// TransmitPCI sends only the header of send; like libpcsclite, the protocol
+// specific data is not passed on to pcscd.
+func (b *pcscliteBackend) TransmitPCI(card uintptr, send *IORequest, cmd, rsp []byte) (int, *IORequest, error) {
+	h, err := b.card(card)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(cmd) > pcsclite.MaxBufferSizeExtended {
+		return 0, nil, ErrInsufficientBuffer
+	}
+	recvLen := len(rsp)
+	if recvLen > pcsclite.MaxBufferSizeExtended {
+		recvLen = pcsclite.MaxBufferSizeExtended
+	}
+
+	msg := pcsclite.Transmit{
+		HCard:           h.handle,
+		SendPciProtocol: uint32(send.Protocol),
+		SendPciLength:   uint32(pcsclite.IORequestSize),
+		SendLength:      uint32(len(cmd)),
+		RecvPciProtocol: uint32(send.Protocol),
+		RecvPciLength:   uint32(pcsclite.IORequestSize),
+		RecvLength:      uint32(recvLen),
+	}
+
+	h.ctx.mu.Lock()
+	defer h.ctx.mu.Unlock()
+
+	if err := h.ctx.call(pcsclite.CmdTransmit, &msg, cmd); err != nil {
+		return 0, nil, err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return 0, nil, err
+	}
+	n, err := h.ctx.readPayload(rsp, msg.RecvLength)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, &IORequest{Protocol: Protocol(msg.RecvPciProtocol)}, nil
+}
+
+func (b *pcscliteBackend) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	h, err := b.card(card)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(in) > pcsclite.MaxBufferSizeExtended {
+		return 0, ErrInsufficientBuffer
+	}
+	recvLen := len(out)
+	if recvLen > pcsclite.MaxBufferSizeExtended {
+		recvLen = pcsclite.MaxBufferSizeExtended
+	}
+
+	msg := pcsclite.Control{
+		HCard:       h.handle,
+		ControlCode: ioctl,
+		SendLength:  uint32(len(in)),
+		RecvLength:  uint32(recvLen),
+	}
+
+	h.ctx.mu.Lock()
+	defer h.ctx.mu.Unlock()
+
+	if err := h.ctx.call(pcsclite.CmdControl, &msg, in); err != nil {
+		return 0, err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return 0, err
+	}
+	return h.ctx.readPayload(out, msg.BytesReturned)
+}
+
+func (b *pcscliteBackend) GetAttrib(card uintptr, id Attrib) ([]byte, error) {
+	h, err := b.card(card)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := pcsclite.GetSetAttrib{HCard: h.handle, AttrID: uint32(id), AttrLen: pcsclite.MaxBufferSize}
+
+	h.ctx.mu.Lock()
+	err = h.ctx.call(pcsclite.CmdGetAttrib, &msg, nil)
+	h.ctx.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := rvError(msg.Rv); err != nil {
+		return nil, err
+	}
+	if msg.AttrLen > uint32(len(msg.Attr)) {
+		return nil, ErrInsufficientBuffer
+	}
+	return append([]byte(nil), msg.Attr[:msg.AttrLen]...), nil
+}
+
+func (b *pcscliteBackend) SetAttrib(card uintptr, id Attrib, data []byte) error {
+	h, err := b.card(card)
+	if err != nil {
+		return err
+	}
+
+	msg := pcsclite.GetSetAttrib{HCard: h.handle, AttrID: uint32(id), AttrLen: uint32(len(data))}
+	if len(data) > len(msg.Attr) {
+		return ErrInsufficientBuffer
+	}
+	copy(msg.Attr[:], data)
+
+	h.ctx.mu.Lock()
+	err = h.ctx.call(pcsclite.CmdSetAttrib, &msg, nil)
+	h.ctx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return rvError(msg.Rv)
+}
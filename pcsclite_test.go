@@ -0,0 +1,93 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package scard
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ebfe/scard/internal/pcsclite"
+)
+
+func TestPCSCLiteNoService(t *testing.T) {
+	b := NewPCSCLiteBackend(filepath.Join(t.TempDir(), "pcscd.comm"))
+	if _, err := EstablishContextWithBackend(b); !errors.Is(err, ErrNoService) {
+		t.Fatalf("EstablishContextWithBackend() = %v; want %v", err, ErrNoService)
+	}
+}
+
+func TestPCSCLiteUpdateStates(t *testing.T) {
+	var table pcsclite.ReaderStates
+	atr := []byte{0x3b, 0x80, 0x80, 0x01, 0x01}
+
+	copy(table[0].Name[:], "Reader A")
+	table[0].EventCounter = 3
+	table[0].State = pcsclite.StatePresent | pcsclite.StatePowered | pcsclite.StateSpecific
+	table[0].Sharing = 1
+	table[0].AtrLength = uint32(copy(table[0].Atr[:], atr))
+
+	copy(table[1].Name[:], "Reader B")
+	table[1].State = pcsclite.StateAbsent
+
+	states := []ReaderState{
+		{Reader: "Reader A", CurrentState: StateUnaware},
+		{Reader: "Reader B", CurrentState: StateEmpty},
+		{Reader: "Reader C", CurrentState: StateUnaware},
+		{Reader: PnPNotification, CurrentState: StateUnaware},
+		{Reader: "Reader A", CurrentState: StateIgnore},
+	}
+
+	if !pcscliteUpdateStates(states, &table) {
+		t.Fatal("no change reported")
+	}
+
+	want := []StateFlag{
+		3<<16 | StateChanged | StatePresent | StateInuse,
+		StateEmpty,
+		StateChanged | StateUnknown | StateUnavailable,
+		2<<16 | StateChanged,
+		StateIgnore,
+	}
+	for i := range states {
+		if states[i].EventState != want[i] {
+			t.Errorf("%s: EventState = %v; want %v", states[i].Reader, states[i].EventState, want[i])
+		}
+	}
+	if !bytes.Equal(states[0].Atr, atr) {
+		t.Errorf("Atr = % x; want % x", states[0].Atr, atr)
+	}
+
+	for i := range states {
+		states[i].CurrentState = states[i].EventState &^ StateChanged
+	}
+	if pcscliteUpdateStates(states, &table) {
+		t.Fatalf("change reported for unchanged table: %+v", states)
+	}
+
+	table[1].EventCounter = 1
+	table[1].State = pcsclite.StatePresent | pcsclite.StateSwallowed
+	if !pcscliteUpdateStates(states, &table) {
+		t.Fatal("card insertion not reported")
+	}
+	if want := 1<<16 | StateChanged | StatePresent | StateMute; states[1].EventState != want {
+		t.Fatalf("EventState = %v; want %v", states[1].EventState, want)
+	}
+
+	// without a counter in CurrentState only the state bits are compared
+	table[1].EventCounter = 2
+	table[1].State = pcsclite.StateAbsent
+	states = []ReaderState{{Reader: "Reader B", CurrentState: StateEmpty}}
+	if pcscliteUpdateStates(states, &table) {
+		t.Fatalf("change reported for empty reader: %v", states[0].EventState)
+	}
+	if want := 2<<16 | StateEmpty; states[0].EventState != want {
+		t.Fatalf("EventState = %v; want %v", states[0].EventState, want)
+	}
+	states[0].CurrentState = 1<<16 | StateEmpty
+	if !pcscliteUpdateStates(states, &table) {
+		t.Fatal("event counter change not reported")
+	}
+}
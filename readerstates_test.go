@@ -0,0 +1,100 @@
+//go:build !windows && !darwin && cgo && !purego
+// +build !windows,!darwin,cgo,!purego
+
+package scard
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReaderStates(t *testing.T) {
+	var s readerStates
+	defer s.free()
+
+	long := bytes.Repeat([]byte{0x3b}, 40)
+	rs := []ReaderState{
+		{Reader: "reader 0", CurrentState: StatePresent, Atr: long},
+		{Reader: "reader 1"},
+	}
+	if err := s.set(rs); err != nil {
+		t.Fatal(err)
+	}
+	if n := int(s.states[0].cbAtr); n != len(s.atr(0)) {
+		t.Errorf("cbAtr = %d, want %d", n, len(s.atr(0)))
+	}
+	reader0 := s.states[0].szReader
+
+	rs = append(rs, ReaderState{Reader: "reader 2"})
+	rs[1].Reader = "reader 1'"
+	if err := s.set(rs); err != nil {
+		t.Fatal(err)
+	}
+	if s.states[0].szReader != reader0 {
+		t.Errorf("unchanged reader name was reallocated")
+	}
+	for i := range rs {
+		if got := s.readers[i]; got != rs[i].Reader {
+			t.Errorf("readers[%d] = %q, want %q", i, got, rs[i].Reader)
+		}
+	}
+
+	s.states[2].dwEventState = 0x1234
+	s.states[2].cbAtr = 100
+	s.update(rs)
+	if rs[2].EventState != 0x1234 {
+		t.Errorf("EventState = %v, want %v", rs[2].EventState, StateFlag(0x1234))
+	}
+	if len(rs[2].Atr) != len(s.atr(2)) {
+		t.Errorf("len(Atr) = %d, want %d", len(rs[2].Atr), len(s.atr(2)))
+	}
+	if !bytes.Equal(rs[0].Atr, long) {
+		t.Errorf("Atr = % x, want % x", rs[0].Atr, long)
+	}
+
+	// a new ATR replaces the long one
+	copy(s.atr(0), []byte{0x3b, 0x00})
+	s.states[0].cbAtr = 2
+	s.update(rs)
+	if !bytes.Equal(rs[0].Atr, []byte{0x3b, 0x00}) {
+		t.Errorf("Atr = % x, want 3b 00", rs[0].Atr)
+	}
+}
+
+func TestContextStates(t *testing.T) {
+	const ctx = 0x5ca4d
+	cs := statesOf(ctx)
+
+	// concurrent calls get their own states
+	s0, err := cs.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := cs.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s0 == s1 {
+		t.Fatal("get() returned states in use")
+	}
+	if err := s0.set([]ReaderState{{Reader: "reader 0"}}); err != nil {
+		t.Fatal(err)
+	}
+	cs.put(s0)
+	if s, _ := cs.get(); s != s0 {
+		t.Error("idle states not reused")
+	}
+	cs.put(s0)
+
+	releaseStates(ctx)
+	if s0.states != nil {
+		t.Error("states not freed by releaseStates")
+	}
+	if _, err := cs.get(); err != ErrInvalidHandle {
+		t.Errorf("get() after release = %v, want %v", err, ErrInvalidHandle)
+	}
+	cs.put(s1)
+	if _, ok := sysContexts.m[ctx]; ok {
+		t.Error("released context still has states")
+	}
+}
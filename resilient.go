@@ -0,0 +1,97 @@
+package scard
+
+// maxReconnects bounds the reconnects done for a single command, in case the
+// card keeps being reset.
+const maxReconnects = 3
+
+// ResilientCard wraps a Card and reconnects it when a command fails because
+// the card was reset or powered down by another application, or removed and
+// inserted again.
+//
+// After reconnecting, Init is called to restore the card state lost with the
+// reset, e.g. by selecting the application again. Commands for which
+// Idempotent reports true are then sent again; for other commands the
+// original error is returned, as they may have had an effect before it was
+// reported. The card is ready for the next command either way.
+//
+// Like Card, a ResilientCard must not be used concurrently.
+type ResilientCard struct {
+	card *Card
+
+	// Init re-initializes the card after a reconnect. It may be nil.
+	Init func(card *Card) error
+	// Idempotent reports whether cmd may be sent again after a reconnect. If
+	// nil, IsIdempotent is used.
+	Idempotent func(cmd []byte) bool
+}
+
+// NewResilientCard returns a ResilientCard for card, which reconnects with
+// the share mode and protocols card was connected with.
+func NewResilientCard(card *Card, init func(card *Card) error) *ResilientCard {
+	return &ResilientCard{card: card, Init: init}
+}
+
+// Card returns the wrapped Card.
+func (rc *ResilientCard) Card() *Card {
+	return rc.card
+}
+
+// Transmit is like Card.Transmit but reconnects and retries as described for
+// ResilientCard.
+func (rc *ResilientCard) Transmit(cmd []byte) ([]byte, error) {
+	for i := 0; ; i++ {
+		rsp, err := rc.card.Transmit(cmd)
+		if !needsReconnect(err) || i == maxReconnects {
+			return rsp, err
+		}
+		if err := rc.Recover(); err != nil {
+			return nil, err
+		}
+		if !rc.idempotent(cmd) {
+			return nil, err
+		}
+	}
+}
+
+// Recover reconnects the card with its original share mode and protocols and
+// calls Init.
+func (rc *ResilientCard) Recover() error {
+	if err := rc.card.Reconnect(rc.card.ShareMode(), rc.card.PreferredProtocols(), LeaveCard); err != nil {
+		return err
+	}
+	if rc.Init != nil {
+		return rc.Init(rc.card)
+	}
+	return nil
+}
+
+func (rc *ResilientCard) idempotent(cmd []byte) bool {
+	if rc.Idempotent != nil {
+		return rc.Idempotent(cmd)
+	}
+	return IsIdempotent(cmd)
+}
+
+// needsReconnect reports whether err means the card handle has to be
+// reconnected before it can be used again.
+func needsReconnect(err error) bool {
+	switch errorCode(err) {
+	case ErrResetCard, ErrRemovedCard, ErrUnpoweredCard:
+		return true
+	}
+	return false
+}
+
+// IsIdempotent reports whether the command APDU cmd can be repeated without
+// changing the outcome: the interindustry SELECT, READ BINARY, READ RECORD
+// and GET DATA commands.
+func IsIdempotent(cmd []byte) bool {
+	if len(cmd) < 4 || cmd[0]&0x80 != 0 {
+		return false
+	}
+	switch cmd[1] {
+	case 0xa4, 0xb0, 0xb1, 0xb2, 0xb3, 0xca, 0xcb:
+		return true
+	}
+	return false
+}
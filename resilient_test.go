@@ -0,0 +1,99 @@
+package scard
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestResilientCard(t *testing.T) {
+	fake := newFakeBackend()
+	resets := 0
+	fake.transmit = func(cmd []byte) ([]byte, error) {
+		if resets > 0 {
+			resets--
+			return nil, ErrResetCard
+		}
+		return []byte{0x90, 0x00}, nil
+	}
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(fake.reader, ShareExclusive, ProtocolT1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.ShareMode() != ShareExclusive || card.PreferredProtocols() != ProtocolT1 {
+		t.Fatalf("ShareMode(), PreferredProtocols() = %v, %v", card.ShareMode(), card.PreferredProtocols())
+	}
+
+	inits := 0
+	rc := NewResilientCard(card, func(c *Card) error {
+		inits++
+		return nil
+	})
+
+	readBinary := []byte{0x00, 0xb0, 0x00, 0x00, 0x00}
+	resets = 1
+	rsp, err := rc.Transmit(readBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, []byte{0x90, 0x00}) {
+		t.Fatalf("Transmit() rsp = % x", rsp)
+	}
+	if inits != 1 {
+		t.Fatalf("inits = %d; want 1", inits)
+	}
+
+	updateBinary := []byte{0x00, 0xd6, 0x00, 0x00, 0x01, 0x00}
+	resets = 1
+	if _, err := rc.Transmit(updateBinary); !errors.Is(err, ErrResetCard) {
+		t.Fatalf("Transmit(UPDATE BINARY) = %v; want %v", err, ErrResetCard)
+	}
+	if inits != 2 {
+		t.Fatalf("inits = %d; want 2", inits)
+	}
+	if _, err := rc.Transmit(updateBinary); err != nil {
+		t.Fatal(err)
+	}
+
+	resets = 2 * maxReconnects
+	if _, err := rc.Transmit(readBinary); !errors.Is(err, ErrResetCard) {
+		t.Fatalf("Transmit() = %v; want %v", err, ErrResetCard)
+	}
+
+	var reconnects int
+	for _, c := range fake.calls {
+		if c == "Reconnect" {
+			reconnects++
+		}
+	}
+	if reconnects != 2+maxReconnects {
+		t.Fatalf("reconnects = %d; want %d", reconnects, 2+maxReconnects)
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		cmd  []byte
+		want bool
+	}{
+		{[]byte{0x00, 0xa4, 0x04, 0x00}, true},
+		{[]byte{0x00, 0xb0, 0x00, 0x00, 0x00}, true},
+		{[]byte{0x0c, 0xb2, 0x01, 0x04, 0x00}, true},
+		{[]byte{0x00, 0xca, 0x00, 0x6e, 0x00}, true},
+		{[]byte{0x00, 0xd6, 0x00, 0x00, 0x01, 0x00}, false},
+		{[]byte{0x00, 0x20, 0x00, 0x81}, false},
+		{[]byte{0x00, 0x84, 0x00, 0x00, 0x08}, false},
+		{[]byte{0x80, 0xca, 0x9f, 0x7f, 0x00}, false},
+		{[]byte{0x00, 0xa4}, false},
+	}
+	for _, tt := range tests {
+		if got := IsIdempotent(tt.cmd); got != tt.want {
+			t.Errorf("IsIdempotent(% x) = %v; want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,17 @@
 package scard
 
 import (
+	"context"
+	"sync"
 	"time"
-	"unsafe"
+
+	"github.com/ebfe/scard/apdu"
 )
 
+// PnPNotification is the name of the pseudo reader that can be passed to
+// GetStatusChange to wait for readers being added or removed.
+const PnPNotification = `\\?PnP?\Notification`
+
 type CardStatus struct {
 	Reader         string
 	State          State
@@ -18,126 +25,217 @@ type ReaderState struct {
 	UserData     interface{}
 	CurrentState StateFlag
 	EventState   StateFlag
-	Atr          []byte
+	// Atr holds at most the 33 bytes (36 on Windows) of the
+	// SCARD_READERSTATE structure when set by GetStatusChange. A longer Atr
+	// passed in is kept as long as the card does not change.
+	Atr []byte
 }
 
 type Context struct {
-	ctx uintptr
+	backend Backend
+	ctx     uintptr
+	scope   Scope
+
+	mu     sync.Mutex
+	shards []uintptr // additional contexts used by GetStatusChange
 }
 
 type Card struct {
+	backend        Backend
 	handle         uintptr
+	reader         string
 	activeProtocol Protocol
+	mode           ShareMode
+	protocols      Protocol
+
+	// capabilities of the card, read by Do
+	caps      *apdu.Capabilities
+	capsKnown bool
+
+	// pending is closed when a BeginTransaction abandoned by
+	// WithTransactionContext has finished
+	pending chan struct{}
 }
 
 // wraps SCardEstablishContext
 func EstablishContext() (*Context, error) {
-	ctx, r := scardEstablishContext(ScopeSystem, 0, 0)
-	if r != ErrSuccess {
-		return nil, r
-	}
+	return EstablishContextWithBackend(defaultBackend)
+}
 
-	return &Context{ctx: ctx}, nil
+// EstablishContextWithScope is like EstablishContext but establishes the
+// context with the given scope instead of ScopeSystem. pcsc-lite ignores the
+// scope.
+func EstablishContextWithScope(scope Scope) (*Context, error) {
+	return newContext(defaultBackend, scope)
 }
 
 // wraps SCardIsValidContext
 func (ctx *Context) IsValid() (bool, error) {
-	r := scardIsValidContext(ctx.ctx)
-	switch r {
-	case ErrSuccess:
+	err := ctx.backend.IsValidContext(ctx.ctx)
+	switch err {
+	case nil:
 		return true, nil
 	case ErrInvalidHandle:
 		return false, nil
 	default:
-		return false, r
+		return false, opError("IsValid", "", err)
 	}
 }
 
 // wraps SCardCancel
 func (ctx *Context) Cancel() error {
-	r := scardCancel(ctx.ctx)
-	if r != ErrSuccess {
-		return r
+	return opError("Cancel", "", ctx.cancel())
+}
+
+// cancel cancels the waits on ctx and on the contexts GetStatusChange
+// established for it.
+func (ctx *Context) cancel() error {
+	err := ctx.backend.Cancel(ctx.ctx)
+	ctx.mu.Lock()
+	for _, h := range ctx.shards {
+		ctx.backend.Cancel(h)
 	}
-	return nil
+	ctx.mu.Unlock()
+	return err
 }
 
 // wraps SCardReleaseContext
 func (ctx *Context) Release() error {
-	r := scardReleaseContext(ctx.ctx)
-	if r != ErrSuccess {
-		return r
+	ctx.mu.Lock()
+	shards := ctx.shards
+	ctx.shards = nil
+	ctx.mu.Unlock()
+	for _, h := range shards {
+		ctx.backend.ReleaseContext(h)
 	}
-	return nil
+	return opError("Release", "", ctx.backend.ReleaseContext(ctx.ctx))
 }
 
 // wraps SCardListReaders
 func (ctx *Context) ListReaders() ([]string, error) {
-	needed, r := scardListReaders(ctx.ctx, nil, nil, 0)
-	if r != ErrSuccess {
-		return nil, r
+	readers, err := ctx.backend.ListReaders(ctx.ctx, nil)
+	if err != nil {
+		return nil, opError("ListReaders", "", err)
 	}
+	return readers, nil
+}
 
-	buf := make(strbuf, needed)
-	n, r := scardListReaders(ctx.ctx, nil, buf.ptr(), uint32(len(buf)))
-	if r != ErrSuccess {
-		return nil, r
+// ListReadersInGroups is like ListReaders but only lists the readers in the
+// given reader groups. Without groups all readers are listed. pcsc-lite does
+// not support reader groups and always lists all readers.
+func (ctx *Context) ListReadersInGroups(groups ...string) ([]string, error) {
+	if len(groups) == 0 {
+		groups = nil
 	}
-	return decodemstr(buf[:n]), nil
+	readers, err := ctx.backend.ListReaders(ctx.ctx, groups)
+	if err != nil {
+		return nil, opError("ListReaders", "", err)
+	}
+	return readers, nil
 }
 
 // wraps SCardListReaderGroups
 func (ctx *Context) ListReaderGroups() ([]string, error) {
-	needed, r := scardListReaderGroups(ctx.ctx, nil, 0)
-	if r != ErrSuccess {
-		return nil, r
-	}
-
-	buf := make(strbuf, needed)
-	n, r := scardListReaderGroups(ctx.ctx, buf.ptr(), uint32(len(buf)))
-	if r != ErrSuccess {
-		return nil, r
+	groups, err := ctx.backend.ListReaderGroups(ctx.ctx)
+	if err != nil {
+		return nil, opError("ListReaderGroups", "", err)
 	}
-	return decodemstr(buf[:n]), nil
+	return groups, nil
 }
 
 // wraps SCardGetStatusChange
+//
+// libpcsclite accepts at most 16 reader states per call. If the backend is
+// a ReaderStateLimiter, larger sets are split across additional contexts,
+// which are waited on concurrently; the call returns as soon as one of them
+// reports a change.
 func (ctx *Context) GetStatusChange(readerStates []ReaderState, timeout time.Duration) error {
+	return opError("GetStatusChange", "", ctx.getStatusChange(timeout, readerStates))
+}
 
-	dwTimeout := durationToTimeout(timeout)
-	states := make([]scardReaderState, len(readerStates))
-
-	for i := range readerStates {
-		var err error
-		states[i], err = readerStates[i].toSys()
-		if err != nil {
-			return err
-		}
+// GetStatusChangeContext is like GetStatusChange but waits until a change
+// occurs or c is done. When c is done the wait is aborted with SCardCancel
+// and the returned error matches both c.Err() and ErrCancelled with
+// errors.Is.
+func (ctx *Context) GetStatusChangeContext(c context.Context, readerStates []ReaderState) error {
+	if err := c.Err(); err != nil {
+		return opError("GetStatusChange", "", &contextError{err})
 	}
 
-	r := scardGetStatusChange(ctx.ctx, dwTimeout, states)
-	if r != ErrSuccess {
-		return r
+	timeout := time.Duration(-1)
+	deadline, hasDeadline := c.Deadline()
+	if hasDeadline {
+		timeout = time.Until(deadline)
 	}
 
-	for i := range readerStates {
-		(&readerStates[i]).update(&states[i])
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-c.Done():
+		case <-done:
+			return
+		}
+		// a cancel before the wait has started is lost, so repeat it
+		// until the wait returns
+		backoff := cancelBackoffMin
+		for {
+			ctx.cancel()
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-done:
+				t.Stop()
+				return
+			}
+			if backoff *= 2; backoff > cancelBackoffMax {
+				backoff = cancelBackoffMax
+			}
+		}
+	}()
+
+	err := ctx.getStatusChange(timeout, readerStates)
+	close(done)
+	<-stopped
+
+	switch {
+	case err == ErrTimeout && hasDeadline:
+		// the timeout is rounded to milliseconds and may expire slightly
+		// before c does
+		<-c.Done()
+		err = &contextError{c.Err()}
+	case err == ErrCancelled && c.Err() != nil:
+		err = &contextError{c.Err()}
 	}
+	return opError("GetStatusChange", "", err)
+}
 
-	return nil
+// contextError is returned by GetStatusChangeContext and
+// WithTransactionContext when the wait is ended by their context.
+type contextError struct {
+	err error
+}
+
+func (e *contextError) Error() string {
+	return e.err.Error()
+}
+
+func (e *contextError) Unwrap() error {
+	return e.err
+}
+
+func (e *contextError) Is(target error) bool {
+	return target == ErrCancelled
 }
 
 // wraps SCardConnect
 func (ctx *Context) Connect(reader string, mode ShareMode, proto Protocol) (*Card, error) {
-	creader, err := encodestr(reader)
+	handle, activeProtocol, err := ctx.backend.Connect(ctx.ctx, reader, mode, proto)
 	if err != nil {
-		return nil, err
-	}
-	handle, activeProtocol, r := scardConnect(ctx.ctx, creader.ptr(), mode, proto)
-	if r != ErrSuccess {
-		return nil, r
+		return nil, opError("Connect", reader, err)
 	}
-	return &Card{handle: handle, activeProtocol: activeProtocol}, nil
+	return &Card{backend: ctx.backend, handle: handle, reader: reader, activeProtocol: activeProtocol, mode: mode, protocols: proto}, nil
 }
 
 // the protocol being used
@@ -145,77 +243,115 @@ func (card *Card) ActiveProtocol() Protocol {
 	return card.activeProtocol
 }
 
+// the share mode passed to the last Connect or Reconnect
+func (card *Card) ShareMode() ShareMode {
+	return card.mode
+}
+
+// the preferred protocols passed to the last Connect or Reconnect
+func (card *Card) PreferredProtocols() Protocol {
+	return card.protocols
+}
+
 // wraps SCardDisconnect
 func (card *Card) Disconnect(d Disposition) error {
-	r := scardDisconnect(card.handle, d)
-	if r != ErrSuccess {
-		return r
-	}
-	return nil
+	card.settle()
+	return card.opError("Disconnect", card.backend.Disconnect(card.handle, d))
 }
 
 // wraps SCardReconnect
 func (card *Card) Reconnect(mode ShareMode, proto Protocol, disp Disposition) error {
-	activeProtocol, r := scardReconnect(card.handle, mode, proto, disp)
-	if r != ErrSuccess {
-		return r
+	card.settle()
+	activeProtocol, err := card.backend.Reconnect(card.handle, mode, proto, disp)
+	if err != nil {
+		return card.opError("Reconnect", err)
 	}
 	card.activeProtocol = activeProtocol
+	card.mode = mode
+	card.protocols = proto
+	card.caps, card.capsKnown = nil, false
 	return nil
 }
 
 // wraps SCardBeginTransaction
 func (card *Card) BeginTransaction() error {
-	r := scardBeginTransaction(card.handle)
-	if r != ErrSuccess {
-		return r
-	}
-	return nil
+	card.settle()
+	return card.opError("BeginTransaction", card.backend.BeginTransaction(card.handle))
 }
 
 // wraps SCardEndTransaction
 func (card *Card) EndTransaction(disp Disposition) error {
-	r := scardEndTransaction(card.handle, disp)
-	if r != ErrSuccess {
-		return r
-	}
-	return nil
+	card.settle()
+	return card.opError("EndTransaction", card.backend.EndTransaction(card.handle, disp))
 }
 
 // wraps SCardStatus
 func (card *Card) Status() (*CardStatus, error) {
+	status, err := card.backend.Status(card.handle)
+	if err != nil {
+		return nil, card.opError("Status", err)
+	}
+	return status, nil
+}
 
-	var readerBuf = make(strbuf, maxReadername+1)
-	var atrBuf = make([]byte, maxAtrSize)
+// bufPool holds maxBufferSizeExtended sized buffers for the responses of
+// Transmit and Control.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, maxBufferSizeExtended)
+		return &buf
+	},
+}
 
-	readerLen, state, proto, atrLen, err := scardCardStatus(card.handle, readerBuf, atrBuf)
-	if err == ErrInsufficientBuffer {
-		if uint32(len(readerBuf)) < readerLen {
-			readerBuf = make(strbuf, readerLen)
-		}
-		if uint32(len(atrBuf)) < atrLen {
-			atrBuf = make([]byte, atrLen)
-		}
-		readerLen, state, proto, atrLen, err = scardCardStatus(card.handle, readerBuf, atrBuf)
-	}
+// wraps SCardTransmit
+func (card *Card) Transmit(cmd []byte) ([]byte, error) {
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
 
-	if err != ErrSuccess {
-		return nil, err
+	n, err := card.backend.Transmit(card.handle, card.activeProtocol, cmd, *buf)
+	if err != nil {
+		return nil, card.opError("Transmit", err)
 	}
+	rsp := make([]byte, n)
+	copy(rsp, *buf)
+	return rsp, nil
+}
 
-	reader := decodemstr(readerBuf[:readerLen])
-
-	return &CardStatus{Reader: reader[0], State: state, ActiveProtocol: proto, Atr: atrBuf[:atrLen]}, nil
+// TransmitInto is like Transmit but writes the response to rsp and returns
+// its length. It fails with ErrInsufficientBuffer if the response does not
+// fit.
+func (card *Card) TransmitInto(cmd, rsp []byte) (int, error) {
+	n, err := card.backend.Transmit(card.handle, card.activeProtocol, cmd, rsp)
+	if err != nil {
+		return 0, card.opError("Transmit", err)
+	}
+	return n, nil
 }
 
-// wraps SCardTransmit
-func (card *Card) Transmit(cmd []byte) ([]byte, error) {
-	rsp := make([]byte, maxBufferSizeExtended)
-	rspLen, err := scardTransmit(card.handle, card.activeProtocol, cmd, rsp)
-	if err != ErrSuccess {
-		return nil, err
+// TransmitPCI is like TransmitInto but sends the command with the protocol
+// control information send instead of that of the active protocol and
+// returns the protocol control information received with the response.
+// Use it for ProtocolRaw, cards connected with ShareDirect, or readers that
+// expect protocol specific data after the SCARD_IO_REQUEST header.
+//
+// Backends that do not implement PCITransmitter only support send without
+// Extra data.
+func (card *Card) TransmitPCI(send *IORequest, cmd, rsp []byte) (int, *IORequest, error) {
+	if b, ok := card.backend.(PCITransmitter); ok {
+		n, recv, err := b.TransmitPCI(card.handle, send, cmd, rsp)
+		if err != nil {
+			return 0, nil, card.opError("Transmit", err)
+		}
+		return n, recv, nil
+	}
+	if len(send.Extra) != 0 {
+		return 0, nil, card.opError("Transmit", ErrUnsupportedFeature)
+	}
+	n, err := card.backend.Transmit(card.handle, send.Protocol, cmd, rsp)
+	if err != nil {
+		return 0, nil, card.opError("Transmit", err)
 	}
-	return rsp[:rspLen], nil
+	return n, &IORequest{Protocol: send.Protocol}, nil
 }
 
 // C macro SCARD_CTL_CODE() equivalent to Compute IOCTL codes to be used with Control()
@@ -225,36 +361,44 @@ func CtlCode(code uint16) uint32 {
 
 // wraps SCardControl
 func (card *Card) Control(ioctl uint32, in []byte) ([]byte, error) {
-	var out [0xffff]byte
-	outLen, err := scardControl(card.handle, ioctl, in, out[:])
-	if err != ErrSuccess {
-		return nil, err
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+
+	n, err := card.backend.Control(card.handle, ioctl, in, (*buf)[:0xffff])
+	if err != nil {
+		return nil, card.opError("Control", err)
 	}
-	return out[:outLen], nil
+	out := make([]byte, n)
+	copy(out, *buf)
+	return out, nil
 }
 
-// wraps SCardGetAttrib
-func (card *Card) GetAttrib(id Attrib) ([]byte, error) {
-	needed, err := scardGetAttrib(card.handle, id, nil)
-	if err != ErrSuccess {
-		return nil, err
+// ControlInto is like Control but writes the output to out and returns its
+// length. It fails with ErrInsufficientBuffer if the output does not fit.
+func (card *Card) ControlInto(ioctl uint32, in, out []byte) (int, error) {
+	n, err := card.backend.Control(card.handle, ioctl, in, out)
+	if err != nil {
+		return 0, card.opError("Control", err)
 	}
+	return n, nil
+}
 
-	var attrib = make([]byte, needed)
-	n, err := scardGetAttrib(card.handle, id, attrib)
-	if err != ErrSuccess {
-		return nil, err
+// wraps SCardGetAttrib
+func (card *Card) GetAttrib(id Attrib) ([]byte, error) {
+	data, err := card.backend.GetAttrib(card.handle, id)
+	if err != nil {
+		return nil, card.opError("GetAttrib", err)
 	}
-	return attrib[:n], nil
+	return data, nil
 }
 
 // wraps SCardSetAttrib
 func (card *Card) SetAttrib(id Attrib, data []byte) error {
-	err := scardSetAttrib(card.handle, id, data)
-	if err != ErrSuccess {
-		return err
-	}
-	return nil
+	return card.opError("SetAttrib", card.backend.SetAttrib(card.handle, id, data))
+}
+
+func (card *Card) opError(op string, err error) error {
+	return opError(op, card.reader, err)
 }
 
 func durationToTimeout(timeout time.Duration) uint32 {
@@ -267,44 +411,3 @@ func durationToTimeout(timeout time.Duration) uint32 {
 		return uint32(timeout / time.Millisecond)
 	}
 }
-
-func (buf strbuf) ptr() unsafe.Pointer {
-	return unsafe.Pointer(&buf[0])
-}
-
-func (buf strbuf) split() []strbuf {
-	var chunks []strbuf
-	for len(buf) > 0 && buf[0] != 0 {
-		i := 0
-		for i = range buf {
-			if buf[i] == 0 {
-				break
-			}
-		}
-		chunks = append(chunks, buf[:i+1])
-		buf = buf[i+1:]
-	}
-
-	return chunks
-}
-
-func encodemstr(strings ...string) (strbuf, error) {
-	var buf strbuf
-	for _, s := range strings {
-		utf16, err := encodestr(s)
-		if err != nil {
-			return nil, err
-		}
-		buf = append(buf, utf16...)
-	}
-	buf = append(buf, 0)
-	return buf, nil
-}
-
-func decodemstr(buf strbuf) []string {
-	var strings []string
-	for _, chunk := range buf.split() {
-		strings = append(strings, decodestr(chunk))
-	}
-	return strings
-}
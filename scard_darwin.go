@@ -5,19 +5,16 @@ package scard
 
 // #cgo LDFLAGS: -framework PCSC
 // #include <stdlib.h>
+// #include <string.h>
 // #include <PCSC/winscard.h>
 // #include <PCSC/wintypes.h>
 import "C"
 
 import (
-	"sync"
+	"encoding/binary"
 	"unsafe"
 )
 
-func (e Error) Error() string {
-	return "scard: " + C.GoString(C.pcsc_stringify_error(C.int32_t(e)))
-}
-
 // Version returns the libpcsclite version string
 func Version() string {
 	return C.PCSCLITE_VERSION_NUMBER
@@ -27,9 +24,9 @@ func scardCtlCode(code uint16) uint32 {
 	return 0x42000000 + uint32(code)
 }
 
-func scardEstablishContext(scope Scope, reserved1, reserved2 uintptr) (uintptr, Error) {
+func scardEstablishContext(scope Scope) (uintptr, Error) {
 	var ctx C.SCARDCONTEXT
-	r := C.SCardEstablishContext(C.uint32_t(scope), unsafe.Pointer(reserved1), unsafe.Pointer(reserved2), &ctx)
+	r := C.SCardEstablishContext(C.uint32_t(scope), nil, nil, &ctx)
 	return uintptr(ctx), Error(r)
 }
 
@@ -60,22 +57,6 @@ func scardListReaderGroups(ctx uintptr, buf unsafe.Pointer, bufLen uint32) (uint
 	return uint32(dwBufLen), Error(r)
 }
 
-func scardGetStatusChange(ctx uintptr, timeout uint32, states []scardReaderState) Error {
-	// In darwin, the LPSCARD_READERSTATE_A has 1 byte alignment and hence
-	// has no trailing padding. Go does add 3 bytes of padding (on both 32
-	// and 64 bits), so we pack an array manually instead.
-	const size = int(unsafe.Sizeof(states[0])) - 3
-	buf := make([]byte, size*len(states))
-	for i := range states {
-		copy(buf[i*size:(i+1)*size], (*(*[size]byte)(unsafe.Pointer(&states[i])))[:])
-	}
-	r := C.SCardGetStatusChange(C.SCARDCONTEXT(ctx), C.uint32_t(timeout), (C.LPSCARD_READERSTATE_A)(unsafe.Pointer(&buf[0])), C.uint32_t(len(states)))
-	for i := range states {
-		copy((*(*[size]byte)(unsafe.Pointer(&states[i])))[:], buf[i*size:(i+1)*size])
-	}
-	return Error(r)
-}
-
 func scardConnect(ctx uintptr, reader unsafe.Pointer, shareMode ShareMode, proto Protocol) (uintptr, Protocol, Error) {
 	var handle C.SCARDHANDLE
 	var activeProto C.uint32_t
@@ -119,30 +100,70 @@ func scardCardStatus(card uintptr, readerBuf strbuf, atrBuf []byte) (uint32, Sta
 func scardTransmit(card uintptr, proto Protocol, cmd []byte, rsp []byte) (uint32, Error) {
 	var sendpci C.SCARD_IO_REQUEST
 	var recvpci C.SCARD_IO_REQUEST
+	var ptrCmd, ptrRsp *C.uchar
 	var rspLen = C.uint32_t(len(rsp))
 
-	switch proto {
-	case ProtocolT0, ProtocolT1:
-		sendpci.dwProtocol = C.uint32_t(proto)
-	default:
-		panic("unknown protocol")
+	if len(cmd) != 0 {
+		ptrCmd = (*C.uchar)(&cmd[0])
 	}
+	if len(rsp) != 0 {
+		ptrRsp = (*C.uchar)(&rsp[0])
+	}
+
+	sendpci.dwProtocol = C.uint32_t(proto)
 	sendpci.cbPciLength = C.sizeof_SCARD_IO_REQUEST
 
-	r := C.SCardTransmit(C.SCARDHANDLE(card), &sendpci, (*C.uchar)(&cmd[0]), C.uint32_t(len(cmd)), &recvpci, (*C.uchar)(&rsp[0]), &rspLen)
+	r := C.SCardTransmit(C.SCARDHANDLE(card), &sendpci, ptrCmd, C.uint32_t(len(cmd)), &recvpci, ptrRsp, &rspLen)
 
 	return uint32(rspLen), Error(r)
 }
 
+func scardTransmitPCI(card uintptr, send *IORequest, cmd []byte, rsp []byte) (uint32, *IORequest, Error) {
+	var ptrCmd, ptrRsp *C.uchar
+	var rspLen = C.uint32_t(len(rsp))
+
+	if len(cmd) != 0 {
+		ptrCmd = (*C.uchar)(&cmd[0])
+	}
+	if len(rsp) != 0 {
+		ptrRsp = (*C.uchar)(&rsp[0])
+	}
+
+	// the PCI header is followed by protocol specific data
+	const hdrLen = C.sizeof_SCARD_IO_REQUEST
+	sendpci := (*C.SCARD_IO_REQUEST)(C.malloc(C.size_t(hdrLen + len(send.Extra))))
+	defer C.free(unsafe.Pointer(sendpci))
+	sendpci.dwProtocol = C.uint32_t(send.Protocol)
+	sendpci.cbPciLength = C.uint32_t(hdrLen + len(send.Extra))
+	copy(unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(sendpci), hdrLen)), len(send.Extra)), send.Extra)
+
+	recvpci := (*C.SCARD_IO_REQUEST)(C.malloc(C.size_t(hdrLen + maxPCIExtra)))
+	defer C.free(unsafe.Pointer(recvpci))
+	recvpci.dwProtocol = C.uint32_t(send.Protocol)
+	recvpci.cbPciLength = C.uint32_t(hdrLen + maxPCIExtra)
+
+	r := C.SCardTransmit(C.SCARDHANDLE(card), sendpci, ptrCmd, C.uint32_t(len(cmd)), recvpci, ptrRsp, &rspLen)
+
+	recv := &IORequest{Protocol: Protocol(recvpci.dwProtocol)}
+	if n := int(recvpci.cbPciLength) - hdrLen; n > 0 && n <= maxPCIExtra {
+		recv.Extra = C.GoBytes(unsafe.Add(unsafe.Pointer(recvpci), hdrLen), C.int(n))
+	}
+	return uint32(rspLen), recv, Error(r)
+}
+
 func scardControl(card uintptr, ioctl uint32, in, out []byte) (uint32, Error) {
 	var ptrIn unsafe.Pointer
+	var ptrOut unsafe.Pointer
 	var outLen = C.uint32_t(len(out))
 
 	if len(in) != 0 {
 		ptrIn = unsafe.Pointer(&in[0])
 	}
+	if len(out) != 0 {
+		ptrOut = unsafe.Pointer(&out[0])
+	}
 
-	r := C.SCardControl(C.SCARDHANDLE(card), C.uint32_t(ioctl), ptrIn, C.uint32_t(len(in)), unsafe.Pointer(&out[0]), C.uint32_t(len(out)), &outLen)
+	r := C.SCardControl(C.SCARDHANDLE(card), C.uint32_t(ioctl), ptrIn, C.uint32_t(len(in)), ptrOut, C.uint32_t(len(out)), &outLen)
 	return uint32(outLen), Error(r)
 }
 
@@ -183,45 +204,94 @@ func decodestr(buf strbuf) string {
 	return string(buf)
 }
 
-type scardReaderState struct {
-	szReader       uintptr
-	pvUserData     uintptr
-	dwCurrentState uint32
-	dwEventState   uint32
-	cbAtr          uint32
-	rgbAtr         [33]byte
+// In darwin, SCARD_READERSTATE_A has 1 byte alignment, so its fields are
+// accessed at fixed offsets instead of through cgo. Pointers are 8 bytes on
+// all supported darwin platforms.
+const (
+	rsReader       = 0
+	rsCurrentState = 16
+	rsEventState   = rsCurrentState + 4
+	rsAtrLen       = rsEventState + 4
+	rsAtr          = rsAtrLen + 4
+	rsAtrSize      = 33
+	rsSize         = rsAtr + rsAtrSize
+)
+
+// MaxReaderStates returns the number of reader states the PCSC framework,
+// derived from pcsc-lite, accepts in a single SCardGetStatusChange call.
+func (sysBackend) MaxReaderStates() int {
+	return 16
 }
 
-var pinned = map[string]*strbuf{}
-var mutex sync.Mutex
+// readerStates is the SCARD_READERSTATE_A array passed to
+// SCardGetStatusChange. The array and the reader names it points to are
+// allocated in C memory, as cgo does not allow Go memory passed to C to
+// contain Go pointers.
+type readerStates struct {
+	buf     unsafe.Pointer // C array of len(readers) states
+	readers []*C.char
+	names   []string
+}
 
-func (rs *ReaderState) toSys() (scardReaderState, error) {
-	var sys scardReaderState
+// set fills the first len(rs) states from rs, reusing the reader names of
+// the previous call where they did not change. An ATR longer than rgbAtr is
+// passed truncated; update keeps the complete one if it did not change.
+func (s *readerStates) set(rs []ReaderState) error {
+	if len(rs) > len(s.readers) {
+		s.grow(len(rs))
+	}
+	for i := range rs {
+		if s.readers[i] == nil || s.names[i] != rs[i].Reader {
+			C.free(unsafe.Pointer(s.readers[i]))
+			s.readers[i] = C.CString(rs[i].Reader)
+			s.names[i] = rs[i].Reader
+		}
+		e := s.entry(i)
+		binary.LittleEndian.PutUint64(e[rsReader:], uint64(uintptr(unsafe.Pointer(s.readers[i]))))
+		binary.LittleEndian.PutUint32(e[rsCurrentState:], uint32(rs[i].CurrentState))
+		binary.LittleEndian.PutUint32(e[rsEventState:], 0)
+		binary.LittleEndian.PutUint32(e[rsAtrLen:], uint32(copy(e[rsAtr:rsSize], rs[i].Atr)))
+	}
+	return nil
+}
 
-	creader, err := encodestr(rs.Reader)
-	if err != nil {
-		return scardReaderState{}, err
+func (s *readerStates) grow(n int) {
+	buf := C.calloc(C.size_t(n), C.size_t(rsSize))
+	if len(s.readers) > 0 {
+		C.memcpy(buf, s.buf, C.size_t(len(s.readers)*rsSize))
+		C.free(s.buf)
 	}
+	s.buf = buf
+	s.readers = append(s.readers, make([]*C.char, n-len(s.readers))...)
+	s.names = append(s.names, make([]string, n-len(s.names))...)
+}
 
-	mutex.Lock()
-	pinned[rs.Reader] = &creader
-	mutex.Unlock()
+// entry returns the bytes of the i-th state.
+func (s *readerStates) entry(i int) []byte {
+	return unsafe.Slice((*byte)(unsafe.Add(s.buf, i*rsSize)), rsSize)
+}
 
-	sys.szReader = uintptr(creader.ptr())
-	sys.dwCurrentState = uint32(rs.CurrentState)
-	sys.cbAtr = uint32(len(rs.Atr))
-	for i, v := range rs.Atr {
-		sys.rgbAtr[i] = byte(v)
-	}
-	return sys, nil
+func (s *readerStates) getStatusChange(ctx uintptr, timeout uint32, n int) Error {
+	r := C.SCardGetStatusChange(C.SCARDCONTEXT(ctx), C.uint32_t(timeout), (C.LPSCARD_READERSTATE_A)(s.buf), C.uint32_t(n))
+	return Error(r)
 }
 
-func (rs *ReaderState) update(sys *scardReaderState) {
-	rs.EventState = StateFlag(sys.dwEventState)
-	if sys.cbAtr > 0 {
-		rs.Atr = make([]byte, int(sys.cbAtr))
-		for i := 0; i < int(sys.cbAtr); i++ {
-			rs.Atr[i] = byte(sys.rgbAtr[i])
+// update copies the event states and ATRs of the first len(rs) states to
+// rs.
+func (s *readerStates) update(rs []ReaderState) {
+	for i := range rs {
+		e := s.entry(i)
+		rs[i].EventState = StateFlag(binary.LittleEndian.Uint32(e[rsEventState:]))
+		if n := int(binary.LittleEndian.Uint32(e[rsAtrLen:])); n > 0 {
+			rs[i].Atr = stateAtr(rs[i].Atr, e[rsAtr:rsSize], n)
 		}
 	}
 }
+
+func (s *readerStates) free() {
+	for _, r := range s.readers {
+		C.free(unsafe.Pointer(r))
+	}
+	C.free(s.buf)
+	*s = readerStates{}
+}
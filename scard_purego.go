@@ -0,0 +1,25 @@
+//go:build !windows && !darwin && (!cgo || purego)
+// +build !windows
+// +build !darwin
+// +build !cgo purego
+
+package scard
+
+import (
+	"fmt"
+
+	"github.com/ebfe/scard/internal/pcsclite"
+)
+
+// Without cgo (or with the purego build tag) the pcsc-lite protocol is
+// spoken directly to pcscd.
+var defaultBackend Backend = NewPCSCLiteBackend("")
+
+// Version returns the pcsc-lite protocol version spoken to pcscd
+func Version() string {
+	return fmt.Sprintf("%d.%d", pcsclite.ProtocolVersionMajor, pcsclite.ProtocolVersionMinor)
+}
+
+func scardCtlCode(code uint16) uint32 {
+	return 0x42000000 + uint32(code)
+}
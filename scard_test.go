@@ -1,12 +1,14 @@
 package scard
 
 import (
+	"errors"
+	"fmt"
 	"runtime"
 	"testing"
 )
 
-// FIXME: all tests assume pcscd is running, and there is a single reader with a
-// card present
+// The tests use the first reader with a card present. Without pcscd, a
+// reader or a card they fall back to a simulated card where supported.
 
 type testCard struct {
 	ctx  *Context
@@ -14,40 +16,53 @@ type testCard struct {
 }
 
 func setup(t *testing.T) *testCard {
-	var ctx *Context
-	var card *Card
-
 	ctx, err := EstablishContext()
 	if err != nil {
-		t.Skipf("EstablishContext: %s", err)
-		t.SkipNow()
+		return setupSimulator(t, fmt.Sprintf("EstablishContext: %s", err))
 	}
 
-	defer func() {
-		if card == nil {
-			ctx.Release()
-		}
-	}()
-
 	readers, err := ctx.ListReaders()
+	if errors.Is(err, ErrNoReadersAvailable) || (err == nil && len(readers) == 0) {
+		ctx.Release()
+		return setupSimulator(t, "no smartcard reader found")
+	}
 	if err != nil {
-		t.Error(err)
-		t.FailNow()
+		ctx.Release()
+		t.Fatal(err)
 	}
 
-	if len(readers) == 0 {
-		t.Skip("no smartcard reader found")
-		t.SkipNow()
+	card, err := ctx.Connect(readers[0], ShareExclusive, ProtocolAny)
+	if err != nil {
+		ctx.Release()
+		return setupSimulator(t, "no smartcard found")
 	}
+	return &testCard{ctx: ctx, card: card}
+}
+
+func setupSimulator(t *testing.T, reason string) *testCard {
+	ctx := simulatorContext(t, reason)
 
-	card, err = ctx.Connect(readers[0], ShareExclusive, ProtocolAny)
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		ctx.Release()
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(readers[0], ShareExclusive, ProtocolAny)
 	if err != nil {
-		t.Skip("no smartcard found")
-		t.SkipNow()
+		ctx.Release()
+		t.Fatal(err)
 	}
 	return &testCard{ctx: ctx, card: card}
 }
 
+func establishContext(t *testing.T) *Context {
+	ctx, err := EstablishContext()
+	if err != nil {
+		return simulatorContext(t, fmt.Sprintf("EstablishContext: %s", err))
+	}
+	return ctx
+}
+
 func teardown(c *testCard) {
 	if c.card != nil {
 		c.card.Disconnect(LeaveCard)
@@ -59,11 +74,7 @@ func teardown(c *testCard) {
 }
 
 func TestListReaders(t *testing.T) {
-	ctx, err := EstablishContext()
-	if err != nil {
-		t.Skipf("EstablishContext: %s", err)
-		t.SkipNow()
-	}
+	ctx := establishContext(t)
 	defer ctx.Release()
 	readers, err := ctx.ListReaders()
 	if err != nil {
@@ -75,11 +86,7 @@ func TestListReaders(t *testing.T) {
 }
 
 func TestListReaderGroups(t *testing.T) {
-	ctx, err := EstablishContext()
-	if err != nil {
-		t.Skipf("EstablishContext: %s", err)
-		t.SkipNow()
-	}
+	ctx := establishContext(t)
 	defer ctx.Release()
 	groups, err := ctx.ListReaderGroups()
 	if err != nil {
@@ -117,12 +124,9 @@ func TestControl(t *testing.T) {
 	rsp, err := c.card.Control(ioctl, nil)
 	if err != nil {
 		// skip on unsupported control code errors
-		if runtime.GOOS == "windows" {
-			if err, ok := err.(Error); ok {
-				if uintptr(err) == 1 {
-					t.Skip()
-				}
-			}
+		var code Error
+		if runtime.GOOS == "windows" && errors.As(err, &code) && code == 1 {
+			t.Skip()
 		}
 		t.Fatal(err)
 	}
@@ -1,5 +1,5 @@
-//go:build !windows && !darwin
-// +build !windows,!darwin
+//go:build !windows && !darwin && cgo && !purego
+// +build !windows,!darwin,cgo,!purego
 
 package scard
 
@@ -14,14 +14,9 @@ package scard
 import "C"
 
 import (
-	"sync"
 	"unsafe"
 )
 
-func (e Error) Error() string {
-	return "scard: " + C.GoString(C.pcsc_stringify_error(C.LONG(e)))
-}
-
 // Version returns the libpcsclite version string
 func Version() string {
 	return C.PCSCLITE_VERSION_NUMBER
@@ -31,9 +26,9 @@ func scardCtlCode(code uint16) uint32 {
 	return 0x42000000 + uint32(code)
 }
 
-func scardEstablishContext(scope Scope, reserved1, reserved2 uintptr) (uintptr, Error) {
+func scardEstablishContext(scope Scope) (uintptr, Error) {
 	var ctx C.SCARDCONTEXT
-	r := C.SCardEstablishContext(C.DWORD(scope), C.LPCVOID(reserved1), C.LPCVOID(reserved2), &ctx)
+	r := C.SCardEstablishContext(C.DWORD(scope), nil, nil, &ctx)
 	return uintptr(ctx), Error(r)
 }
 
@@ -64,11 +59,6 @@ func scardListReaderGroups(ctx uintptr, buf unsafe.Pointer, bufLen uint32) (uint
 	return uint32(dwBufLen), Error(r)
 }
 
-func scardGetStatusChange(ctx uintptr, timeout uint32, states []scardReaderState) Error {
-	r := C.wrapSCardGetStatusChange(C.SCARDCONTEXT(ctx), C.DWORD(timeout), (*C.SCARD_READERSTATE)(unsafe.Pointer(&states[0])), C.DWORD(len(states)))
-	return Error(r)
-}
-
 func scardConnect(ctx uintptr, reader unsafe.Pointer, shareMode ShareMode, proto Protocol) (uintptr, Protocol, Error) {
 	var handle C.SCARDHANDLE
 	var activeProto C.DWORD
@@ -112,30 +102,70 @@ func scardCardStatus(card uintptr, readerBuf []byte, atrBuf []byte) (uint32, Sta
 func scardTransmit(card uintptr, proto Protocol, cmd []byte, rsp []byte) (uint32, Error) {
 	var sendpci C.SCARD_IO_REQUEST
 	var recvpci C.SCARD_IO_REQUEST
+	var ptrCmd, ptrRsp *C.BYTE
 	var rspLen = C.DWORD(len(rsp))
 
-	switch proto {
-	case ProtocolT0, ProtocolT1:
-		sendpci.dwProtocol = C.ulong(proto)
-	default:
-		panic("unknown protocol")
+	if len(cmd) != 0 {
+		ptrCmd = (*C.BYTE)(&cmd[0])
+	}
+	if len(rsp) != 0 {
+		ptrRsp = (*C.BYTE)(&rsp[0])
 	}
+
+	sendpci.dwProtocol = C.ulong(proto)
 	sendpci.cbPciLength = C.sizeof_SCARD_IO_REQUEST
 
-	r := C.SCardTransmit(C.SCARDHANDLE(card), &sendpci, (*C.BYTE)(&cmd[0]), C.DWORD(len(cmd)), &recvpci, (*C.BYTE)(&rsp[0]), &rspLen)
+	r := C.SCardTransmit(C.SCARDHANDLE(card), &sendpci, ptrCmd, C.DWORD(len(cmd)), &recvpci, ptrRsp, &rspLen)
 
 	return uint32(rspLen), Error(r)
 }
 
+func scardTransmitPCI(card uintptr, send *IORequest, cmd []byte, rsp []byte) (uint32, *IORequest, Error) {
+	var ptrCmd, ptrRsp *C.BYTE
+	var rspLen = C.DWORD(len(rsp))
+
+	if len(cmd) != 0 {
+		ptrCmd = (*C.BYTE)(&cmd[0])
+	}
+	if len(rsp) != 0 {
+		ptrRsp = (*C.BYTE)(&rsp[0])
+	}
+
+	// the PCI header is followed by protocol specific data
+	const hdrLen = C.sizeof_SCARD_IO_REQUEST
+	sendpci := (*C.SCARD_IO_REQUEST)(C.malloc(C.size_t(hdrLen + len(send.Extra))))
+	defer C.free(unsafe.Pointer(sendpci))
+	sendpci.dwProtocol = C.ulong(send.Protocol)
+	sendpci.cbPciLength = C.ulong(hdrLen + len(send.Extra))
+	copy(unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(sendpci), hdrLen)), len(send.Extra)), send.Extra)
+
+	recvpci := (*C.SCARD_IO_REQUEST)(C.malloc(C.size_t(hdrLen + maxPCIExtra)))
+	defer C.free(unsafe.Pointer(recvpci))
+	recvpci.dwProtocol = C.ulong(send.Protocol)
+	recvpci.cbPciLength = C.ulong(hdrLen + maxPCIExtra)
+
+	r := C.SCardTransmit(C.SCARDHANDLE(card), sendpci, ptrCmd, C.DWORD(len(cmd)), recvpci, ptrRsp, &rspLen)
+
+	recv := &IORequest{Protocol: Protocol(recvpci.dwProtocol)}
+	if n := int(recvpci.cbPciLength) - hdrLen; n > 0 && n <= maxPCIExtra {
+		recv.Extra = C.GoBytes(unsafe.Add(unsafe.Pointer(recvpci), hdrLen), C.int(n))
+	}
+	return uint32(rspLen), recv, Error(r)
+}
+
 func scardControl(card uintptr, ioctl uint32, in, out []byte) (uint32, Error) {
 	var ptrIn C.LPCVOID
+	var ptrOut C.LPVOID
 	var outLen = C.DWORD(len(out))
 
 	if len(in) != 0 {
 		ptrIn = C.LPCVOID(unsafe.Pointer(&in[0]))
 	}
+	if len(out) != 0 {
+		ptrOut = C.LPVOID(unsafe.Pointer(&out[0]))
+	}
 
-	r := C.SCardControl(C.SCARDHANDLE(card), C.DWORD(ioctl), ptrIn, C.DWORD(len(in)), (C.LPVOID)(unsafe.Pointer(&out[0])), C.DWORD(len(out)), &outLen)
+	r := C.SCardControl(C.SCARDHANDLE(card), C.DWORD(ioctl), ptrIn, C.DWORD(len(in)), ptrOut, C.DWORD(len(out)), &outLen)
 	return uint32(outLen), Error(r)
 }
 
@@ -176,45 +206,85 @@ func decodestr(buf strbuf) string {
 	return string(buf)
 }
 
-type scardReaderState struct {
-	szReader       uintptr
-	pvUserData     uintptr
-	dwCurrentState uintptr
-	dwEventState   uintptr
-	cbAtr          uintptr
-	rgbAtr         [33]byte
+// MaxReaderStates returns PCSCLITE_MAX_READERS_CONTEXTS, the number of
+// reader states libpcsclite accepts in a single SCardGetStatusChange call.
+func (sysBackend) MaxReaderStates() int {
+	return 16
 }
 
-var pinned = map[string]*strbuf{}
-var mutex sync.Mutex
+// readerStates is the SCARD_READERSTATE array passed to
+// SCardGetStatusChange. The array and the reader names it points to are
+// allocated in C memory, as cgo does not allow Go memory passed to C to
+// contain Go pointers.
+type readerStates struct {
+	states  []C.SCARD_READERSTATE // backed by C memory
+	readers []string              // names of the allocated szReader
+}
 
-func (rs *ReaderState) toSys() (scardReaderState, error) {
-	var sys scardReaderState
+// set fills the first len(rs) states from rs, reusing the reader names of
+// the previous call where they did not change. An ATR longer than rgbAtr is
+// passed truncated; update keeps the complete one if it did not change.
+func (s *readerStates) set(rs []ReaderState) error {
+	if len(rs) > len(s.states) {
+		s.grow(len(rs))
+	}
+	for i := range rs {
+		sys := &s.states[i]
+		if sys.szReader == nil || s.readers[i] != rs[i].Reader {
+			C.free(unsafe.Pointer(sys.szReader))
+			sys.szReader = C.CString(rs[i].Reader)
+			s.readers[i] = rs[i].Reader
+		}
+		sys.dwCurrentState = C.DWORD(rs[i].CurrentState)
+		sys.dwEventState = 0
+		sys.cbAtr = C.DWORD(copy(s.atr(i), rs[i].Atr))
+	}
+	return nil
+}
 
-	creader, err := encodestr(rs.Reader)
-	if err != nil {
-		return scardReaderState{}, err
+func (s *readerStates) grow(n int) {
+	p := (*C.SCARD_READERSTATE)(C.calloc(C.size_t(n), C.sizeof_SCARD_READERSTATE))
+	states := unsafe.Slice(p, n)
+	copy(states, s.states)
+	if len(s.states) > 0 {
+		C.free(unsafe.Pointer(&s.states[0]))
 	}
+	s.states = states
+	s.readers = append(s.readers, make([]string, n-len(s.readers))...)
+}
 
-	mutex.Lock()
-	pinned[rs.Reader] = &creader
-	mutex.Unlock()
+func (s *readerStates) atr(i int) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s.states[i].rgbAtr[0])), len(s.states[i].rgbAtr))
+}
 
-	sys.szReader = uintptr(creader.ptr())
-	sys.dwCurrentState = uintptr(rs.CurrentState)
-	sys.cbAtr = uintptr(len(rs.Atr))
-	for i, v := range rs.Atr {
-		sys.rgbAtr[i] = byte(v)
+func (s *readerStates) getStatusChange(ctx uintptr, timeout uint32, n int) Error {
+	var p *C.SCARD_READERSTATE
+	if n > 0 {
+		p = &s.states[0]
 	}
-	return sys, nil
+	r := C.wrapSCardGetStatusChange(C.SCARDCONTEXT(ctx), C.DWORD(timeout), p, C.DWORD(n))
+	return Error(r)
 }
 
-func (rs *ReaderState) update(sys *scardReaderState) {
-	rs.EventState = StateFlag(sys.dwEventState)
-	if sys.cbAtr > 0 {
-		rs.Atr = make([]byte, int(sys.cbAtr))
-		for i := 0; i < int(sys.cbAtr); i++ {
-			rs.Atr[i] = byte(sys.rgbAtr[i])
+// update copies the event states and ATRs of the first len(rs) states to
+// rs.
+func (s *readerStates) update(rs []ReaderState) {
+	for i := range rs {
+		sys := &s.states[i]
+		rs[i].EventState = StateFlag(sys.dwEventState)
+		if sys.cbAtr > 0 {
+			rs[i].Atr = stateAtr(rs[i].Atr, s.atr(i), int(sys.cbAtr))
 		}
 	}
 }
+
+func (s *readerStates) free() {
+	for i := range s.states {
+		C.free(unsafe.Pointer(s.states[i].szReader))
+	}
+	if len(s.states) > 0 {
+		C.free(unsafe.Pointer(&s.states[0]))
+	}
+	s.states = nil
+	s.readers = nil
+}
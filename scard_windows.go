@@ -1,7 +1,8 @@
 package scard
 
 import (
-	"fmt"
+	"encoding/binary"
+	"runtime"
 	"syscall"
 	"unsafe"
 )
@@ -45,18 +46,51 @@ func init() {
 	scardIoReqT1 = dataT1Pci.Addr()
 }
 
-func (e Error) Error() string {
-	err := syscall.Errno(e)
-	return fmt.Sprintf("scard: error(%x): %s", uintptr(e), err.Error())
+// winscard uses a different value for SCARD_PROTOCOL_RAW than pcsc-lite.
+const winProtocolRaw = 0x10000
+
+func toWinProtocol(p Protocol) uint32 {
+	if p&ProtocolRaw != 0 {
+		p = p&^ProtocolRaw | winProtocolRaw
+	}
+	return uint32(p)
+}
+
+func fromWinProtocol(p uint32) Protocol {
+	if p&winProtocolRaw != 0 {
+		p = p&^winProtocolRaw | uint32(ProtocolRaw)
+	}
+	return Protocol(p)
+}
+
+// winStates maps the card states of winscard, which are an enumeration
+// (SCARD_UNKNOWN 0 to SCARD_SPECIFIC 6), to the State flags of pcsc-lite.
+// Each winscard state implies the ones before it, so that e.g. State.String
+// and checks like state&Present give the same results on all platforms.
+var winStates = [...]State{
+	Unknown,
+	Absent,
+	Present,
+	Present | Swallowed,
+	Present | Swallowed | Powered,
+	Present | Swallowed | Powered | Negotiable,
+	Present | Swallowed | Powered | Specific,
+}
+
+func fromWinState(s uint32) State {
+	if s < uint32(len(winStates)) {
+		return winStates[s]
+	}
+	return State(s)
 }
 
 func scardCtlCode(code uint16) uint32 {
 	return 0x310000 | (uint32(code) << 2)
 }
 
-func scardEstablishContext(scope Scope, reserved1, reserved2 uintptr) (uintptr, Error) {
+func scardEstablishContext(scope Scope) (uintptr, Error) {
 	var ctx uintptr
-	r, _, _ := procEstablishContext.Call(uintptr(scope), reserved1, reserved2, uintptr(unsafe.Pointer(&ctx)))
+	r, _, _ := procEstablishContext.Call(uintptr(scope), 0, 0, uintptr(unsafe.Pointer(&ctx)))
 	return ctx, Error(r)
 }
 
@@ -87,18 +121,13 @@ func scardListReaderGroups(ctx uintptr, buf unsafe.Pointer, bufLen uint32) (uint
 	return dwBufLen, Error(r)
 }
 
-func scardGetStatusChange(ctx uintptr, timeout uint32, states []scardReaderState) Error {
-	r, _, _ := procGetStatusChange.Call(ctx, uintptr(timeout), uintptr(unsafe.Pointer(&states[0])), uintptr(len(states)))
-	return Error(r)
-}
-
 func scardConnect(ctx uintptr, reader unsafe.Pointer, shareMode ShareMode, proto Protocol) (uintptr, Protocol, Error) {
 	var handle uintptr
 	var activeProto uint32
 
-	r, _, _ := procConnect.Call(ctx, uintptr(reader), uintptr(shareMode), uintptr(proto), uintptr(unsafe.Pointer(&handle)), uintptr(unsafe.Pointer(&activeProto)))
+	r, _, _ := procConnect.Call(ctx, uintptr(reader), uintptr(shareMode), uintptr(toWinProtocol(proto)), uintptr(unsafe.Pointer(&handle)), uintptr(unsafe.Pointer(&activeProto)))
 
-	return handle, Protocol(activeProto), Error(r)
+	return handle, fromWinProtocol(activeProto), Error(r)
 }
 
 func scardDisconnect(card uintptr, d Disposition) Error {
@@ -108,8 +137,8 @@ func scardDisconnect(card uintptr, d Disposition) Error {
 
 func scardReconnect(card uintptr, mode ShareMode, proto Protocol, disp Disposition) (Protocol, Error) {
 	var activeProtocol uint32
-	r, _, _ := procReconnect.Call(card, uintptr(mode), uintptr(proto), uintptr(disp), uintptr(unsafe.Pointer(&activeProtocol)))
-	return Protocol(activeProtocol), Error(r)
+	r, _, _ := procReconnect.Call(card, uintptr(mode), uintptr(toWinProtocol(proto)), uintptr(disp), uintptr(unsafe.Pointer(&activeProtocol)))
+	return fromWinProtocol(activeProtocol), Error(r)
 }
 
 func scardBeginTransaction(card uintptr) Error {
@@ -130,36 +159,94 @@ func scardCardStatus(card uintptr, readerBuf strbuf, atrBuf []byte) (uint32, Sta
 
 	r, _, _ := procStatus.Call(card, uintptr(readerBuf.ptr()), uintptr(unsafe.Pointer(&readerLen)), uintptr(unsafe.Pointer(&state)), uintptr(unsafe.Pointer(&proto)), uintptr(unsafe.Pointer(&atrBuf[0])), uintptr(unsafe.Pointer(&atrLen)))
 
-	return readerLen, State(state), Protocol(proto), atrLen, Error(r)
+	return readerLen, fromWinState(state), fromWinProtocol(proto), atrLen, Error(r)
 }
 
 func scardTransmit(card uintptr, proto Protocol, cmd []byte, rsp []byte) (uint32, Error) {
 	var sendpci uintptr
+	var ptrCmd, ptrRsp uintptr
 	var rspLen = uint32(len(rsp))
 
+	if len(cmd) != 0 {
+		ptrCmd = uintptr(unsafe.Pointer(&cmd[0]))
+	}
+	if len(rsp) != 0 {
+		ptrRsp = uintptr(unsafe.Pointer(&rsp[0]))
+	}
+
 	switch proto {
 	case ProtocolT0:
 		sendpci = scardIoReqT0
 	case ProtocolT1:
 		sendpci = scardIoReqT1
 	default:
-		panic("unknown protocol")
+		return scardTransmitPCIRaw(card, &IORequest{Protocol: proto}, ptrCmd, len(cmd), ptrRsp, &rspLen, nil)
 	}
 
-	r, _, _ := procTransmit.Call(card, sendpci, uintptr(unsafe.Pointer(&cmd[0])), uintptr(len(cmd)), uintptr(0), uintptr(unsafe.Pointer(&rsp[0])), uintptr(unsafe.Pointer(&rspLen)))
+	r, _, _ := procTransmit.Call(card, sendpci, ptrCmd, uintptr(len(cmd)), uintptr(0), ptrRsp, uintptr(unsafe.Pointer(&rspLen)))
 
 	return rspLen, Error(r)
 }
 
+func scardTransmitPCI(card uintptr, send *IORequest, cmd []byte, rsp []byte) (uint32, *IORequest, Error) {
+	var ptrCmd, ptrRsp uintptr
+	var rspLen = uint32(len(rsp))
+
+	if len(cmd) != 0 {
+		ptrCmd = uintptr(unsafe.Pointer(&cmd[0]))
+	}
+	if len(rsp) != 0 {
+		ptrRsp = uintptr(unsafe.Pointer(&rsp[0]))
+	}
+
+	recv := &IORequest{}
+	n, r := scardTransmitPCIRaw(card, send, ptrCmd, len(cmd), ptrRsp, &rspLen, recv)
+	return n, recv, r
+}
+
+// scardTransmitPCIRaw calls SCardTransmit with send as the SCARD_IO_REQUEST
+// and fills in recv if it is not nil.
+func scardTransmitPCIRaw(card uintptr, send *IORequest, ptrCmd uintptr, cmdLen int, ptrRsp uintptr, rspLen *uint32, recv *IORequest) (uint32, Error) {
+	const hdrLen = 8
+
+	sendpci := make([]byte, hdrLen+len(send.Extra))
+	binary.LittleEndian.PutUint32(sendpci, toWinProtocol(send.Protocol))
+	binary.LittleEndian.PutUint32(sendpci[4:], uint32(len(sendpci)))
+	copy(sendpci[hdrLen:], send.Extra)
+
+	var ptrRecv uintptr
+	var recvpci []byte
+	if recv != nil {
+		recvpci = make([]byte, hdrLen+maxPCIExtra)
+		binary.LittleEndian.PutUint32(recvpci, toWinProtocol(send.Protocol))
+		binary.LittleEndian.PutUint32(recvpci[4:], uint32(len(recvpci)))
+		ptrRecv = uintptr(unsafe.Pointer(&recvpci[0]))
+	}
+
+	r, _, _ := procTransmit.Call(card, uintptr(unsafe.Pointer(&sendpci[0])), ptrCmd, uintptr(cmdLen), ptrRecv, ptrRsp, uintptr(unsafe.Pointer(rspLen)))
+
+	if recv != nil {
+		recv.Protocol = fromWinProtocol(binary.LittleEndian.Uint32(recvpci))
+		if n := int(binary.LittleEndian.Uint32(recvpci[4:])) - hdrLen; n > 0 && n <= maxPCIExtra {
+			recv.Extra = append([]byte(nil), recvpci[hdrLen:hdrLen+n]...)
+		}
+	}
+	return *rspLen, Error(r)
+}
+
 func scardControl(card uintptr, ioctl uint32, in, out []byte) (uint32, Error) {
 	var ptrIn uintptr
+	var ptrOut uintptr
 	var outLen = uint32(len(out))
 
 	if len(in) != 0 {
 		ptrIn = uintptr(unsafe.Pointer(&in[0]))
 	}
+	if len(out) != 0 {
+		ptrOut = uintptr(unsafe.Pointer(&out[0]))
+	}
 
-	r, _, _ := procControl.Call(card, uintptr(ioctl), ptrIn, uintptr(len(in)), uintptr(unsafe.Pointer(&out[0])), uintptr(len(out)), uintptr(unsafe.Pointer(&outLen)))
+	r, _, _ := procControl.Call(card, uintptr(ioctl), ptrIn, uintptr(len(in)), ptrOut, uintptr(len(out)), uintptr(unsafe.Pointer(&outLen)))
 	return outLen, Error(r)
 }
 
@@ -172,10 +259,26 @@ func scardGetAttrib(card uintptr, id Attrib, buf []byte) (uint32, Error) {
 
 	bufLen := uint32(len(buf))
 	r, _, _ := procGetAttrib.Call(card, uintptr(id), ptr, uintptr(unsafe.Pointer(&bufLen)))
+	if Error(r) == ErrSuccess {
+		fromWinAttrib(id, buf, bufLen)
+	}
 
 	return bufLen, Error(r)
 }
 
+// fromWinAttrib converts the protocol in AttrCurrentProtocolType like the
+// active protocol returned by Connect and Status. n is the attribute length
+// returned by SCardGetAttrib; a size query with a nil buf is left alone.
+func fromWinAttrib(id Attrib, buf []byte, n uint32) {
+	if buf == nil || n > uint32(len(buf)) {
+		return
+	}
+	buf = buf[:n]
+	if id == AttrCurrentProtocolType && len(buf) == 4 {
+		binary.LittleEndian.PutUint32(buf, uint32(fromWinProtocol(binary.LittleEndian.Uint32(buf))))
+	}
+}
+
 func scardSetAttrib(card uintptr, id Attrib, buf []byte) Error {
 	r, _, _ := procSetAttrib.Call(card, uintptr(id), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
 	return Error(r)
@@ -190,25 +293,66 @@ type scardReaderState struct {
 	rgbAtr         [36]byte
 }
 
-func (rs *ReaderState) toSys() (scardReaderState, error) {
-	var sys scardReaderState
-	creader, err := encodestr(rs.Reader)
-	if err != nil {
-		return scardReaderState{}, err
+// readerStates is the SCARD_READERSTATE array passed to
+// SCardGetStatusChange, kept with the reader names it points to.
+type readerStates struct {
+	states  []scardReaderState
+	readers []strbuf
+	names   []string
+}
+
+// set fills the first len(rs) states from rs, reusing the reader names of
+// the previous call where they did not change. An ATR longer than rgbAtr is
+// passed truncated; update keeps the complete one if it did not change.
+func (s *readerStates) set(rs []ReaderState) error {
+	if len(rs) > len(s.states) {
+		s.states = append(s.states, make([]scardReaderState, len(rs)-len(s.states))...)
+		s.readers = append(s.readers, make([]strbuf, len(rs)-len(s.readers))...)
+		s.names = append(s.names, make([]string, len(rs)-len(s.names))...)
+	}
+	for i := range rs {
+		sys := &s.states[i]
+		if s.readers[i] == nil || s.names[i] != rs[i].Reader {
+			creader, err := encodestr(rs[i].Reader)
+			if err != nil {
+				return err
+			}
+			s.readers[i] = creader
+			s.names[i] = rs[i].Reader
+		}
+		sys.szReader = uintptr(s.readers[i].ptr())
+		sys.dwCurrentState = uint32(rs[i].CurrentState)
+		sys.dwEventState = 0
+		sys.cbAtr = uint32(copy(sys.rgbAtr[:], rs[i].Atr))
 	}
-	sys.szReader = uintptr(creader.ptr())
-	sys.dwCurrentState = uint32(rs.CurrentState)
-	sys.cbAtr = uint32(len(rs.Atr))
-	copy(sys.rgbAtr[:], rs.Atr)
-	return sys, nil
+	return nil
 }
 
-func (rs *ReaderState) update(sys *scardReaderState) {
-	rs.EventState = StateFlag(sys.dwEventState)
-	if sys.cbAtr > 0 {
-		rs.Atr = make([]byte, int(sys.cbAtr))
-		copy(rs.Atr, sys.rgbAtr[:])
+func (s *readerStates) getStatusChange(ctx uintptr, timeout uint32, n int) Error {
+	var p *scardReaderState
+	if n > 0 {
+		p = &s.states[0]
 	}
+	r, _, _ := procGetStatusChange.Call(ctx, uintptr(timeout), uintptr(unsafe.Pointer(p)), uintptr(n))
+	// the reader names are only referenced by szReader
+	runtime.KeepAlive(s.readers)
+	return Error(r)
+}
+
+// update copies the event states and ATRs of the first len(rs) states to
+// rs.
+func (s *readerStates) update(rs []ReaderState) {
+	for i := range rs {
+		sys := &s.states[i]
+		rs[i].EventState = StateFlag(sys.dwEventState)
+		if sys.cbAtr > 0 {
+			rs[i].Atr = stateAtr(rs[i].Atr, sys.rgbAtr[:], int(sys.cbAtr))
+		}
+	}
+}
+
+func (s *readerStates) free() {
+	*s = readerStates{}
 }
 
 type strbuf []uint16
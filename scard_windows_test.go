@@ -0,0 +1,53 @@
+package scard
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFromWinAttrib(t *testing.T) {
+	tests := []struct {
+		id       Attrib
+		buf, out []byte
+	}{
+		{AttrCurrentProtocolType, []byte{0x02, 0x00, 0x00, 0x00}, []byte{0x02, 0x00, 0x00, 0x00}},
+		{AttrCurrentProtocolType, []byte{0x00, 0x00, 0x01, 0x00}, []byte{0x04, 0x00, 0x00, 0x00}},
+		{AttrCurrentClk, []byte{0x00, 0x00, 0x01, 0x00}, []byte{0x00, 0x00, 0x01, 0x00}},
+	}
+	for _, tt := range tests {
+		buf := append([]byte(nil), tt.buf...)
+		fromWinAttrib(tt.id, buf, uint32(len(buf)))
+		if !bytes.Equal(buf, tt.out) {
+			t.Errorf("fromWinAttrib(%v, % x) = % x, want % x", tt.id, tt.buf, buf, tt.out)
+		}
+	}
+
+	// size query
+	fromWinAttrib(AttrCurrentProtocolType, nil, 4)
+
+	// length larger than buf
+	buf := []byte{0x00, 0x00}
+	fromWinAttrib(AttrCurrentProtocolType, buf, 4)
+	if !bytes.Equal(buf, []byte{0x00, 0x00}) {
+		t.Errorf("fromWinAttrib() with short buf = % x", buf)
+	}
+}
+
+func TestFromWinState(t *testing.T) {
+	tests := []struct {
+		in   uint32
+		want State
+	}{
+		{0, Unknown},
+		{1, Absent},
+		{2, Present},
+		{4, Present | Swallowed | Powered},
+		{6, Present | Swallowed | Powered | Specific},
+		{7, State(7)},
+	}
+	for _, tt := range tests {
+		if got := fromWinState(tt.in); got != tt.want {
+			t.Errorf("fromWinState(%d) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
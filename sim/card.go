@@ -0,0 +1,618 @@
+// Package sim implements a virtual ISO 7816-4 smart card.
+//
+// A Card has a file system of DFs and EFs and answers SELECT, READ BINARY,
+// UPDATE BINARY, READ RECORD, UPDATE RECORD, VERIFY and GET RESPONSE, with
+// command chaining for all of them. It implements pcscdtest.Card, so it can
+// be inserted into a pcscdtest reader to run code using the scard package
+// against it:
+//
+//	card := sim.New(nil)
+//	app := card.MF().AddDF(0x1000, []byte{0xa0, 0x00, 0x00, 0x00, 0x01})
+//	app.AddEF(0x0101, []byte("hello"))
+//	reader.Insert(card)
+package sim
+
+import (
+	"sync"
+)
+
+// Status words.
+const (
+	swOK                    = 0x9000
+	swEndOfFile             = 0x6282
+	swWrongLength           = 0x6700
+	swIncompatibleFile      = 0x6981
+	swSecurityNotSatisfied  = 0x6982
+	swPINBlocked            = 0x6983
+	swConditionsNotMet      = 0x6985
+	swNoCurrentEF           = 0x6986
+	swFileNotFound          = 0x6a82
+	swRecordNotFound        = 0x6a83
+	swNotEnoughMemory       = 0x6a84
+	swIncorrectP1P2         = 0x6a86
+	swReferenceDataNotFound = 0x6a88
+	swWrongP1P2             = 0x6b00
+	swINSNotSupported       = 0x6d00
+	swCLANotSupported       = 0x6e00
+	swLastCommandExpected   = 0x6883
+)
+
+// DefaultATR is the ATR of a Card created with a nil ATR. It indicates T=0
+// and T=1, and command chaining and extended length support in the card
+// capabilities.
+var DefaultATR = withTCK([]byte{
+	0x3b, 0x88, 0x80, 0x01,
+	// historical bytes: category 00, card capabilities, status 00 90 00
+	0x00, 0x73, 0x00, 0x00, 0xc0, 0x00, 0x90, 0x00,
+})
+
+// withTCK appends the check byte to atr.
+func withTCK(atr []byte) []byte {
+	var tck byte
+	for _, b := range atr[1:] {
+		tck ^= b
+	}
+	return append(atr, tck)
+}
+
+type pin struct {
+	value    []byte
+	retries  int
+	max      int
+	verified bool
+}
+
+// Card is a virtual smart card. It is safe for concurrent use.
+type Card struct {
+	mu sync.Mutex
+
+	atr  []byte
+	mf   *File
+	pins map[byte]*pin
+
+	df      *File
+	ef      *File
+	pending []byte
+	chain   *command // data received so far with command chaining
+}
+
+// New returns a card with an empty MF. If atr is nil DefaultATR is used.
+func New(atr []byte) *Card {
+	if atr == nil {
+		atr = DefaultATR
+	}
+	mf := NewMF()
+	return &Card{
+		atr:  append([]byte(nil), atr...),
+		mf:   mf,
+		pins: make(map[byte]*pin),
+		df:   mf,
+	}
+}
+
+// MF returns the root of the card's file system.
+func (c *Card) MF() *File {
+	return c.mf
+}
+
+// AddPIN adds a PIN with the given reference (P2 of VERIFY). The PIN is
+// blocked after retries consecutive wrong presentations.
+func (c *Card) AddPIN(ref byte, value []byte, retries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pins[ref] = &pin{value: append([]byte(nil), value...), retries: retries, max: retries}
+}
+
+// PINRetries returns the number of tries left for PIN ref, or -1 if there
+// is no such PIN.
+func (c *Card) PINRetries(ref byte) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.pins[ref]
+	if !ok {
+		return -1
+	}
+	return p.retries
+}
+
+// ATR returns the answer to reset.
+func (c *Card) ATR() []byte {
+	return c.atr
+}
+
+// Reset resets the security status and selects the MF.
+func (c *Card) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pins {
+		p.verified = false
+	}
+	c.df = c.mf
+	c.ef = nil
+	c.pending = nil
+	c.chain = nil
+}
+
+// Transmit processes a command APDU. Errors in the command are reported
+// with a status word; the returned error is always nil.
+func (c *Card) Transmit(apdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cmd, ok := parseCommand(apdu)
+	if !ok {
+		return sw(swWrongLength), nil
+	}
+
+	if cmd.ins != 0xc0 {
+		c.pending = nil
+	}
+
+	if cmd.cla&0x80 != 0 {
+		return sw(swCLANotSupported), nil
+	}
+	if cmd, ok = c.chained(cmd); !ok {
+		return sw(swLastCommandExpected), nil
+	}
+	if cmd == nil {
+		return sw(swOK), nil
+	}
+
+	switch cmd.ins {
+	case 0xa4:
+		return c.selectFile(cmd), nil
+	case 0xb0:
+		return c.readBinary(cmd), nil
+	case 0xd6:
+		return c.updateBinary(cmd), nil
+	case 0xb2:
+		return c.readRecord(cmd), nil
+	case 0xdc:
+		return c.updateRecord(cmd), nil
+	case 0x20:
+		return c.verify(cmd), nil
+	case 0xc0:
+		return c.getResponse(cmd), nil
+	}
+	return sw(swINSNotSupported), nil
+}
+
+// chained collects the data of commands with the chaining bit set in CLA.
+// It returns nil for those, and the last command of a chain with the data
+// of the whole chain. A chain interrupted by another command is an error.
+func (c *Card) chained(cmd *command) (*command, bool) {
+	chain := c.chain
+	if chain != nil && (cmd.ins != chain.ins || cmd.p1 != chain.p1 || cmd.p2 != chain.p2) {
+		c.chain = nil
+		return nil, false
+	}
+	if cmd.cla&0x10 != 0 {
+		if chain == nil {
+			chain = &command{cla: cmd.cla &^ 0x10, ins: cmd.ins, p1: cmd.p1, p2: cmd.p2}
+			c.chain = chain
+		}
+		chain.data = append(chain.data, cmd.data...)
+		return nil, true
+	}
+	if chain != nil {
+		c.chain = nil
+		cmd.data = append(chain.data, cmd.data...)
+	}
+	return cmd, true
+}
+
+// command is a parsed command APDU.
+type command struct {
+	cla, ins, p1, p2 byte
+	data             []byte
+	// hasLe is set if the command has an Le field; ne is the decoded
+	// number of expected bytes and leZero is set if Le was encoded as 0
+	// (the maximum).
+	hasLe  bool
+	leZero bool
+	ne     int
+}
+
+func parseCommand(b []byte) (*command, bool) {
+	if len(b) < 4 {
+		return nil, false
+	}
+	cmd := &command{cla: b[0], ins: b[1], p1: b[2], p2: b[3]}
+	body := b[4:]
+
+	switch {
+	case len(body) == 0:
+	case len(body) == 1:
+		cmd.setLe(int(body[0]), 256)
+	case body[0] != 0:
+		lc := int(body[0])
+		switch len(body) {
+		case 1 + lc:
+		case 2 + lc:
+			cmd.setLe(int(body[1+lc]), 256)
+		default:
+			return nil, false
+		}
+		cmd.data = body[1 : 1+lc]
+	case len(body) == 3:
+		cmd.setLe(int(body[1])<<8|int(body[2]), 65536)
+	case len(body) > 3:
+		lc := int(body[1])<<8 | int(body[2])
+		switch len(body) {
+		case 3 + lc:
+		case 5 + lc:
+			cmd.setLe(int(body[3+lc])<<8|int(body[4+lc]), 65536)
+		default:
+			return nil, false
+		}
+		cmd.data = body[3 : 3+lc]
+	default:
+		return nil, false
+	}
+
+	return cmd, true
+}
+
+func (cmd *command) setLe(le, max int) {
+	cmd.hasLe = true
+	cmd.leZero = le == 0
+	cmd.ne = le
+	if le == 0 {
+		cmd.ne = max
+	}
+}
+
+func sw(sw uint16) []byte {
+	return []byte{byte(sw >> 8), byte(sw)}
+}
+
+// respond returns data to the terminal. Data that does not fit in Ne, or
+// any data if the command had no Le field, is kept for GET RESPONSE and
+// announced with 61 XX.
+func (c *Card) respond(cmd *command, data []byte) []byte {
+	if len(data) == 0 {
+		return sw(swOK)
+	}
+	n := 0
+	if cmd.hasLe {
+		n = cmd.ne
+	}
+	if len(data) <= n {
+		return append(append([]byte(nil), data...), 0x90, 0x00)
+	}
+	c.pending = append([]byte(nil), data[n:]...)
+	return append(append([]byte(nil), data[:n]...), 0x61, remaining(len(c.pending)))
+}
+
+// remaining returns SW2 of 61 XX for n bytes still available.
+func remaining(n int) byte {
+	if n > 255 {
+		return 0
+	}
+	return byte(n)
+}
+
+func (c *Card) getResponse(cmd *command) []byte {
+	if c.pending == nil {
+		return sw(swConditionsNotMet)
+	}
+	if cmd.p1 != 0 || cmd.p2 != 0 {
+		c.pending = nil
+		return sw(swIncorrectP1P2)
+	}
+	if cmd.hasLe && !cmd.leZero && cmd.ne > len(c.pending) {
+		return []byte{0x6c, byte(len(c.pending))}
+	}
+
+	data := c.pending
+	c.pending = nil
+	if !cmd.hasLe {
+		cmd.setLe(0, 256)
+	}
+	return c.respond(cmd, data)
+}
+
+func fid(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func (c *Card) selectFile(cmd *command) []byte {
+	var f *File
+
+	switch cmd.p1 {
+	case 0x00:
+		switch {
+		case len(cmd.data) == 0:
+			f = c.mf
+		case len(cmd.data) != 2:
+			return sw(swWrongLength)
+		default:
+			id := fid(cmd.data)
+			switch {
+			case id == MFID:
+				f = c.mf
+			case id == c.df.FID:
+				f = c.df
+			default:
+				f = c.df.Child(id)
+				if f == nil && c.df.parent != nil && c.df.parent.FID == id {
+					f = c.df.parent
+				}
+			}
+		}
+	case 0x01, 0x02:
+		if len(cmd.data) != 2 {
+			return sw(swWrongLength)
+		}
+		f = c.df.Child(fid(cmd.data))
+		if f != nil && (f.Type == DF) != (cmd.p1 == 0x01) {
+			f = nil
+		}
+	case 0x03:
+		if len(cmd.data) != 0 {
+			return sw(swWrongLength)
+		}
+		f = c.df.parent
+	case 0x04:
+		if len(cmd.data) == 0 || len(cmd.data) > 16 {
+			return sw(swWrongLength)
+		}
+		if cmd.p2&0x03 != 0 {
+			return sw(swIncorrectP1P2)
+		}
+		f = c.mf.findName(cmd.data)
+	case 0x08, 0x09:
+		if len(cmd.data) == 0 || len(cmd.data)%2 != 0 {
+			return sw(swWrongLength)
+		}
+		f = c.df
+		path := cmd.data
+		if cmd.p1 == 0x08 {
+			f = c.mf
+			if fid(path) == MFID {
+				path = path[2:]
+			}
+		}
+		for ; f != nil && len(path) > 0; path = path[2:] {
+			if f.Type != DF {
+				f = nil
+				break
+			}
+			f = f.Child(fid(path))
+		}
+	default:
+		return sw(swIncorrectP1P2)
+	}
+
+	if f == nil {
+		return sw(swFileNotFound)
+	}
+
+	if f.Type == DF {
+		c.df, c.ef = f, nil
+	} else {
+		c.df, c.ef = f.parent, f
+	}
+
+	switch cmd.p2 &^ 0x03 {
+	case 0x00:
+		return c.respond(cmd, template(0x6f, f.fcp()))
+	case 0x04:
+		return c.respond(cmd, template(0x62, f.fcp()))
+	case 0x08:
+		return c.respond(cmd, template(0x64, nil))
+	case 0x0c:
+		return sw(swOK)
+	}
+	return sw(swIncorrectP1P2)
+}
+
+func template(tag byte, value []byte) []byte {
+	return appendTLV(nil, tag, value)
+}
+
+// efForAccess returns the EF addressed by a short EF identifier, selecting
+// it, or the current EF if sfi is 0.
+func (c *Card) efForAccess(sfi byte) (*File, uint16) {
+	if sfi != 0 {
+		f := c.df.childSFI(sfi)
+		if f == nil {
+			return nil, swFileNotFound
+		}
+		c.ef = f
+		return f, 0
+	}
+	if c.ef == nil {
+		return nil, swNoCurrentEF
+	}
+	return c.ef, 0
+}
+
+// allowed reports whether the PIN ref has been verified.
+func (c *Card) allowed(ref byte) bool {
+	if ref == 0 {
+		return true
+	}
+	p, ok := c.pins[ref]
+	return ok && p.verified
+}
+
+// binaryTarget decodes P1-P2 of READ/UPDATE BINARY.
+func (c *Card) binaryTarget(cmd *command) (*File, int, uint16) {
+	var sfi byte
+	offset := int(cmd.p1)<<8 | int(cmd.p2)
+	if cmd.p1&0x80 != 0 {
+		if cmd.p1&0x60 != 0 {
+			return nil, 0, swIncorrectP1P2
+		}
+		sfi = cmd.p1 & 0x1f
+		offset = int(cmd.p2)
+	}
+
+	f, code := c.efForAccess(sfi)
+	if f == nil {
+		return nil, 0, code
+	}
+	if f.Type != TransparentEF {
+		return nil, 0, swIncompatibleFile
+	}
+	return f, offset, 0
+}
+
+func (c *Card) readBinary(cmd *command) []byte {
+	if !cmd.hasLe || len(cmd.data) != 0 {
+		return sw(swWrongLength)
+	}
+	f, offset, code := c.binaryTarget(cmd)
+	if f == nil {
+		return sw(code)
+	}
+	if !c.allowed(f.ReadPIN) {
+		return sw(swSecurityNotSatisfied)
+	}
+	if offset >= len(f.Data) {
+		return sw(swWrongP1P2)
+	}
+
+	// Le 00 reads up to the end of the file; an explicit Ne past the end
+	// returns what there is with 62 82.
+	avail := f.Data[offset:]
+	if cmd.ne > len(avail) {
+		if !cmd.leZero {
+			return append(append([]byte(nil), avail...), sw(swEndOfFile)...)
+		}
+		return c.respond(cmd, avail)
+	}
+	return c.respond(cmd, avail[:cmd.ne])
+}
+
+func (c *Card) updateBinary(cmd *command) []byte {
+	if cmd.hasLe || len(cmd.data) == 0 {
+		return sw(swWrongLength)
+	}
+	f, offset, code := c.binaryTarget(cmd)
+	if f == nil {
+		return sw(code)
+	}
+	if !c.allowed(f.WritePIN) {
+		return sw(swSecurityNotSatisfied)
+	}
+	if offset > len(f.Data) {
+		return sw(swWrongP1P2)
+	}
+	if offset+len(cmd.data) > len(f.Data) {
+		return sw(swNotEnoughMemory)
+	}
+
+	copy(f.Data[offset:], cmd.data)
+	return sw(swOK)
+}
+
+// recordTarget decodes P1-P2 of READ/UPDATE RECORD. Only addressing a
+// single record by number is supported.
+func (c *Card) recordTarget(cmd *command) (*File, int, uint16) {
+	if cmd.p2&0x07 != 0x04 || cmd.p1 == 0 || cmd.p1 == 0xff {
+		return nil, 0, swIncorrectP1P2
+	}
+
+	f, code := c.efForAccess(cmd.p2 >> 3)
+	if f == nil {
+		return nil, 0, code
+	}
+	if !f.isRecord() {
+		return nil, 0, swIncompatibleFile
+	}
+	if int(cmd.p1) > len(f.Records) {
+		return nil, 0, swRecordNotFound
+	}
+	return f, int(cmd.p1) - 1, 0
+}
+
+func (c *Card) readRecord(cmd *command) []byte {
+	if !cmd.hasLe || len(cmd.data) != 0 {
+		return sw(swWrongLength)
+	}
+	f, i, code := c.recordTarget(cmd)
+	if f == nil {
+		return sw(code)
+	}
+	if !c.allowed(f.ReadPIN) {
+		return sw(swSecurityNotSatisfied)
+	}
+
+	rec := f.Records[i]
+	if !cmd.leZero && cmd.ne != len(rec) && len(rec) < 256 {
+		return []byte{0x6c, byte(len(rec))}
+	}
+	return c.respond(cmd, rec)
+}
+
+func (c *Card) updateRecord(cmd *command) []byte {
+	if cmd.hasLe || len(cmd.data) == 0 {
+		return sw(swWrongLength)
+	}
+	f, i, code := c.recordTarget(cmd)
+	if f == nil {
+		return sw(code)
+	}
+	if !c.allowed(f.WritePIN) {
+		return sw(swSecurityNotSatisfied)
+	}
+	if f.Type == LinearFixedEF && len(cmd.data) != f.RecordSize {
+		return sw(swWrongLength)
+	}
+
+	f.Records[i] = append([]byte(nil), cmd.data...)
+	return sw(swOK)
+}
+
+func (c *Card) verify(cmd *command) []byte {
+	if cmd.hasLe {
+		return sw(swWrongLength)
+	}
+	p, ok := c.pins[cmd.p2]
+	if !ok {
+		return sw(swReferenceDataNotFound)
+	}
+
+	switch cmd.p1 {
+	case 0x00:
+	case 0xff:
+		if len(cmd.data) != 0 {
+			return sw(swWrongLength)
+		}
+		p.verified = false
+		return sw(swOK)
+	default:
+		return sw(swIncorrectP1P2)
+	}
+
+	if len(cmd.data) == 0 {
+		if p.verified {
+			return sw(swOK)
+		}
+		return retries(p.retries)
+	}
+	if p.retries == 0 {
+		return sw(swPINBlocked)
+	}
+	if string(cmd.data) != string(p.value) {
+		p.verified = false
+		p.retries--
+		return retries(p.retries)
+	}
+
+	p.verified = true
+	p.retries = p.max
+	return sw(swOK)
+}
+
+func retries(n int) []byte {
+	if n > 15 {
+		n = 15
+	}
+	return []byte{0x63, 0xc0 | byte(n)}
+}
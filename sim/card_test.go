@@ -0,0 +1,223 @@
+package sim
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testAID = []byte{0xa0, 0x00, 0x00, 0x00, 0x01, 0x01}
+
+func newTestCard() *Card {
+	c := New(nil)
+	app := c.MF().AddDF(0x1000, testAID)
+	app.AddEF(0x0101, []byte("hello, world")).SFI = 1
+	app.AddRecordEF(0x0102, 4, []byte{1, 1, 1, 1}, []byte{2, 2, 2, 2})
+	app.AddEF(0x0103, make([]byte, 300)).ReadPIN = 0x81
+	c.AddPIN(0x81, []byte("1234"), 3)
+	return c
+}
+
+func transmit(t *testing.T, c *Card, cmd []byte, want []byte) {
+	t.Helper()
+
+	rsp, err := c.Transmit(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, want) {
+		t.Fatalf("Transmit(% x) = % x; want % x", cmd, rsp, want)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		apdu   []byte
+		ok     bool
+		ndata  int
+		hasLe  bool
+		ne     int
+		leZero bool
+	}{
+		{[]byte{0, 0xb0, 0, 0}, true, 0, false, 0, false},
+		{[]byte{0, 0xb0, 0, 0, 0x10}, true, 0, true, 16, false},
+		{[]byte{0, 0xb0, 0, 0, 0x00}, true, 0, true, 256, true},
+		{[]byte{0, 0xd6, 0, 0, 0x02, 1, 2}, true, 2, false, 0, false},
+		{[]byte{0, 0xa4, 0, 0, 0x02, 1, 2, 0x00}, true, 2, true, 256, true},
+		{[]byte{0, 0xb0, 0, 0, 0x00, 0x01, 0x00}, true, 0, true, 256, false},
+		{[]byte{0, 0xb0, 0, 0, 0x00, 0x00, 0x00}, true, 0, true, 65536, true},
+		{[]byte{0, 0xd6, 0, 0, 0x00, 0x00, 0x01, 7}, true, 1, false, 0, false},
+		{[]byte{0, 0xa4, 0, 0, 0x00, 0x00, 0x01, 7, 0x00, 0x00}, true, 1, true, 65536, true},
+		{[]byte{0, 0xb0, 0}, false, 0, false, 0, false},
+		{[]byte{0, 0xd6, 0, 0, 0x03, 1, 2}, false, 0, false, 0, false},
+		{[]byte{0, 0xd6, 0, 0, 0x00, 0x00}, false, 0, false, 0, false},
+	}
+
+	for _, tt := range tests {
+		cmd, ok := parseCommand(tt.apdu)
+		if ok != tt.ok {
+			t.Errorf("parseCommand(% x) ok = %v; want %v", tt.apdu, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if len(cmd.data) != tt.ndata || cmd.hasLe != tt.hasLe || cmd.ne != tt.ne || cmd.leZero != tt.leZero {
+			t.Errorf("parseCommand(% x) = %+v", tt.apdu, cmd)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	c := newTestCard()
+
+	transmit(t, c, []byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x01, 0x01}, []byte{0x6a, 0x82})
+	transmit(t, c, []byte{0x00, 0xa4, 0x01, 0x0c, 0x02, 0x10, 0x00}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xa4, 0x02, 0x0c, 0x02, 0x01, 0x01}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xa4, 0x03, 0x0c}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xa4, 0x03, 0x0c}, []byte{0x6a, 0x82})
+
+	// by path from the MF, with FCP
+	fcp := []byte{0x62, 0x11, 0x82, 0x01, 0x01, 0x83, 0x02, 0x01, 0x01, 0x80, 0x02, 0x00, 0x0c, 0x88, 0x01, 0x08, 0x8a, 0x01, 0x05, 0x90, 0x00}
+	transmit(t, c, []byte{0x00, 0xa4, 0x08, 0x04, 0x04, 0x10, 0x00, 0x01, 0x01, 0x00}, fcp)
+
+	// by partial DF name, FCI retrieved with GET RESPONSE
+	transmit(t, c, []byte{0x00, 0xa4, 0x04, 0x00, 0x05, 0xa0, 0x00, 0x00, 0x00, 0x01}, []byte{0x61, 0x14})
+	fci := append([]byte{0x6f, 0x12, 0x82, 0x01, 0x38, 0x83, 0x02, 0x10, 0x00, 0x84, 0x06}, testAID...)
+	fci = append(fci, 0x8a, 0x01, 0x05, 0x90, 0x00)
+	transmit(t, c, []byte{0x00, 0xc0, 0x00, 0x00, 0x20}, []byte{0x6c, 0x14})
+	transmit(t, c, []byte{0x00, 0xc0, 0x00, 0x00, 0x08}, append(fci[:8:8], 0x61, 0x0c))
+	transmit(t, c, []byte{0x00, 0xc0, 0x00, 0x00, 0x00}, fci[8:])
+	transmit(t, c, []byte{0x00, 0xc0, 0x00, 0x00, 0x00}, []byte{0x69, 0x85})
+
+	transmit(t, c, []byte{0x00, 0xa4, 0x04, 0x0c, 0x02, 0xa0, 0x01}, []byte{0x6a, 0x82})
+}
+
+func TestSelectLongFCP(t *testing.T) {
+	tests := []struct {
+		name   int
+		header []byte
+	}{
+		{100, []byte{0x62, 0x70, 0x82, 0x01, 0x38, 0x83, 0x02, 0x20, 0x00, 0x84, 0x64}},
+		{200, []byte{0x62, 0x81, 0xd5, 0x82, 0x01, 0x38, 0x83, 0x02, 0x20, 0x00, 0x84, 0x81, 0xc8}},
+		{300, []byte{0x62, 0x82, 0x01, 0x3a, 0x82, 0x01, 0x38, 0x83, 0x02, 0x20, 0x00, 0x84, 0x82, 0x01, 0x2c}},
+	}
+
+	for _, tt := range tests {
+		c := New(nil)
+		c.MF().AddDF(0x2000, make([]byte, tt.name))
+
+		rsp, _ := c.Transmit([]byte{0x00, 0xa4, 0x00, 0x04, 0x00, 0x00, 0x02, 0x20, 0x00, 0x00, 0x00})
+		want := len(tt.header) + tt.name + 3 + 2
+		if len(rsp) != want || !bytes.HasPrefix(rsp, tt.header) || !bytes.HasSuffix(rsp, []byte{0x8a, 0x01, 0x05, 0x90, 0x00}) {
+			t.Errorf("SELECT DF with %d byte name = % x", tt.name, rsp)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	c := newTestCard()
+
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x00}, []byte{0x69, 0x86})
+	transmit(t, c, []byte{0x00, 0xa4, 0x01, 0x0c, 0x02, 0x10, 0x00}, []byte{0x90, 0x00})
+
+	// by SFI
+	transmit(t, c, []byte{0x00, 0xb0, 0x81, 0x07, 0x00}, []byte("world\x90\x00"))
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x05}, []byte("hello\x90\x00"))
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x07, 0x10}, []byte("world\x62\x82"))
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x0c, 0x00}, []byte{0x6b, 0x00})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x0c, 0x01}, []byte{0x6b, 0x00})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x0d, 0x00}, []byte{0x6b, 0x00})
+
+	transmit(t, c, []byte{0x00, 0xd6, 0x00, 0x07, 0x05, 'g', 'o', 'p', 'h', 'r'}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xd6, 0x00, 0x0b, 0x02, 'e', 'r'}, []byte{0x6a, 0x84})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x00}, []byte("hello, gophr\x90\x00"))
+
+	transmit(t, c, []byte{0x00, 0xa4, 0x02, 0x0c, 0x02, 0x01, 0x02}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x00}, []byte{0x69, 0x81})
+}
+
+func TestChaining(t *testing.T) {
+	c := newTestCard()
+	transmit(t, c, []byte{0x00, 0xa4, 0x01, 0x0c, 0x02, 0x10, 0x00}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xa4, 0x02, 0x0c, 0x02, 0x01, 0x01}, []byte{0x90, 0x00})
+
+	transmit(t, c, []byte{0x10, 0xd6, 0x00, 0x00, 0x02, 'H', 'E'}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x10, 0xd6, 0x00, 0x00, 0x02, 'L', 'L'}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xd6, 0x00, 0x00, 0x01, 'O'}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x05}, []byte("HELLO\x90\x00"))
+
+	// interrupted chain
+	transmit(t, c, []byte{0x10, 0xd6, 0x00, 0x00, 0x02, 'a', 'b'}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x05}, []byte{0x68, 0x83})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x05}, []byte("HELLO\x90\x00"))
+}
+
+func TestRecord(t *testing.T) {
+	c := newTestCard()
+
+	transmit(t, c, []byte{0x00, 0xa4, 0x08, 0x0c, 0x04, 0x10, 0x00, 0x01, 0x02}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb2, 0x02, 0x04, 0x00}, []byte{2, 2, 2, 2, 0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb2, 0x01, 0x04, 0x02}, []byte{0x6c, 0x04})
+	transmit(t, c, []byte{0x00, 0xb2, 0x03, 0x04, 0x00}, []byte{0x6a, 0x83})
+
+	transmit(t, c, []byte{0x00, 0xdc, 0x01, 0x04, 0x02, 9, 9}, []byte{0x67, 0x00})
+	transmit(t, c, []byte{0x00, 0xdc, 0x01, 0x04, 0x04, 9, 9, 9, 9}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb2, 0x01, 0x04, 0x04}, []byte{9, 9, 9, 9, 0x90, 0x00})
+}
+
+func TestVerify(t *testing.T) {
+	c := newTestCard()
+
+	transmit(t, c, []byte{0x00, 0xa4, 0x08, 0x0c, 0x04, 0x10, 0x00, 0x01, 0x03}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0xb0, 0x00, 0x00, 0x00}, []byte{0x69, 0x82})
+
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81}, []byte{0x63, 0xc3})
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81, 0x04, '0', '0', '0', '0'}, []byte{0x63, 0xc2})
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81, 0x04, '1', '2', '3', '4'}, []byte{0x90, 0x00})
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81}, []byte{0x90, 0x00})
+	if n := c.PINRetries(0x81); n != 3 {
+		t.Fatalf("PINRetries() = %d; want 3", n)
+	}
+
+	rsp, _ := c.Transmit([]byte{0x00, 0xb0, 0x00, 0x00, 0x00})
+	if len(rsp) != 258 || !bytes.Equal(rsp[256:], []byte{0x90, 0x00}) {
+		t.Fatalf("READ BINARY: % x", rsp[len(rsp)-2:])
+	}
+	rsp, _ = c.Transmit([]byte{0x00, 0xb0, 0x01, 0x00, 0x30})
+	if len(rsp) != 46 || !bytes.Equal(rsp[44:], []byte{0x62, 0x82}) {
+		t.Fatalf("READ BINARY past the end: % x", rsp[len(rsp)-2:])
+	}
+
+	// extended Le
+	rsp, _ = c.Transmit([]byte{0x00, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if len(rsp) != 302 || !bytes.Equal(rsp[300:], []byte{0x90, 0x00}) {
+		t.Fatalf("READ BINARY: % x", rsp[len(rsp)-2:])
+	}
+
+	c.Reset()
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81}, []byte{0x63, 0xc3})
+
+	for i := 2; i >= 0; i-- {
+		transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81, 0x01, 0x00}, []byte{0x63, 0xc0 | byte(i)})
+	}
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x81, 0x04, '1', '2', '3', '4'}, []byte{0x69, 0x83})
+}
+
+func TestVerifyUnknownPIN(t *testing.T) {
+	c := newTestCard()
+
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x82}, []byte{0x6a, 0x88})
+	transmit(t, c, []byte{0x00, 0x20, 0x00, 0x82, 0x04, '1', '2', '3', '4'}, []byte{0x6a, 0x88})
+	transmit(t, c, []byte{0x00, 0x20, 0xff, 0x82}, []byte{0x6a, 0x88})
+}
+
+func TestDefaultATR(t *testing.T) {
+	var x byte
+	for _, b := range DefaultATR[1:] {
+		x ^= b
+	}
+	if x != 0 {
+		t.Fatalf("DefaultATR % x: bad TCK", DefaultATR)
+	}
+}
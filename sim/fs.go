@@ -0,0 +1,180 @@
+package sim
+
+// FileType is the structure of a File.
+type FileType int
+
+const (
+	// DF is a dedicated file (directory). The MF is a DF.
+	DF FileType = iota
+	// TransparentEF is an elementary file accessed with READ/UPDATE
+	// BINARY.
+	TransparentEF
+	// LinearFixedEF is a record file whose records all have RecordSize
+	// bytes.
+	LinearFixedEF
+	// LinearVariableEF is a record file with records of any size.
+	LinearVariableEF
+)
+
+// File is a node of the card's file system.
+//
+// Files are set up before the card is used. Data and Records reflect
+// updates made by UPDATE BINARY and UPDATE RECORD.
+type File struct {
+	Type FileType
+	// FID is the two byte file identifier.
+	FID uint16
+	// Name is the DF name (AID) of a DF, used by SELECT by name.
+	Name []byte
+	// SFI is the short EF identifier (1-30) of an EF, 0 if none.
+	SFI byte
+
+	// Data is the content of a transparent EF.
+	Data []byte
+	// Records are the records of a record EF; record numbers start at 1.
+	Records [][]byte
+	// RecordSize is the record length of a linear fixed EF.
+	RecordSize int
+
+	// ReadPIN and WritePIN are the references of the PIN that must be
+	// verified before reading or updating the file. 0 means always
+	// allowed.
+	ReadPIN  byte
+	WritePIN byte
+
+	parent   *File
+	children []*File
+}
+
+// MFID is the file identifier of the MF.
+const MFID = 0x3f00
+
+// NewMF returns an empty master file.
+func NewMF() *File {
+	return &File{Type: DF, FID: MFID}
+}
+
+// Parent returns the DF containing f, or nil for the MF.
+func (f *File) Parent() *File {
+	return f.parent
+}
+
+// Children returns the files in DF f.
+func (f *File) Children() []*File {
+	return f.children
+}
+
+// Add adds child to DF f and returns it.
+func (f *File) Add(child *File) *File {
+	child.parent = f
+	f.children = append(f.children, child)
+	return child
+}
+
+// AddDF adds a DF with the given identifier and optional name.
+func (f *File) AddDF(fid uint16, name []byte) *File {
+	return f.Add(&File{Type: DF, FID: fid, Name: name})
+}
+
+// AddEF adds a transparent EF.
+func (f *File) AddEF(fid uint16, data []byte) *File {
+	return f.Add(&File{Type: TransparentEF, FID: fid, Data: data})
+}
+
+// AddRecordEF adds a record EF. If recordSize is 0 the file is linear
+// variable, otherwise linear fixed.
+func (f *File) AddRecordEF(fid uint16, recordSize int, records ...[]byte) *File {
+	typ := LinearFixedEF
+	if recordSize == 0 {
+		typ = LinearVariableEF
+	}
+	return f.Add(&File{Type: typ, FID: fid, RecordSize: recordSize, Records: records})
+}
+
+// Child returns the child of DF f with identifier fid.
+func (f *File) Child(fid uint16) *File {
+	for _, c := range f.children {
+		if c.FID == fid {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *File) childSFI(sfi byte) *File {
+	for _, c := range f.children {
+		if c.Type != DF && c.SFI == sfi {
+			return c
+		}
+	}
+	return nil
+}
+
+// findName returns the first DF below and including f whose name starts
+// with name.
+func (f *File) findName(name []byte) *File {
+	if f.Type != DF {
+		return nil
+	}
+	if len(f.Name) >= len(name) && len(name) > 0 && string(f.Name[:len(name)]) == string(name) {
+		return f
+	}
+	for _, c := range f.children {
+		if found := c.findName(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (f *File) isRecord() bool {
+	return f.Type == LinearFixedEF || f.Type == LinearVariableEF
+}
+
+// fcp returns the file control parameters of f (without the 62 tag).
+func (f *File) fcp() []byte {
+	var b []byte
+
+	switch f.Type {
+	case DF:
+		b = append(b, 0x82, 0x01, 0x38)
+	case TransparentEF:
+		b = append(b, 0x82, 0x01, 0x01)
+	case LinearFixedEF:
+		b = append(b, 0x82, 0x05, 0x02, 0x21, 0x00, byte(f.RecordSize), byte(len(f.Records)))
+	case LinearVariableEF:
+		b = append(b, 0x82, 0x02, 0x04, 0x21)
+	}
+
+	b = append(b, 0x83, 0x02, byte(f.FID>>8), byte(f.FID))
+
+	if f.Type == DF && len(f.Name) > 0 {
+		b = appendTLV(b, 0x84, f.Name)
+	}
+	if f.Type == TransparentEF {
+		b = append(b, 0x80, 0x02, byte(len(f.Data)>>8), byte(len(f.Data)))
+	}
+	if f.SFI != 0 {
+		b = append(b, 0x88, 0x01, f.SFI<<3)
+	}
+
+	// life cycle status: operational, activated
+	b = append(b, 0x8a, 0x01, 0x05)
+
+	return b
+}
+
+// appendTLV appends a data object with a single byte tag to b, using the
+// BER long form for lengths above 127.
+func appendTLV(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	switch n := len(value); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, value...)
+}
@@ -0,0 +1,15 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package scard
+
+import (
+	"testing"
+)
+
+// simulatorContext skips the test; the simulator needs the pcsc-lite
+// backend.
+func simulatorContext(t *testing.T, reason string) *Context {
+	t.Skip(reason)
+	return nil
+}
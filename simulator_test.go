@@ -0,0 +1,40 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package scard
+
+import (
+	"testing"
+
+	"github.com/ebfe/scard/pcscdtest"
+	"github.com/ebfe/scard/sim"
+)
+
+// simulatorContext returns a context connected to a pcscdtest server with a
+// single reader containing a simulated card.
+func simulatorContext(t *testing.T, reason string) *Context {
+	t.Helper()
+	t.Logf("%s; using a simulated card", reason)
+
+	s, err := pcscdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	r, err := s.AddReader("Simulated Reader 00 00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// report an empty feature list for CM_IOCTL_GET_FEATURE_REQUEST
+	r.HandleControl(func(code uint32, in []byte) ([]byte, error) {
+		return nil, nil
+	})
+	r.Insert(sim.New(nil))
+
+	ctx, err := EstablishContextWithBackend(NewPCSCLiteBackend(s.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
@@ -0,0 +1,116 @@
+package scard
+
+import (
+	"errors"
+	"time"
+)
+
+// Backoff of cancelling waits again, in case they had not started waiting
+// yet when they were cancelled. SCardCancel has no effect then.
+const (
+	cancelBackoffMin = 10 * time.Millisecond
+	cancelBackoffMax = time.Second
+)
+
+// getStatusChange calls GetStatusChange on the backend. If the backend
+// limits the number of reader states per call, larger sets of states are
+// split into shards that are waited on concurrently, each on its own
+// context. Once one shard returns, the others are cancelled and queried
+// again with a zero timeout, so that all states are current. Only if that
+// fails too are the states of a shard reported unchanged.
+func (ctx *Context) getStatusChange(timeout time.Duration, states []ReaderState) error {
+	size := 0
+	if l, ok := ctx.backend.(ReaderStateLimiter); ok {
+		size = l.MaxReaderStates()
+	}
+	if size <= 0 || len(states) <= size {
+		return ctx.backend.GetStatusChange(ctx.ctx, timeout, states)
+	}
+
+	handles, err := ctx.shardContexts((len(states) + size - 1) / size)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(handles))
+	for i, h := range handles {
+		go func(i int, h uintptr) {
+			results <- result{i, ctx.backend.GetStatusChange(h, timeout, shard(states, size, i))}
+		}(i, h)
+	}
+
+	first := <-results
+	done := make([]bool, len(handles))
+	errs := make([]error, len(handles))
+	done[first.i], errs[first.i] = true, first.err
+
+	backoff := cancelBackoffMin
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for n := 1; n < len(handles); {
+		select {
+		case r := <-results:
+			done[r.i], errs[r.i] = true, r.err
+			n++
+		case <-timer.C:
+			for i, h := range handles {
+				if !done[i] {
+					ctx.backend.Cancel(h)
+				}
+			}
+			timer.Reset(backoff)
+			if backoff *= 2; backoff > cancelBackoffMax {
+				backoff = cancelBackoffMax
+			}
+		}
+	}
+
+	changed := false
+	for _, err := range errs {
+		changed = changed || err == nil
+	}
+	if !changed {
+		return first.err
+	}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		sh := shard(states, size, i)
+		err = ctx.backend.GetStatusChange(handles[i], 0, sh)
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			for j := range sh {
+				sh[j].EventState = sh[j].CurrentState &^ StateChanged
+			}
+		}
+	}
+	return nil
+}
+
+// shard returns the i-th slice of size states.
+func shard(states []ReaderState, size, i int) []ReaderState {
+	end := (i + 1) * size
+	if end > len(states) {
+		end = len(states)
+	}
+	return states[i*size : end]
+}
+
+// shardContexts returns ctx and n-1 additional contexts, establishing those
+// that do not exist yet. They are kept until ctx is released.
+func (ctx *Context) shardContexts(n int) ([]uintptr, error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	for len(ctx.shards) < n-1 {
+		h, err := ctx.backend.EstablishContext(ctx.scope)
+		if err != nil {
+			return nil, err
+		}
+		ctx.shards = append(ctx.shards, h)
+	}
+	return append([]uintptr{ctx.ctx}, ctx.shards[:n-1]...), nil
+}
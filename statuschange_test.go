@@ -0,0 +1,262 @@
+package scard
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rackBackend has many readers and, like pcsc-lite, rejects more than
+// limit reader states per GetStatusChange call if limit is set.
+type rackBackend struct {
+	fakeBackend
+	limit int
+
+	mu       sync.Mutex
+	present  map[string]bool
+	changed  chan struct{} // closed when a card is inserted
+	contexts map[uintptr]chan struct{}
+	waiting  map[uintptr]chan struct{}
+	next     uintptr
+}
+
+func newRackBackend(n, limit int) *rackBackend {
+	b := &rackBackend{
+		limit:    limit,
+		present:  map[string]bool{},
+		changed:  make(chan struct{}),
+		contexts: map[uintptr]chan struct{}{},
+		waiting:  map[uintptr]chan struct{}{},
+	}
+	for i := 0; i < n; i++ {
+		b.present[fmt.Sprintf("Reader %02d", i)] = false
+	}
+	return b
+}
+
+func (b *rackBackend) insert(reader string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.present[reader] = true
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *rackBackend) MaxReaderStates() int {
+	return b.limit
+}
+
+func (b *rackBackend) EstablishContext(scope Scope) (uintptr, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	b.contexts[b.next] = nil
+	return b.next, nil
+}
+
+func (b *rackBackend) ReleaseContext(ctx uintptr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.contexts[ctx]; !ok {
+		return ErrInvalidHandle
+	}
+	delete(b.contexts, ctx)
+	return nil
+}
+
+// Cancel only affects a context that is waiting, like SCardCancel.
+func (b *rackBackend) Cancel(ctx uintptr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.waiting[ctx]; ok {
+		close(c)
+		delete(b.waiting, ctx)
+	}
+	return nil
+}
+
+func (b *rackBackend) GetStatusChange(ctx uintptr, timeout time.Duration, states []ReaderState) error {
+	if b.limit > 0 && len(states) > b.limit {
+		return ErrInvalidValue
+	}
+	cancel := make(chan struct{})
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		expired = time.After(timeout)
+	}
+	for {
+		b.mu.Lock()
+		if _, ok := b.contexts[ctx]; !ok {
+			b.mu.Unlock()
+			return ErrInvalidHandle
+		}
+		changed := false
+		for i := range states {
+			present, ok := b.present[states[i].Reader]
+			if !ok {
+				b.mu.Unlock()
+				return ErrUnknownReader
+			}
+			states[i].EventState = StateEmpty
+			if present {
+				states[i].EventState = StatePresent
+			}
+			if states[i].EventState != states[i].CurrentState {
+				states[i].EventState |= StateChanged
+				changed = true
+			}
+		}
+		wake := b.changed
+		b.waiting[ctx] = cancel
+		b.mu.Unlock()
+		if changed {
+			b.Cancel(ctx)
+			return nil
+		}
+
+		select {
+		case <-wake:
+		case <-cancel:
+			return ErrCancelled
+		case <-expired:
+			b.Cancel(ctx)
+			return ErrTimeout
+		}
+	}
+}
+
+func rackStates(b *rackBackend) []ReaderState {
+	rs := make([]ReaderState, len(b.present))
+	for i := range rs {
+		rs[i] = ReaderState{Reader: fmt.Sprintf("Reader %02d", i), CurrentState: StateEmpty}
+	}
+	return rs
+}
+
+func TestGetStatusChangeShards(t *testing.T) {
+	b := newRackBackend(40, 16)
+	ctx, err := EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := rackStates(b)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.insert("Reader 37")
+	}()
+	if err := ctx.GetStatusChange(rs, time.Second); err != nil {
+		t.Fatalf("GetStatusChange() = %v", err)
+	}
+	for i := range rs {
+		want := StateEmpty
+		if i == 37 {
+			want = StateChanged | StatePresent
+		}
+		if rs[i].EventState != want {
+			t.Errorf("%s: EventState = %v, want %v", rs[i].Reader, rs[i].EventState, want)
+		}
+	}
+
+	// the additional contexts are reused
+	rs[37].CurrentState = StatePresent
+	if err := ctx.GetStatusChange(rs, 20*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("GetStatusChange() = %v, want %v", err, ErrTimeout)
+	}
+	if n := len(b.contexts); n != 3 {
+		t.Errorf("%d contexts established, want 3", n)
+	}
+
+	if err := ctx.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(b.contexts); n != 0 {
+		t.Errorf("%d contexts not released", n)
+	}
+}
+
+// racingBackend inserts a card without waking any waiter when the first
+// wait is cancelled, as if it had been inserted while being cancelled.
+type racingBackend struct {
+	*rackBackend
+	reader string
+	once   sync.Once
+}
+
+func (b *racingBackend) Cancel(ctx uintptr) error {
+	b.once.Do(func() {
+		b.mu.Lock()
+		b.present[b.reader] = true
+		b.mu.Unlock()
+	})
+	return b.rackBackend.Cancel(ctx)
+}
+
+func TestGetStatusChangeShardsRequery(t *testing.T) {
+	b := &racingBackend{rackBackend: newRackBackend(40, 16), reader: "Reader 37"}
+	ctx, err := EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	rs := rackStates(b.rackBackend)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.insert("Reader 03")
+	}()
+	if err := ctx.GetStatusChange(rs, time.Second); err != nil {
+		t.Fatalf("GetStatusChange() = %v", err)
+	}
+	for _, i := range []int{3, 37} {
+		if want := StateChanged | StatePresent; rs[i].EventState != want {
+			t.Errorf("%s: EventState = %v, want %v", rs[i].Reader, rs[i].EventState, want)
+		}
+	}
+}
+
+func TestGetStatusChangeShardsCancel(t *testing.T) {
+	b := newRackBackend(24, 16)
+	ctx, err := EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ctx.Cancel()
+	}()
+	if err := ctx.GetStatusChange(rackStates(b), -1); !errors.Is(err, ErrCancelled) {
+		t.Errorf("GetStatusChange() = %v, want %v", err, ErrCancelled)
+	}
+
+	rs := rackStates(b)
+	rs[20].Reader = "Reader 99"
+	if err := ctx.GetStatusChange(rs, -1); !errors.Is(err, ErrUnknownReader) {
+		t.Errorf("GetStatusChange() = %v, want %v", err, ErrUnknownReader)
+	}
+}
+
+func TestGetStatusChangeNoLimit(t *testing.T) {
+	b := newRackBackend(40, 0)
+	ctx, err := EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	rs := rackStates(b)
+	b.insert("Reader 37")
+	if err := ctx.GetStatusChange(rs, time.Second); err != nil {
+		t.Fatalf("GetStatusChange() = %v", err)
+	}
+	if rs[37].EventState != StateChanged|StatePresent {
+		t.Errorf("EventState = %v, want %v", rs[37].EventState, StateChanged|StatePresent)
+	}
+	if n := len(b.contexts); n != 1 {
+		t.Errorf("%d contexts established, want 1", n)
+	}
+}
@@ -0,0 +1,348 @@
+package tlv
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Unmarshal decodes the BER-TLV data objects in data into the struct pointed
+// to by v.
+//
+// The fields of the struct are matched by tag, regardless of the order of
+// the data objects. A field is decoded if it has a struct tag of the form
+//
+//	tlv:"<tag>[,option...]"
+//
+// where <tag> is the tag in hex, e.g. `tlv:"5F20"`. The options are:
+//
+//	optional     the data object may be missing; Marshal omits zero values
+//	constructed  the data object is constructed; this is checked against
+//	             the tag
+//
+// Data objects without a matching field are ignored. A missing data object
+// for a field that is not optional, or a pointer, is an error.
+//
+// The field types are decoded as follows:
+//
+//	[]byte          the value; for a constructed data object its encoded
+//	                children
+//	string          the value
+//	integer types   the value as a big endian unsigned number
+//	struct          the children of a constructed data object, recursively
+//	TLV, *TLV       the data object itself
+//	*T              a newly allocated T, left nil if the data object is
+//	                missing
+//	[]T             all data objects with the tag, for T other than byte
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("tlv: Unmarshal needs a non-nil pointer to a struct, got %T", v)
+	}
+	tlvs, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return decodeStruct(tlvs, rv.Elem())
+}
+
+// Marshal encodes the struct v, or the struct v points to, as the sequence
+// of the data objects of its fields, in field order. See Unmarshal for the
+// struct tags and field types.
+func Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("tlv: Marshal needs a struct, got %T", v)
+	}
+	tlvs, err := encodeStruct(rv)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(tlvs...)
+}
+
+var tlvType = reflect.TypeOf(TLV{})
+
+type field struct {
+	index       int
+	name        string
+	tag         Tag
+	optional    bool
+	constructed bool
+}
+
+// fields returns the fields of struct type t that have a tlv struct tag.
+func fields(t reflect.Type) ([]field, error) {
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		s, ok := sf.Tag.Lookup("tlv")
+		if !ok || s == "-" || !sf.IsExported() {
+			continue
+		}
+		f := field{index: i, name: t.Name() + "." + sf.Name}
+		name, opts, _ := strings.Cut(s, ",")
+		tag, err := ParseTag(name)
+		if err != nil {
+			return nil, fmt.Errorf("tlv: field %s: %w", f.name, err)
+		}
+		f.tag = tag
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "":
+			case "optional":
+				f.optional = true
+			case "constructed":
+				f.constructed = true
+			default:
+				return nil, fmt.Errorf("tlv: field %s: unknown option %q", f.name, opt)
+			}
+		}
+		if f.constructed && !tag.Constructed() {
+			return nil, fmt.Errorf("tlv: field %s: tag %s is not constructed", f.name, tag)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// isList reports whether values of type t hold all data objects with a tag.
+func isList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func decodeStruct(tlvs []*TLV, v reflect.Value) error {
+	fs, err := fields(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		fv := v.Field(f.index)
+		if isList(fv.Type()) {
+			list := reflect.Zero(fv.Type())
+			for _, t := range tlvs {
+				if t.Tag != f.tag {
+					continue
+				}
+				elem := reflect.New(fv.Type().Elem()).Elem()
+				if err := decode(t, elem); err != nil {
+					return fmt.Errorf("tlv: field %s: %w", f.name, err)
+				}
+				list = reflect.Append(list, elem)
+			}
+			if list.Len() == 0 && !f.optional {
+				return fmt.Errorf("tlv: field %s: data object %s missing", f.name, f.tag)
+			}
+			fv.Set(list)
+			continue
+		}
+
+		t := findTag(tlvs, f.tag)
+		if t == nil {
+			if f.optional || fv.Kind() == reflect.Pointer {
+				continue
+			}
+			return fmt.Errorf("tlv: field %s: data object %s missing", f.name, f.tag)
+		}
+		if err := decode(t, fv); err != nil {
+			return fmt.Errorf("tlv: field %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+func decode(t *TLV, v reflect.Value) error {
+	switch {
+	case v.Type() == tlvType:
+		v.Set(reflect.ValueOf(*t))
+		return nil
+	case v.Type() == reflect.PtrTo(tlvType):
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(v.Type().Elem())
+		if err := decode(t, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		if !t.Tag.Constructed() {
+			return fmt.Errorf("data object %s is primitive", t.Tag)
+		}
+		return decodeStruct(t.Children, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		b, err := content(t)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(b)
+	case reflect.String:
+		if t.Tag.Constructed() {
+			return fmt.Errorf("data object %s is constructed", t.Tag)
+		}
+		v.SetString(string(t.Value))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := decodeUint(t, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := decodeUint(t, v.Type().Bits()-1)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// content returns a copy of the value of t, or the encoding of its children
+// if it is constructed.
+func content(t *TLV) ([]byte, error) {
+	if t.Tag.Constructed() {
+		return Encode(t.Children...)
+	}
+	return append([]byte{}, t.Value...), nil
+}
+
+var errRange = errors.New("value out of range")
+
+// decodeUint decodes the value of t as a big endian number of at most bits
+// bits.
+func decodeUint(t *TLV, bits int) (uint64, error) {
+	if t.Tag.Constructed() {
+		return 0, fmt.Errorf("data object %s is constructed", t.Tag)
+	}
+	v := t.Value
+	for len(v) > 0 && v[0] == 0 {
+		v = v[1:]
+	}
+	var n uint64
+	for _, b := range v {
+		n = n<<8 | uint64(b)
+	}
+	if len(v) > 8 || (bits < 64 && n>>bits != 0) {
+		return 0, fmt.Errorf("data object %s: %w", t.Tag, errRange)
+	}
+	return n, nil
+}
+
+func encodeStruct(v reflect.Value) ([]*TLV, error) {
+	fs, err := fields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var tlvs []*TLV
+	for _, f := range fs {
+		fv := v.Field(f.index)
+		switch {
+		case isList(fv.Type()):
+			for i := 0; i < fv.Len(); i++ {
+				t, err := encode(f.tag, fv.Index(i))
+				if err != nil {
+					return nil, fmt.Errorf("tlv: field %s: %w", f.name, err)
+				}
+				tlvs = append(tlvs, t)
+			}
+			continue
+		case fv.Kind() == reflect.Pointer && fv.IsNil():
+			continue
+		case f.optional && fv.IsZero():
+			continue
+		}
+		t, err := encode(f.tag, fv)
+		if err != nil {
+			return nil, fmt.Errorf("tlv: field %s: %w", f.name, err)
+		}
+		tlvs = append(tlvs, t)
+	}
+	return tlvs, nil
+}
+
+func encode(tag Tag, v reflect.Value) (*TLV, error) {
+	// nil pointers of non-list fields are skipped by encodeStruct, so this
+	// is a nil element of a list
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil, fmt.Errorf("nil %s in list", v.Type())
+	}
+
+	switch {
+	case v.Type() == tlvType:
+		t := v.Interface().(TLV)
+		t.Tag = tag
+		return &t, nil
+	case v.Type() == reflect.PtrTo(tlvType):
+		t := *v.Interface().(*TLV)
+		t.Tag = tag
+		return &t, nil
+	}
+
+	t := &TLV{Tag: tag}
+	switch v.Kind() {
+	case reflect.Pointer:
+		return encode(tag, v.Elem())
+	case reflect.Struct:
+		if !tag.Constructed() {
+			return nil, fmt.Errorf("tag %s is primitive", tag)
+		}
+		var err error
+		if t.Children, err = encodeStruct(v); err != nil {
+			return nil, err
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("unsupported type %s", v.Type())
+		}
+		if tag.Constructed() {
+			var err error
+			if t.Children, err = Parse(v.Bytes()); err != nil {
+				return nil, err
+			}
+		} else {
+			t.Value = v.Bytes()
+		}
+	case reflect.String:
+		if tag.Constructed() {
+			return nil, fmt.Errorf("tag %s is constructed", tag)
+		}
+		t.Value = []byte(v.String())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		t.Value = encodeUint(v.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return nil, fmt.Errorf("negative value %d", v.Int())
+		}
+		t.Value = encodeUint(uint64(v.Int()))
+	default:
+		return nil, fmt.Errorf("unsupported type %s", v.Type())
+	}
+	if !tag.Constructed() && t.Children != nil {
+		return nil, fmt.Errorf("tag %s is primitive", tag)
+	}
+	if tag.Constructed() && t.Value != nil {
+		return nil, fmt.Errorf("tag %s is constructed", tag)
+	}
+	return t, nil
+}
+
+// encodeUint returns n in big endian with at least one byte.
+func encodeUint(n uint64) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n != 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
+}
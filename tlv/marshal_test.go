@@ -0,0 +1,207 @@
+package tlv
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type cardholder struct {
+	Name string `tlv:"5B"`
+	Lang string `tlv:"5F2D,optional"`
+	Sex  uint8  `tlv:"5F35,optional"`
+}
+
+type publicKey struct {
+	Modulus  []byte `tlv:"81"`
+	Exponent uint32 `tlv:"82"`
+}
+
+type keyInfo struct {
+	Holder cardholder `tlv:"65,constructed"`
+	Key    *publicKey `tlv:"7F49,constructed"`
+	Extra  *TLV       `tlv:"C1"`
+	Apps   [][]byte   `tlv:"4F,optional"`
+}
+
+type fciProprietary struct {
+	Label    string `tlv:"50"`
+	Priority int    `tlv:"87,optional"`
+	Discr    []byte `tlv:"BF0C,optional"`
+}
+
+type fciTemplate struct {
+	FCI struct {
+		AID         []byte         `tlv:"84"`
+		Proprietary fciProprietary `tlv:"A5,constructed"`
+	} `tlv:"6F"`
+}
+
+func TestUnmarshalFCI(t *testing.T) {
+	var v fciTemplate
+	if err := Unmarshal(fci, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.FCI.AID, unhex("a0000000031010")) || v.FCI.Proprietary.Label != "VISA CREDIT" ||
+		v.FCI.Proprietary.Priority != 0 || !bytes.Equal(v.FCI.Proprietary.Discr, unhex("5f2d00")) {
+		t.Fatalf("Unmarshal() = %+v", v)
+	}
+
+	b, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, fci) {
+		t.Errorf("Marshal() = %x; want %x", b, fci)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := keyInfo{
+		Holder: cardholder{Name: "Doe<<John", Sex: 1},
+		Key:    &publicKey{Modulus: []byte{0xc0, 0xff, 0xee}, Exponent: 65537},
+		Extra:  New(0xc1, []byte{1, 2}),
+		Apps:   [][]byte{{0xa0, 0x01}, {0xa0, 0x02}},
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := unhex("650f" + "5b09446f653c3c4a6f686e" + "5f350101" +
+		"7f490a" + "8103c0ffee" + "8203010001" +
+		"c1020102" + "4f02a001" + "4f02a002")
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Marshal() = %x; want %x", b, want)
+	}
+
+	var out keyInfo
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal(Marshal(%+v)) = %+v", in, out)
+	}
+}
+
+func TestUnmarshalOptional(t *testing.T) {
+	var v keyInfo
+	if err := Unmarshal(unhex("c100"+"ff00"+"65035b0141"+"9f0100"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Holder.Name != "A" || v.Key != nil || v.Apps != nil || v.Extra == nil || v.Extra.Tag != 0xc1 {
+		t.Errorf("Unmarshal() = %+v", v)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		v    interface{}
+		err  string
+	}{
+		{"c100", &keyInfo{}, "data object 65 missing"},
+		{"65005b00c100", &keyInfo{}, "cardholder.Name: data object 5B missing"},
+		{"5b0141", &struct {
+			N uint8 `tlv:"5B"`
+			M int8  `tlv:"5C,optional"`
+		}{}, ""},
+		{"5b020100", &struct {
+			N uint8 `tlv:"5B"`
+		}{}, "out of range"},
+		{"5b0180", &struct {
+			N int8 `tlv:"5B"`
+		}{}, "out of range"},
+		{"5b0a000000000000000000ff", &struct {
+			N uint64 `tlv:"5B"`
+		}{}, ""},
+		{"5b00", &struct {
+			N cardholder `tlv:"5B"`
+		}{}, "primitive"},
+		{"5b00", &struct {
+			N cardholder `tlv:"5B,constructed"`
+		}{}, "not constructed"},
+		{"5b00", &struct {
+			N bool `tlv:"5B"`
+		}{}, "unsupported type"},
+		{"5b00", &struct {
+			N string `tlv:"5B,required"`
+		}{}, "unknown option"},
+		{"5b00", &struct {
+			N string `tlv:"1F"`
+		}{}, "invalid tag"},
+		{"5b", &cardholder{}, "truncated"},
+		{"5b00", cardholder{}, "pointer to a struct"},
+	}
+	for _, tt := range tests {
+		err := Unmarshal(unhex(tt.data), tt.v)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Unmarshal(%s, %T) = %v", tt.data, tt.v, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Unmarshal(%s, %T) = %v; want %q", tt.data, tt.v, err, tt.err)
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		v   interface{}
+		err string
+	}{
+		{struct {
+			N int `tlv:"5B"`
+		}{-1}, "negative"},
+		{struct {
+			N string `tlv:"65"`
+		}{"x"}, "constructed"},
+		{struct {
+			N uint `tlv:"65"`
+		}{1}, "constructed"},
+		{struct {
+			N []byte `tlv:"65"`
+		}{[]byte{0x5b}}, "truncated"},
+		{struct {
+			N float64 `tlv:"5B"`
+		}{1}, "unsupported type"},
+		{"x", "needs a struct"},
+		{struct {
+			N []*TLV `tlv:"5B"`
+		}{[]*TLV{nil}}, "nil *tlv.TLV"},
+		{struct {
+			N []*struct {
+				M []byte `tlv:"5B"`
+			} `tlv:"65"`
+		}{N: make([]*struct {
+			M []byte `tlv:"5B"`
+		}, 1)}, "nil *struct"},
+	}
+	for _, tt := range tests {
+		if _, err := Marshal(tt.v); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Marshal(%+v) = %v; want %q", tt.v, err, tt.err)
+		}
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add(unhex("650f5b09446f653c3c4a6f686e5f3501017f490a8103c0ffee8203010001c10201024f02a0014f02a002"))
+	f.Add(unhex("c100ff0065035b01419f0100"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v keyInfo
+		if err := Unmarshal(data, &v); err != nil {
+			return
+		}
+		b, err := Marshal(&v)
+		if err != nil {
+			t.Fatalf("Marshal(%+v) = %v", v, err)
+		}
+		var again keyInfo
+		if err := Unmarshal(b, &again); err != nil {
+			t.Fatalf("Unmarshal(Marshal(%+v)) = %v", v, err)
+		}
+		if !reflect.DeepEqual(again, v) {
+			t.Fatalf("Unmarshal(Marshal(%+v)) = %+v", v, again)
+		}
+	})
+}
@@ -0,0 +1,324 @@
+// Package tlv decodes and encodes BER-TLV data objects as used by smart card
+// applications (ISO/IEC 7816-4, EMV, PIV, OpenPGP, GlobalPlatform).
+//
+// Parse decodes data into a tree of TLV values, in which data objects can be
+// looked up by a path of tags:
+//
+//	tlvs, err := tlv.Parse(fci)
+//	if err != nil {
+//		return err
+//	}
+//	if t := tlv.Find(tlvs, "6F/A5/BF0C"); t != nil {
+//		...
+//	}
+//
+// Unmarshal and Marshal map data objects to struct fields by struct tags,
+// similar to encoding/asn1.
+//
+// Only the definite length forms are supported. The padding bytes 00 and FF
+// are skipped where a tag is expected.
+package tlv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTruncated is returned when data ends within a data object.
+	ErrTruncated = errors.New("tlv: truncated data")
+	// ErrInvalidTag is returned for tags that are not valid BER-TLV tags or
+	// are longer than 4 bytes.
+	ErrInvalidTag = errors.New("tlv: invalid tag")
+	// ErrInvalidLength is returned for length fields that are malformed or
+	// longer than 4 bytes.
+	ErrInvalidLength = errors.New("tlv: invalid length")
+	// ErrIndefiniteLength is returned for the indefinite length form (80),
+	// which is not used by smart card applications.
+	ErrIndefiniteLength = errors.New("tlv: indefinite length not supported")
+)
+
+// Class is the class of a tag.
+type Class byte
+
+const (
+	Universal       Class = 0
+	Application     Class = 1
+	ContextSpecific Class = 2
+	Private         Class = 3
+)
+
+// Tag is a BER-TLV tag. Its value is the tag bytes read as a big endian
+// number, as tags are usually written, e.g. 0x5F20 or 0xBF0C.
+type Tag uint32
+
+// ParseTag parses a tag written in hex, e.g. "5F20".
+func ParseTag(s string) (Tag, error) {
+	if len(s) == 0 || len(s) > 8 || len(s)%2 != 0 {
+		return 0, fmt.Errorf("tlv: invalid tag %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || !Tag(v).valid() {
+		return 0, fmt.Errorf("tlv: invalid tag %q", s)
+	}
+	return Tag(v), nil
+}
+
+// Bytes returns the encoding of the tag.
+func (t Tag) Bytes() []byte {
+	n := t.len()
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(t)
+		t >>= 8
+	}
+	return b
+}
+
+func (t Tag) len() int {
+	n := 1
+	for v := t >> 8; v != 0; v >>= 8 {
+		n++
+	}
+	return n
+}
+
+// first returns the first byte of the tag.
+func (t Tag) first() byte {
+	return byte(t >> (8 * (t.len() - 1)))
+}
+
+// valid reports whether the tag is encoded correctly: the first byte has
+// all tag number bits set if and only if there are more bytes, and all
+// subsequent bytes except the last have bit 8 set. The tag number must not
+// start with zero bits.
+func (t Tag) valid() bool {
+	b := t.Bytes()
+	if b[0] == 0x00 || b[0] == 0xff {
+		return false
+	}
+	if len(b) == 1 {
+		return b[0]&0x1f != 0x1f
+	}
+	if b[0]&0x1f != 0x1f || b[1] == 0x80 {
+		return false
+	}
+	for _, c := range b[1 : len(b)-1] {
+		if c&0x80 == 0 {
+			return false
+		}
+	}
+	return b[len(b)-1]&0x80 == 0
+}
+
+// Class returns the class of the tag.
+func (t Tag) Class() Class {
+	return Class(t.first() >> 6)
+}
+
+// Constructed reports whether the tag is that of a constructed data object,
+// whose value consists of data objects.
+func (t Tag) Constructed() bool {
+	return t.first()&0x20 != 0
+}
+
+// String returns the tag in hex, e.g. "5F20".
+func (t Tag) String() string {
+	return fmt.Sprintf("%0*X", 2*t.len(), uint32(t))
+}
+
+// TLV is a BER-TLV data object.
+type TLV struct {
+	Tag Tag
+	// Value is the value of a primitive data object.
+	Value []byte
+	// Children are the data objects in the value of a constructed data
+	// object.
+	Children []*TLV
+}
+
+// New returns a primitive data object.
+func New(tag Tag, value []byte) *TLV {
+	return &TLV{Tag: tag, Value: value}
+}
+
+// NewConstructed returns a constructed data object.
+func NewConstructed(tag Tag, children ...*TLV) *TLV {
+	return &TLV{Tag: tag, Children: children}
+}
+
+// Parse decodes the data objects in b. The values of primitive data objects
+// refer to b.
+func Parse(b []byte) ([]*TLV, error) {
+	tlvs, err := parse(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	return tlvs, nil
+}
+
+func parse(b []byte, offset int) ([]*TLV, error) {
+	var tlvs []*TLV
+	for i := 0; i < len(b); {
+		if b[i] == 0x00 || b[i] == 0xff {
+			i++
+			continue
+		}
+		start := i
+		tag, n, err := parseTag(b[i:])
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset+start)
+		}
+		i += n
+		length, n, err := parseLength(b[i:])
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset+start)
+		}
+		i += n
+		if length > len(b)-i {
+			return nil, fmt.Errorf("%w at offset %d", ErrTruncated, offset+start)
+		}
+		value := b[i : i+length : i+length]
+
+		t := &TLV{Tag: tag}
+		if tag.Constructed() {
+			if t.Children, err = parse(value, offset+i); err != nil {
+				return nil, err
+			}
+		} else {
+			t.Value = value
+		}
+		tlvs = append(tlvs, t)
+		i += length
+	}
+	return tlvs, nil
+}
+
+func parseTag(b []byte) (Tag, int, error) {
+	tag := Tag(b[0])
+	if b[0]&0x1f != 0x1f {
+		return tag, 1, nil
+	}
+	for i := 1; i < len(b); i++ {
+		if i == 4 || (i == 1 && b[i] == 0x80) {
+			return 0, 0, ErrInvalidTag
+		}
+		tag = tag<<8 | Tag(b[i])
+		if b[i]&0x80 == 0 {
+			return tag, i + 1, nil
+		}
+	}
+	return 0, 0, ErrTruncated
+}
+
+func parseLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrTruncated
+	}
+	switch {
+	case b[0] < 0x80:
+		return int(b[0]), 1, nil
+	case b[0] == 0x80:
+		return 0, 0, ErrIndefiniteLength
+	case b[0] > 0x84:
+		return 0, 0, ErrInvalidLength
+	}
+	n := int(b[0] & 0x7f)
+	if len(b) < 1+n {
+		return 0, 0, ErrTruncated
+	}
+	var length uint32
+	for _, c := range b[1 : 1+n] {
+		length = length<<8 | uint32(c)
+	}
+	if uint64(length) > uint64(int(^uint(0)>>1)) {
+		return 0, 0, ErrInvalidLength
+	}
+	return int(length), 1 + n, nil
+}
+
+// Find returns the first data object in tlvs matching path, a list of tags
+// in hex separated by "/", e.g. "6F/A5/BF0C", or nil if there is none.
+func Find(tlvs []*TLV, path string) *TLV {
+	var t *TLV
+	for _, s := range strings.Split(path, "/") {
+		tag, err := ParseTag(s)
+		if err != nil {
+			return nil
+		}
+		if t != nil {
+			tlvs = t.Children
+		}
+		if t = findTag(tlvs, tag); t == nil {
+			return nil
+		}
+	}
+	return t
+}
+
+func findTag(tlvs []*TLV, tag Tag) *TLV {
+	for _, t := range tlvs {
+		if t.Tag == tag {
+			return t
+		}
+	}
+	return nil
+}
+
+// Find is like the Find function but looks up path in the children of t.
+func (t *TLV) Find(path string) *TLV {
+	return Find(t.Children, path)
+}
+
+// Encode returns the encoding of tlvs.
+func Encode(tlvs ...*TLV) ([]byte, error) {
+	var b []byte
+	for _, t := range tlvs {
+		var err error
+		if b, err = t.appendTo(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// MarshalBinary returns the encoding of t. Lengths are encoded in the
+// shortest form.
+func (t *TLV) MarshalBinary() ([]byte, error) {
+	return t.appendTo(nil)
+}
+
+func (t *TLV) appendTo(b []byte) ([]byte, error) {
+	if !t.Tag.valid() {
+		return nil, fmt.Errorf("%w %s", ErrInvalidTag, t.Tag)
+	}
+	value := t.Value
+	if t.Tag.Constructed() {
+		var err error
+		if value, err = Encode(t.Children...); err != nil {
+			return nil, err
+		}
+	} else if t.Children != nil {
+		return nil, fmt.Errorf("tlv: primitive data object %s has children", t.Tag)
+	}
+	b = append(b, t.Tag.Bytes()...)
+	b = appendLength(b, len(value))
+	return append(b, value...), nil
+}
+
+func appendLength(b []byte, n int) []byte {
+	switch {
+	case n < 0x80:
+		return append(b, byte(n))
+	case n <= 0xff:
+		return append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		return append(b, 0x82, byte(n>>8), byte(n))
+	case n <= 0xffffff:
+		return append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	default:
+		return append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
@@ -0,0 +1,201 @@
+package tlv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// fci is the FCI of a payment application.
+var fci = unhex("6f1e8407a0000000031010a513500b5649534120435245444954bf0c035f2d00")
+
+func TestTag(t *testing.T) {
+	tests := []struct {
+		s           string
+		tag         Tag
+		class       Class
+		constructed bool
+	}{
+		{"84", 0x84, ContextSpecific, false},
+		{"6F", 0x6f, Application, true},
+		{"5F20", 0x5f20, Application, false},
+		{"BF0C", 0xbf0c, ContextSpecific, true},
+		{"7F49", 0x7f49, Application, true},
+		{"9F02", 0x9f02, ContextSpecific, false},
+		{"DF8101", 0xdf8101, Private, false},
+		{"30", 0x30, Universal, true},
+	}
+	for _, tt := range tests {
+		tag, err := ParseTag(tt.s)
+		if err != nil || tag != tt.tag {
+			t.Errorf("ParseTag(%q) = %v, %v; want %v", tt.s, tag, err, tt.tag)
+			continue
+		}
+		if tag.String() != tt.s || tag.Class() != tt.class || tag.Constructed() != tt.constructed {
+			t.Errorf("%s: String() = %q, Class() = %v, Constructed() = %v", tt.s, tag, tag.Class(), tag.Constructed())
+		}
+	}
+
+	for _, s := range []string{"", "5", "1F", "5F", "5F80", "5F2020", "00", "FF", "1F808001", "5F818181", "xx"} {
+		if tag, err := ParseTag(s); err == nil {
+			t.Errorf("ParseTag(%q) = %v; want error", s, tag)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tlvs, err := Parse(fci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlvs) != 1 || tlvs[0].Tag != 0x6f || len(tlvs[0].Children) != 2 {
+		t.Fatalf("Parse() = %+v", tlvs)
+	}
+
+	if aid := Find(tlvs, "6F/84"); aid == nil || !bytes.Equal(aid.Value, unhex("a0000000031010")) {
+		t.Errorf("Find(6F/84) = %+v", aid)
+	}
+	if label := Find(tlvs, "6F/A5/50"); label == nil || string(label.Value) != "VISA CREDIT" {
+		t.Errorf("Find(6F/A5/50) = %+v", label)
+	}
+	if lang := tlvs[0].Find("A5/BF0C/5F2D"); lang == nil || len(lang.Value) != 0 {
+		t.Errorf("Find(A5/BF0C/5F2D) = %+v", lang)
+	}
+	for _, path := range []string{"6F/A5/87", "84", "6F/84/50", "6F/xx", ""} {
+		if found := Find(tlvs, path); found != nil {
+			t.Errorf("Find(%q) = %+v; want nil", path, found)
+		}
+	}
+
+	b, err := Encode(tlvs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, fci) {
+		t.Errorf("Encode() = %x; want %x", b, fci)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{"5f", ErrTruncated},
+		{"5f20", ErrTruncated},
+		{"5f2003aabb", ErrTruncated},
+		{"6f0384", ErrTruncated},
+		{"8482", ErrTruncated},
+		{"5f8001", ErrInvalidTag},
+		{"5f81818101", ErrInvalidTag},
+		{"6f80000000", ErrIndefiniteLength},
+		{"848500000000000000", ErrInvalidLength},
+	}
+	for _, tt := range tests {
+		if tlvs, err := Parse(unhex(tt.data)); !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%s) = %+v, %v; want %v", tt.data, tlvs, err, tt.err)
+		}
+	}
+}
+
+func TestParseLengths(t *testing.T) {
+	for _, n := range []int{0, 1, 0x7f, 0x80, 0xff, 0x100, 0xffff, 0x10000} {
+		b, err := New(0xc1, make([]byte, n)).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlvs, err := Parse(b)
+		if err != nil || len(tlvs) != 1 || len(tlvs[0].Value) != n {
+			t.Errorf("%d bytes: Parse() = %v", n, err)
+		}
+	}
+
+	// non-minimal lengths are accepted
+	tlvs, err := Parse(unhex("840101" + "84820001aa" + "00ff" + "8400"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlvs) != 3 || tlvs[1].Value[0] != 0xaa || len(tlvs[2].Value) != 0 {
+		t.Errorf("Parse() = %+v", tlvs)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tree := NewConstructed(0x7f49,
+		New(0x81, []byte{1, 2, 3}),
+		New(0x82, []byte{1, 0, 1}),
+	)
+	b, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex("7f490a81030102038203010001"); !bytes.Equal(b, want) {
+		t.Errorf("MarshalBinary() = %x; want %x", b, want)
+	}
+
+	if _, err := New(0x1f, nil).MarshalBinary(); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("MarshalBinary(tag 1F) = %v; want %v", err, ErrInvalidTag)
+	}
+	if _, err := (&TLV{Tag: 0x84, Children: []*TLV{New(0x84, nil)}}).MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary(primitive with children) succeeded")
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(fci)
+	f.Add(unhex("7f490a81030102038203010001"))
+	f.Add(unhex("5f2003aabbcc00ff9f0281020000"))
+	f.Add(unhex("e0045f2001e0"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tlvs, err := Parse(data)
+		if err != nil {
+			return
+		}
+		b, err := Encode(tlvs...)
+		if err != nil {
+			t.Fatalf("Encode(Parse(%x)) = %v", data, err)
+		}
+		again, err := Parse(b)
+		if err != nil {
+			t.Fatalf("Parse(Encode(Parse(%x))) = %v", data, err)
+		}
+		if !reflect.DeepEqual(again, tlvs) {
+			t.Fatalf("Parse(Encode(Parse(%x))) = %+v; want %+v", data, again, tlvs)
+		}
+		b2, err := Encode(again...)
+		if err != nil || !bytes.Equal(b2, b) {
+			t.Fatalf("Encode is not stable for %x: %x, %x", data, b, b2)
+		}
+	})
+}
+
+func FuzzParseTag(f *testing.F) {
+	for _, s := range []string{"84", "5F20", "BF0C", "DF8101", "1F80", "FF"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		tag, err := ParseTag(s)
+		if err != nil {
+			return
+		}
+		b := tag.Bytes()
+		parsed, n, err := parseTag(b)
+		if err != nil || n != len(b) || parsed != tag {
+			t.Fatalf("parseTag(%x) = %v, %d, %v; want %v", b, parsed, n, err, tag)
+		}
+		if again, err := ParseTag(tag.String()); err != nil || again != tag {
+			t.Fatalf("ParseTag(%q) = %v, %v; want %v", tag.String(), again, err, tag)
+		}
+	})
+}
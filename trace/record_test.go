@@ -0,0 +1,82 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package trace
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ebfe/scard"
+	"github.com/ebfe/scard/pcscdtest"
+	"github.com/ebfe/scard/sim"
+)
+
+// session is the code under test: it reads EF 0101 of the simulated card.
+func session(b scard.Backend) ([]byte, error) {
+	ctx, err := scard.EstablishContextWithBackend(b)
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Release()
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		return nil, err
+	}
+	card, err := ctx.Connect(readers[0], scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		return nil, err
+	}
+	defer card.Disconnect(scard.LeaveCard)
+
+	if _, err := card.Transmit([]byte{0x00, 0xa4, 0x02, 0x0c, 0x02, 0x01, 0x01}); err != nil {
+		return nil, err
+	}
+	return card.Transmit([]byte{0x00, 0xb0, 0x00, 0x00, 0x00})
+}
+
+func TestRecordReplay(t *testing.T) {
+	s, err := pcscdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	r, err := s.AddReader("Simulated Reader 00 00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := sim.New(nil)
+	card.MF().AddEF(0x0101, []byte("recorded"))
+	r.Insert(card)
+
+	var buf bytes.Buffer
+	rec := NewRecorder(scard.NewPCSCLiteBackend(s.Path()), &buf)
+	want, err := session(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("trace:\n%s", buf.Bytes())
+
+	// the card is gone, the trace answers instead
+	r.Eject()
+
+	p, err := NewReplayer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := session(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("replayed response % x; want % x", got, want)
+	}
+	if err := p.Done(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,371 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ebfe/scard"
+)
+
+// DivergenceError reports a call that does not match the trace.
+type DivergenceError struct {
+	// Index is the position of Want in the trace.
+	Index int
+	// Want is the next recorded event, or nil if the trace is exhausted.
+	Want *Event
+	// Got describes the call that was made.
+	Got Event
+}
+
+func (e *DivergenceError) Error() string {
+	if e.Want == nil {
+		return fmt.Sprintf("trace: unexpected %s after end of trace", describe(&e.Got))
+	}
+	return fmt.Sprintf("trace: event %d: got %s, want %s", e.Index, describe(&e.Got), describe(e.Want))
+}
+
+func describe(ev *Event) string {
+	switch ev.Op {
+	case OpTransmit:
+		return fmt.Sprintf("transmit(%q, %v, % x)", ev.Reader, ev.Protocol, []byte(ev.Command))
+	case OpControl:
+		return fmt.Sprintf("control(%q, %#x, % x)", ev.Reader, ev.Ioctl, []byte(ev.Command))
+	}
+	return fmt.Sprintf("%s(%q)", ev.Op, ev.Reader)
+}
+
+type replayCard struct {
+	reader   string
+	protocol scard.Protocol
+	atr      []byte
+}
+
+// Replayer is a scard.Backend that serves the responses of a trace. Calls
+// that are not recorded (e.g. transactions, disconnect) succeed without
+// checking.
+type Replayer struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	err    error
+	cards  map[uintptr]*replayCard
+	handle uintptr
+}
+
+// NewReplayer reads a trace written by a Recorder.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{cards: make(map[uintptr]*replayCard)}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("trace: event %d: %v", len(p.events), err)
+		}
+		p.events = append(p.events, ev)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Err returns the first divergence from the trace.
+func (p *Replayer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Done returns the first divergence, or an error if not all events of the
+// trace have been replayed.
+func (p *Replayer) Done() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	if p.next < len(p.events) {
+		return fmt.Errorf("trace: %d of %d events not replayed, next is %s", len(p.events)-p.next, len(p.events), describe(&p.events[p.next]))
+	}
+	return nil
+}
+
+// expect consumes the next event if it matches got. p.mu must be held.
+func (p *Replayer) expect(got *Event) (*Event, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if p.next >= len(p.events) {
+		p.err = &DivergenceError{Index: p.next, Got: *got}
+		return nil, p.err
+	}
+
+	want := &p.events[p.next]
+	if want.Op != got.Op || want.Reader != got.Reader || want.Ioctl != got.Ioctl || !bytes.Equal(want.Command, got.Command) ||
+		(got.Op == OpTransmit && want.Protocol != got.Protocol) {
+		p.err = &DivergenceError{Index: p.next, Want: want, Got: *got}
+		return nil, p.err
+	}
+	p.next++
+
+	return want, nil
+}
+
+func (p *Replayer) card(card uintptr) (*replayCard, error) {
+	c, ok := p.cards[card]
+	if !ok {
+		return nil, scard.ErrInvalidHandle
+	}
+	return c, nil
+}
+
+// readers returns the readers connected to in the trace.
+func (p *Replayer) readers() map[string]*Event {
+	readers := make(map[string]*Event)
+	for i := range p.events {
+		ev := &p.events[i]
+		if ev.Op == OpConnect && readers[ev.Reader] == nil {
+			readers[ev.Reader] = ev
+		}
+	}
+	return readers
+}
+
+func (p *Replayer) EstablishContext(scope scard.Scope) (uintptr, error) {
+	return 1, nil
+}
+
+func (p *Replayer) ReleaseContext(ctx uintptr) error {
+	return nil
+}
+
+func (p *Replayer) IsValidContext(ctx uintptr) error {
+	return nil
+}
+
+func (p *Replayer) Cancel(ctx uintptr) error {
+	return nil
+}
+
+// ListReaders returns the readers connected to in the trace, in order of
+// the first connect.
+func (p *Replayer) ListReaders(ctx uintptr, groups []string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, ev := range p.events {
+		if ev.Op == OpConnect && !seen[ev.Reader] {
+			seen[ev.Reader] = true
+			names = append(names, ev.Reader)
+		}
+	}
+	if len(names) == 0 {
+		return nil, scard.ErrNoReadersAvailable
+	}
+	return names, nil
+}
+
+func (p *Replayer) ListReaderGroups(ctx uintptr) ([]string, error) {
+	return []string{"SCard$DefaultReaders"}, nil
+}
+
+// GetStatusChange reports the readers of the trace with the card of their
+// first connect present. It never blocks: if nothing changed it fails with
+// scard.ErrTimeout.
+func (p *Replayer) GetStatusChange(ctx uintptr, timeout time.Duration, states []scard.ReaderState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	const mask = ^(scard.StateChanged | scard.StateIgnore)
+
+	readers := p.readers()
+	changed := false
+	for i := range states {
+		rs := &states[i]
+		if rs.CurrentState&scard.StateIgnore != 0 || rs.Reader == scard.PnPNotification {
+			rs.EventState = rs.CurrentState &^ scard.StateChanged
+			continue
+		}
+
+		ev, ok := readers[rs.Reader]
+		switch {
+		case !ok:
+			rs.EventState = scard.StateUnknown | scard.StateUnavailable
+			rs.Atr = nil
+		case ev.Err() != nil:
+			rs.EventState = scard.StateEmpty
+			rs.Atr = nil
+		default:
+			rs.EventState = scard.StatePresent
+			rs.Atr = append([]byte(nil), ev.ATR...)
+		}
+		if rs.EventState&mask != rs.CurrentState&mask {
+			rs.EventState |= scard.StateChanged
+			changed = true
+		}
+	}
+
+	if !changed {
+		return scard.ErrTimeout
+	}
+	return nil
+}
+
+func (p *Replayer) Connect(ctx uintptr, reader string, mode scard.ShareMode, proto scard.Protocol) (uintptr, scard.Protocol, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ev, err := p.expect(&Event{Op: OpConnect, Reader: reader})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := ev.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	p.handle++
+	p.cards[p.handle] = &replayCard{reader: reader, protocol: ev.Protocol, atr: ev.ATR}
+	return p.handle, ev.Protocol, nil
+}
+
+func (p *Replayer) Disconnect(card uintptr, d scard.Disposition) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.card(card); err != nil {
+		return err
+	}
+	delete(p.cards, card)
+	return nil
+}
+
+func (p *Replayer) Reconnect(card uintptr, mode scard.ShareMode, proto scard.Protocol, d scard.Disposition) (scard.Protocol, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, err := p.card(card)
+	if err != nil {
+		return 0, err
+	}
+	ev, err := p.expect(&Event{Op: OpReconnect, Reader: c.reader})
+	if err != nil {
+		return 0, err
+	}
+	if err := ev.Err(); err != nil {
+		return 0, err
+	}
+
+	c.protocol = ev.Protocol
+	c.atr = ev.ATR
+	return ev.Protocol, nil
+}
+
+func (p *Replayer) BeginTransaction(card uintptr) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.card(card)
+	return err
+}
+
+func (p *Replayer) EndTransaction(card uintptr, d scard.Disposition) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.card(card)
+	return err
+}
+
+func (p *Replayer) Status(card uintptr) (*scard.CardStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, err := p.card(card)
+	if err != nil {
+		return nil, err
+	}
+	return &scard.CardStatus{
+		Reader:         c.reader,
+		State:          scard.Present | scard.Powered | scard.Specific,
+		ActiveProtocol: c.protocol,
+		Atr:            append([]byte(nil), c.atr...),
+	}, nil
+}
+
+// replay copies the recorded response of ev to out.
+func replay(ev *Event, out []byte) (int, error) {
+	if err := ev.Err(); err != nil {
+		return 0, err
+	}
+	if len(ev.Response) > len(out) {
+		return 0, scard.ErrInsufficientBuffer
+	}
+	return copy(out, ev.Response), nil
+}
+
+func (p *Replayer) Transmit(card uintptr, proto scard.Protocol, cmd, rsp []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, err := p.card(card)
+	if err != nil {
+		return 0, err
+	}
+	ev, err := p.expect(&Event{Op: OpTransmit, Reader: c.reader, Protocol: proto, Command: cmd})
+	if err != nil {
+		return 0, err
+	}
+	return replay(ev, rsp)
+}
+
+func (p *Replayer) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, err := p.card(card)
+	if err != nil {
+		return 0, err
+	}
+	ev, err := p.expect(&Event{Op: OpControl, Reader: c.reader, Ioctl: ioctl, Command: in})
+	if err != nil {
+		return 0, err
+	}
+	return replay(ev, out)
+}
+
+// GetAttrib returns the ATR for scard.AttrAtrString and fails with
+// scard.ErrUnsupportedFeature for other attributes.
+func (p *Replayer) GetAttrib(card uintptr, id scard.Attrib) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, err := p.card(card)
+	if err != nil {
+		return nil, err
+	}
+	if id != scard.AttrAtrString {
+		return nil, scard.ErrUnsupportedFeature
+	}
+	return append([]byte(nil), c.atr...), nil
+}
+
+func (p *Replayer) SetAttrib(card uintptr, id scard.Attrib, data []byte) error {
+	return scard.ErrUnsupportedFeature
+}
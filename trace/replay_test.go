@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ebfe/scard"
+)
+
+const testTrace = `{"op":"connect","reader":"Reader 0","protocol":2,"atr":"3b8080010101","time":"2024-01-01T00:00:00Z","duration":1000}
+{"op":"transmit","reader":"Reader 0","protocol":2,"command":"00a4000c023f00","response":"9000","time":"2024-01-01T00:00:00Z","duration":1000}
+{"op":"transmit","reader":"Reader 0","protocol":2,"command":"00b0000000","code":2148532329,"time":"2024-01-01T00:00:00Z","duration":1000}
+{"op":"control","reader":"Reader 0","ioctl":1107299656,"response":"1204420033d4","time":"2024-01-01T00:00:00Z","duration":1000}
+`
+
+func replayTestTrace(t *testing.T) (*Replayer, *scard.Card) {
+	t.Helper()
+
+	p, err := NewReplayer(strings.NewReader(testTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := scard.EstablishContextWithBackend(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 || readers[0] != "Reader 0" {
+		t.Fatalf("ListReaders() = %q", readers)
+	}
+	card, err := ctx.Connect(readers[0], scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, card
+}
+
+func TestReplay(t *testing.T) {
+	p, card := replayTestTrace(t)
+
+	if card.ActiveProtocol() != scard.ProtocolT1 {
+		t.Errorf("ActiveProtocol() = %v", card.ActiveProtocol())
+	}
+	status, err := card.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(status.Atr, []byte{0x3b, 0x80, 0x80, 0x01, 0x01, 0x01}) {
+		t.Errorf("Atr = % x", status.Atr)
+	}
+
+	rsp, err := card.Transmit([]byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, []byte{0x90, 0x00}) {
+		t.Fatalf("Transmit() = % x", rsp)
+	}
+	if _, err := card.Transmit([]byte{0x00, 0xb0, 0x00, 0x00, 0x00}); !errors.Is(err, scard.ErrRemovedCard) {
+		t.Fatalf("Transmit() = %v; want %v", err, scard.ErrRemovedCard)
+	}
+
+	if err := p.Done(); err == nil {
+		t.Fatal("Done() = nil with events left")
+	}
+
+	out, err := card.Control(scard.CtlCode(3400), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte{0x12, 0x04, 0x42, 0x00, 0x33, 0xd4}) {
+		t.Fatalf("Control() = % x", out)
+	}
+
+	if err := p.Done(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	p, card := replayTestTrace(t)
+
+	_, err := card.Transmit([]byte{0x00, 0xa4, 0x04, 0x00})
+	var de *DivergenceError
+	if !errors.As(err, &de) {
+		t.Fatalf("Transmit() = %v; want *DivergenceError", err)
+	}
+	if de.Index != 1 || de.Want == nil || de.Want.Op != OpTransmit {
+		t.Fatalf("DivergenceError = %+v", de)
+	}
+
+	// the replay stays failed
+	if _, err := card.Transmit([]byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00}); !errors.Is(err, de) {
+		t.Fatalf("Transmit() = %v; want %v", err, de)
+	}
+	if err := p.Done(); err != de {
+		t.Fatalf("Done() = %v; want %v", err, de)
+	}
+}
+
+func TestReplayProtocolDivergence(t *testing.T) {
+	// connected with T=0, but the command was recorded with T=1
+	const trace = `{"op":"connect","reader":"Reader 0","protocol":1,"atr":"3b8080010101","time":"2024-01-01T00:00:00Z","duration":1000}
+{"op":"transmit","reader":"Reader 0","protocol":2,"command":"00a4000c023f00","response":"9000","time":"2024-01-01T00:00:00Z","duration":1000}
+`
+	p, err := NewReplayer(strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := scard.EstablishContextWithBackend(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect("Reader 0", scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = card.Transmit([]byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00})
+	var de *DivergenceError
+	if !errors.As(err, &de) {
+		t.Fatalf("Transmit() = %v; want *DivergenceError", err)
+	}
+	if de.Index != 1 || de.Want == nil || de.Want.Protocol != scard.ProtocolT1 || de.Got.Protocol != scard.ProtocolT0 {
+		t.Fatalf("DivergenceError = %+v", de)
+	}
+}
+
+func TestHex(t *testing.T) {
+	var h Hex
+	if err := h.UnmarshalText([]byte("00A4ff")); err != nil {
+		t.Fatal(err)
+	}
+	text, _ := h.MarshalText()
+	if string(text) != "00a4ff" {
+		t.Fatalf("MarshalText() = %s", text)
+	}
+	if err := h.UnmarshalText([]byte("0")); err == nil {
+		t.Fatal("UnmarshalText(odd length) = nil")
+	}
+}
@@ -0,0 +1,279 @@
+// Package trace records the card exchanges made through a scard.Backend and
+// replays them.
+//
+// A Recorder wraps another Backend and writes one JSON object per line for
+// every connect, reconnect, transmit and control call:
+//
+//	f, _ := os.Create("session.trace")
+//	ctx, err := scard.EstablishContextWithBackend(trace.NewRecorder(scard.DefaultBackend(), f))
+//
+// A Replayer reads such a trace and serves the recorded responses without
+// a reader. Any call that does not match the next recorded event fails
+// with a *DivergenceError:
+//
+//	p, err := trace.NewReplayer(f)
+//	ctx, err := scard.EstablishContextWithBackend(p)
+//	... run the code under test ...
+//	if err := p.Done(); err != nil {
+//		t.Fatal(err)
+//	}
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ebfe/scard"
+	"github.com/ebfe/scard/internal/hexbytes"
+)
+
+// Operations recorded in a trace.
+const (
+	OpConnect   = "connect"
+	OpReconnect = "reconnect"
+	OpTransmit  = "transmit"
+	OpControl   = "control"
+)
+
+// Hex is a byte slice that is marshalled as a hex string.
+type Hex = hexbytes.Bytes
+
+// Event is a recorded call.
+type Event struct {
+	Op     string `json:"op"`
+	Reader string `json:"reader,omitempty"`
+	// Protocol is the active protocol after connect and reconnect, and
+	// the protocol used by transmit.
+	Protocol scard.Protocol `json:"protocol,omitempty"`
+	// ATR is the ATR of the card after connect and reconnect.
+	ATR Hex `json:"atr,omitempty"`
+	// Ioctl is the control code of control.
+	Ioctl uint32 `json:"ioctl,omitempty"`
+	// Command and Response are the APDUs of transmit, or the input and
+	// output of control.
+	Command  Hex `json:"command,omitempty"`
+	Response Hex `json:"response,omitempty"`
+	// Code is set if the call failed with a scard.Error, otherwise Error
+	// holds the message of a failure.
+	Code  scard.Error `json:"code,omitempty"`
+	Error string      `json:"error,omitempty"`
+
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (e *Event) setErr(err error) {
+	if err == nil {
+		return
+	}
+	var code scard.Error
+	if errors.As(err, &code) {
+		e.Code = code
+	} else {
+		e.Error = err.Error()
+	}
+}
+
+// Err returns the error recorded for e.
+func (e *Event) Err() error {
+	switch {
+	case e.Code != 0:
+		return e.Code
+	case e.Error != "":
+		return errors.New(e.Error)
+	}
+	return nil
+}
+
+// Recorder is a scard.Backend that records the calls made to another
+// Backend.
+type Recorder struct {
+	b scard.Backend
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	err     error
+	readers map[uintptr]string
+}
+
+// NewRecorder returns a Recorder that passes all calls to b and writes the
+// trace to w.
+func NewRecorder(b scard.Backend, w io.Writer) *Recorder {
+	return &Recorder{
+		b:       b,
+		enc:     json.NewEncoder(w),
+		readers: make(map[uintptr]string),
+	}
+}
+
+// Err returns the first error that occurred writing the trace.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+func (r *Recorder) record(ev *Event, start time.Time, err error) {
+	ev.Time = start
+	ev.Duration = time.Since(start)
+	ev.setErr(err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(ev)
+	}
+}
+
+func (r *Recorder) reader(card uintptr) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.readers[card]
+}
+
+// atr returns the ATR of the card, ignoring errors.
+func (r *Recorder) atr(card uintptr) []byte {
+	status, err := r.b.Status(card)
+	if err != nil {
+		return nil
+	}
+	return status.Atr
+}
+
+func (r *Recorder) EstablishContext(scope scard.Scope) (uintptr, error) {
+	return r.b.EstablishContext(scope)
+}
+
+func (r *Recorder) ReleaseContext(ctx uintptr) error {
+	return r.b.ReleaseContext(ctx)
+}
+
+func (r *Recorder) IsValidContext(ctx uintptr) error {
+	return r.b.IsValidContext(ctx)
+}
+
+func (r *Recorder) Cancel(ctx uintptr) error {
+	return r.b.Cancel(ctx)
+}
+
+func (r *Recorder) ListReaders(ctx uintptr, groups []string) ([]string, error) {
+	return r.b.ListReaders(ctx, groups)
+}
+
+func (r *Recorder) ListReaderGroups(ctx uintptr) ([]string, error) {
+	return r.b.ListReaderGroups(ctx)
+}
+
+func (r *Recorder) GetStatusChange(ctx uintptr, timeout time.Duration, states []scard.ReaderState) error {
+	return r.b.GetStatusChange(ctx, timeout, states)
+}
+
+// MaxReaderStates returns the limit of the recorded backend if it is a
+// scard.ReaderStateLimiter, 0 otherwise.
+func (r *Recorder) MaxReaderStates() int {
+	if l, ok := r.b.(scard.ReaderStateLimiter); ok {
+		return l.MaxReaderStates()
+	}
+	return 0
+}
+
+func (r *Recorder) Connect(ctx uintptr, reader string, mode scard.ShareMode, proto scard.Protocol) (uintptr, scard.Protocol, error) {
+	start := time.Now()
+	card, active, err := r.b.Connect(ctx, reader, mode, proto)
+
+	ev := &Event{Op: OpConnect, Reader: reader, Protocol: active}
+	if err == nil {
+		ev.ATR = r.atr(card)
+		r.mu.Lock()
+		r.readers[card] = reader
+		r.mu.Unlock()
+	}
+	r.record(ev, start, err)
+
+	return card, active, err
+}
+
+func (r *Recorder) Disconnect(card uintptr, d scard.Disposition) error {
+	err := r.b.Disconnect(card, d)
+	if err == nil {
+		r.mu.Lock()
+		delete(r.readers, card)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+func (r *Recorder) Reconnect(card uintptr, mode scard.ShareMode, proto scard.Protocol, d scard.Disposition) (scard.Protocol, error) {
+	start := time.Now()
+	active, err := r.b.Reconnect(card, mode, proto, d)
+
+	ev := &Event{Op: OpReconnect, Reader: r.reader(card), Protocol: active}
+	if err == nil {
+		ev.ATR = r.atr(card)
+	}
+	r.record(ev, start, err)
+
+	return active, err
+}
+
+func (r *Recorder) BeginTransaction(card uintptr) error {
+	return r.b.BeginTransaction(card)
+}
+
+func (r *Recorder) EndTransaction(card uintptr, d scard.Disposition) error {
+	return r.b.EndTransaction(card, d)
+}
+
+func (r *Recorder) Status(card uintptr) (*scard.CardStatus, error) {
+	return r.b.Status(card)
+}
+
+func (r *Recorder) Transmit(card uintptr, proto scard.Protocol, cmd, rsp []byte) (int, error) {
+	start := time.Now()
+	n, err := r.b.Transmit(card, proto, cmd, rsp)
+
+	ev := &Event{
+		Op:       OpTransmit,
+		Reader:   r.reader(card),
+		Protocol: proto,
+		Command:  append(Hex(nil), cmd...),
+	}
+	if err == nil {
+		ev.Response = append(Hex(nil), rsp[:n]...)
+	}
+	r.record(ev, start, err)
+
+	return n, err
+}
+
+func (r *Recorder) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	start := time.Now()
+	n, err := r.b.Control(card, ioctl, in, out)
+
+	ev := &Event{
+		Op:      OpControl,
+		Reader:  r.reader(card),
+		Ioctl:   ioctl,
+		Command: append(Hex(nil), in...),
+	}
+	if err == nil {
+		ev.Response = append(Hex(nil), out[:n]...)
+	}
+	r.record(ev, start, err)
+
+	return n, err
+}
+
+func (r *Recorder) GetAttrib(card uintptr, id scard.Attrib) ([]byte, error) {
+	return r.b.GetAttrib(card, id)
+}
+
+func (r *Recorder) SetAttrib(card uintptr, id scard.Attrib, data []byte) error {
+	return r.b.SetAttrib(card, id, data)
+}
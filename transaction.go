@@ -0,0 +1,146 @@
+package scard
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// maxTransactionAttempts bounds how often WithTransaction runs fn when
+	// the card is reset.
+	maxTransactionAttempts = 3
+
+	transactionBackoffMin = 10 * time.Millisecond
+	transactionBackoffMax = 500 * time.Millisecond
+)
+
+// WithTransaction is like WithTransactionContext without a bound on the
+// wait for the transaction to start.
+func (card *Card) WithTransaction(disp Disposition, fn func(*Card) error) error {
+	return card.WithTransactionContext(context.Background(), disp, fn)
+}
+
+// WithTransactionContext starts a transaction, runs fn and ends the
+// transaction with disp when fn returns.
+//
+// If fn panics or exits the goroutine, the transaction is ended with
+// ResetCard, so that no state such as a verified PIN is left to other
+// applications. If the card was reset before the transaction started, or fn
+// fails with ErrResetCard, the card is reconnected with its share mode and
+// protocols and fn is run again from scratch, up to three times in total.
+// While the card is used exclusively by another application, starting the
+// transaction is retried with exponential backoff.
+//
+// c bounds the wait for the transaction to start, not the run of fn. If c
+// is done first, a transaction that is started later is ended again right
+// away. Until then the next BeginTransaction, EndTransaction, Reconnect or
+// Disconnect on card waits for the abandoned BeginTransaction to return.
+func (card *Card) WithTransactionContext(c context.Context, disp Disposition, fn func(*Card) error) error {
+	var err error
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		if attempt > 0 {
+			if err := card.Reconnect(card.mode, card.protocols, LeaveCard); err != nil {
+				return err
+			}
+		}
+
+		err = card.beginTransaction(c)
+		if errors.Is(err, ErrResetCard) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = card.runTransaction(disp, fn)
+		if !errors.Is(err, ErrResetCard) {
+			return err
+		}
+	}
+	return err
+}
+
+// runTransaction runs fn in the transaction started by the caller and ends
+// it.
+func (card *Card) runTransaction(disp Disposition, fn func(*Card) error) error {
+	ended := false
+	defer func() {
+		if !ended {
+			card.EndTransaction(ResetCard)
+		}
+	}()
+
+	err := fn(card)
+	ended = true
+	if endErr := card.EndTransaction(disp); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+// beginTransaction starts a transaction, giving up when c is done.
+func (card *Card) beginTransaction(c context.Context) error {
+	card.settle()
+	if c.Done() == nil {
+		return card.acquire(c)
+	}
+	if err := c.Err(); err != nil {
+		return card.opError("BeginTransaction", &contextError{err})
+	}
+
+	// BeginTransaction blocks while another handle holds a transaction and
+	// cannot be cancelled
+	res := make(chan error, 1)
+	go func() {
+		res <- card.acquire(c)
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-c.Done():
+		pending := make(chan struct{})
+		card.pending = pending
+		go func() {
+			if <-res == nil {
+				card.backend.EndTransaction(card.handle, LeaveCard)
+			}
+			close(pending)
+		}()
+		return card.opError("BeginTransaction", &contextError{c.Err()})
+	}
+}
+
+// settle waits for a BeginTransaction abandoned by beginTransaction to
+// return and for the transaction it started to end.
+func (card *Card) settle() {
+	if card.pending != nil {
+		<-card.pending
+		card.pending = nil
+	}
+}
+
+// acquire calls BeginTransaction, backing off while it fails with
+// ErrSharingViolation.
+func (card *Card) acquire(c context.Context) error {
+	backoff := transactionBackoffMin
+	for {
+		err := card.opError("BeginTransaction", card.backend.BeginTransaction(card.handle))
+		if !errors.Is(err, ErrSharingViolation) {
+			return err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-c.Done():
+			t.Stop()
+			return card.opError("BeginTransaction", &contextError{c.Err()})
+		}
+
+		if backoff *= 2; backoff > transactionBackoffMax {
+			backoff = transactionBackoffMax
+		}
+	}
+}
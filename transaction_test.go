@@ -0,0 +1,172 @@
+package scard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func transactionCard(t *testing.T, fake *fakeBackend) *Card {
+	t.Helper()
+
+	ctx, err := EstablishContextWithBackend(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ctx.Connect(fake.reader, ShareShared, ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.calls = nil
+	return card
+}
+
+func checkCalls(t *testing.T, fake *fakeBackend, want ...string) {
+	t.Helper()
+
+	if len(fake.calls) != len(want) {
+		t.Fatalf("calls = %q; want %q", fake.calls, want)
+	}
+	for i := range want {
+		if fake.calls[i] != want[i] {
+			t.Fatalf("calls = %q; want %q", fake.calls, want)
+		}
+	}
+}
+
+func TestWithTransaction(t *testing.T) {
+	fake := newFakeBackend()
+	card := transactionCard(t, fake)
+
+	errTest := errors.New("test")
+	if err := card.WithTransaction(LeaveCard, func(*Card) error { return errTest }); err != errTest {
+		t.Fatalf("WithTransaction() = %v; want %v", err, errTest)
+	}
+	if fake.disposition != LeaveCard {
+		t.Fatalf("disposition = %v; want %v", fake.disposition, LeaveCard)
+	}
+	checkCalls(t, fake, "BeginTransaction", "EndTransaction")
+
+	fake.calls = nil
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recover() = %v; want boom", r)
+			}
+		}()
+		card.WithTransaction(LeaveCard, func(*Card) error { panic("boom") })
+	}()
+	if fake.disposition != ResetCard {
+		t.Fatalf("disposition after panic = %v; want %v", fake.disposition, ResetCard)
+	}
+	checkCalls(t, fake, "BeginTransaction", "EndTransaction")
+}
+
+func TestWithTransactionReset(t *testing.T) {
+	fake := newFakeBackend()
+	card := transactionCard(t, fake)
+
+	runs := 0
+	err := card.WithTransaction(UnpowerCard, func(*Card) error {
+		if runs++; runs < 2 {
+			return ErrResetCard
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("runs = %d; want 2", runs)
+	}
+	if fake.disposition != UnpowerCard {
+		t.Fatalf("disposition = %v; want %v", fake.disposition, UnpowerCard)
+	}
+
+	fake.calls = nil
+	runs = 0
+	err = card.WithTransaction(LeaveCard, func(*Card) error {
+		runs++
+		return ErrResetCard
+	})
+	if !errors.Is(err, ErrResetCard) {
+		t.Fatalf("WithTransaction() = %v; want %v", err, ErrResetCard)
+	}
+	if runs != maxTransactionAttempts {
+		t.Fatalf("runs = %d; want %d", runs, maxTransactionAttempts)
+	}
+	checkCalls(t, fake,
+		"BeginTransaction", "EndTransaction", "Reconnect",
+		"BeginTransaction", "EndTransaction", "Reconnect",
+		"BeginTransaction", "EndTransaction")
+}
+
+func TestWithTransactionSharingViolation(t *testing.T) {
+	fake := newFakeBackend()
+	card := transactionCard(t, fake)
+
+	busy := 2
+	fake.begin = func() error {
+		if busy > 0 {
+			busy--
+			return ErrSharingViolation
+		}
+		return nil
+	}
+	if err := card.WithTransaction(LeaveCard, func(*Card) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, fake, "BeginTransaction", "BeginTransaction", "BeginTransaction", "EndTransaction")
+
+	fake = newFakeBackend()
+	card = transactionCard(t, fake)
+	fake.begin = func() error { return ErrSharingViolation }
+
+	c, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := card.WithTransactionContext(c, LeaveCard, func(*Card) error {
+		t.Error("fn called without transaction")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrCancelled) {
+		t.Fatalf("WithTransactionContext() = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWithTransactionAbandoned(t *testing.T) {
+	fake := newFakeBackend()
+	card := transactionCard(t, fake)
+
+	release := make(chan struct{})
+	fake.begin = func() error {
+		<-release
+		return nil
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := card.WithTransactionContext(c, LeaveCard, func(*Card) error {
+		t.Error("fn called without transaction")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WithTransactionContext() = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// Disconnect waits until the abandoned transaction has been ended
+	done := make(chan error)
+	go func() {
+		done <- card.Disconnect(LeaveCard)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Disconnect() returned before BeginTransaction")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, fake, "BeginTransaction", "EndTransaction", "Disconnect")
+}
@@ -0,0 +1,590 @@
+package vpcd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ebfe/scard"
+)
+
+// powerOnTimeout bounds the power on exchange with a newly connected vicc.
+var powerOnTimeout = 5 * time.Second
+
+// slot is a reader with at most one connected vicc.
+type slot struct {
+	name string
+	ln   net.Listener
+
+	// io serializes exchanges with conn.
+	io sync.Mutex
+
+	// The fields below are guarded by Backend.mu.
+	conn    net.Conn
+	pending net.Conn // vicc being powered on
+	atr     []byte
+	gen     uint64
+	events  uint32
+}
+
+type handle struct {
+	slot     *slot
+	gen      uint64
+	mode     scard.ShareMode
+	protocol scard.Protocol
+}
+
+// Backend is a scard.Backend that plays the part of vpcd: virtual ICCs
+// connect to it over TCP and appear as cards in its readers. No pcscd is
+// involved.
+//
+// Every reader accepts one vicc at a time; the card is present while the
+// vicc stays connected. Like in vpcd, a vicc that went away is only noticed
+// on the next exchange with it. Transactions are accepted but not enforced.
+type Backend struct {
+	slots []*slot
+
+	mu       sync.Mutex
+	closed   bool
+	changed  chan struct{}
+	cancel   map[uintptr]chan struct{}
+	handles  map[uintptr]*handle
+	nextCtx  uintptr
+	nextCard uintptr
+}
+
+// NewBackend returns a Backend with one reader per listener, named
+// "Virtual PCD 00 00", "Virtual PCD 00 01", ... like the readers of vpcd.
+func NewBackend(listeners ...net.Listener) *Backend {
+	b := &Backend{
+		changed: make(chan struct{}),
+		cancel:  make(map[uintptr]chan struct{}),
+		handles: make(map[uintptr]*handle),
+	}
+	for i, ln := range listeners {
+		s := &slot{name: fmt.Sprintf("Virtual PCD 00 %02d", i), ln: ln}
+		b.slots = append(b.slots, s)
+		go b.accept(s)
+	}
+	return b
+}
+
+// Listen returns a Backend with a single reader listening on addr. Use
+// Addr to find the port if addr has port 0.
+func Listen(addr string) (*Backend, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewBackend(ln), nil
+}
+
+// Addr returns the address of the first reader.
+func (b *Backend) Addr() net.Addr {
+	return b.slots[0].ln.Addr()
+}
+
+// Close stops listening and disconnects all viccs.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	var conns []net.Conn
+	for _, s := range b.slots {
+		if s.conn != nil {
+			conns = append(conns, s.conn)
+		}
+		if s.pending != nil {
+			conns = append(conns, s.pending)
+		}
+	}
+	b.mu.Unlock()
+
+	var err error
+	for _, s := range b.slots {
+		if e := s.ln.Close(); err == nil {
+			err = e
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	return err
+}
+
+// signal wakes up GetStatusChange. b.mu must be held.
+func (b *Backend) signal() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Backend) accept(s *slot) {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		busy := s.conn != nil || b.closed
+		if !busy {
+			s.pending = conn
+		}
+		b.mu.Unlock()
+		if busy {
+			conn.Close()
+			continue
+		}
+
+		// a vicc that does not answer must not block the slot
+		s.io.Lock()
+		conn.SetDeadline(time.Now().Add(powerOnTimeout))
+		atr, err := powerOn(conn)
+		if err == nil {
+			err = conn.SetDeadline(time.Time{})
+		}
+		s.io.Unlock()
+
+		b.mu.Lock()
+		s.pending = nil
+		if err != nil || b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conn = conn
+		s.atr = atr
+		s.gen++
+		s.events++
+		b.signal()
+		b.mu.Unlock()
+	}
+}
+
+// powerOn powers the card on and returns its ATR.
+func powerOn(conn net.Conn) ([]byte, error) {
+	if err := writeFrame(conn, []byte{msgPowerOn}); err != nil {
+		return nil, err
+	}
+	return getATR(conn)
+}
+
+func getATR(conn net.Conn) ([]byte, error) {
+	if err := writeFrame(conn, []byte{msgGetATR}); err != nil {
+		return nil, err
+	}
+	return readFrame(conn)
+}
+
+// drop forgets the vicc of s after an I/O error. b.mu must be held.
+func (b *Backend) drop(s *slot, conn net.Conn) {
+	conn.Close()
+	if s.conn != conn {
+		return
+	}
+	s.conn = nil
+	s.atr = nil
+	s.gen++
+	s.events++
+	b.signal()
+}
+
+// exchange sends msg to the vicc of h and returns the reply if wantReply
+// is set.
+func (b *Backend) exchange(h *handle, msg []byte, wantReply bool) ([]byte, error) {
+	s := h.slot
+
+	s.io.Lock()
+	defer s.io.Unlock()
+
+	b.mu.Lock()
+	conn := s.conn
+	if conn == nil || s.gen != h.gen {
+		b.mu.Unlock()
+		return nil, scard.ErrRemovedCard
+	}
+	b.mu.Unlock()
+
+	err := writeFrame(conn, msg)
+	var rsp []byte
+	if err == nil && wantReply {
+		rsp, err = readFrame(conn)
+	}
+	if err != nil {
+		b.mu.Lock()
+		b.drop(s, conn)
+		b.mu.Unlock()
+		return nil, scard.ErrRemovedCard
+	}
+	return rsp, nil
+}
+
+// dispose applies d to the card of h.
+func (b *Backend) dispose(h *handle, d scard.Disposition) error {
+	var msgs [][]byte
+	switch d {
+	case scard.LeaveCard:
+		return nil
+	case scard.ResetCard:
+		msgs = [][]byte{{msgReset}}
+	case scard.UnpowerCard, scard.EjectCard:
+		msgs = [][]byte{{msgPowerOff}, {msgPowerOn}}
+	default:
+		return scard.ErrInvalidValue
+	}
+	for _, msg := range msgs {
+		if _, err := b.exchange(h, msg, false); err != nil {
+			return err
+		}
+	}
+	atr, err := b.exchange(h, []byte{msgGetATR}, true)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	h.slot.atr = atr
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Backend) slot(name string) *slot {
+	for _, s := range b.slots {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (b *Backend) handle(card uintptr) (*handle, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h, ok := b.handles[card]
+	if !ok {
+		return nil, scard.ErrInvalidHandle
+	}
+	return h, nil
+}
+
+func (b *Backend) EstablishContext(scope scard.Scope) (uintptr, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextCtx++
+	b.cancel[b.nextCtx] = make(chan struct{})
+	return b.nextCtx, nil
+}
+
+func (b *Backend) ReleaseContext(ctx uintptr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.cancel[ctx]; !ok {
+		return scard.ErrInvalidHandle
+	}
+	delete(b.cancel, ctx)
+	return nil
+}
+
+func (b *Backend) IsValidContext(ctx uintptr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.cancel[ctx]; !ok {
+		return scard.ErrInvalidHandle
+	}
+	return nil
+}
+
+func (b *Backend) Cancel(ctx uintptr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.cancel[ctx]
+	if !ok {
+		return scard.ErrInvalidHandle
+	}
+	close(c)
+	b.cancel[ctx] = make(chan struct{})
+	return nil
+}
+
+func (b *Backend) ListReaders(ctx uintptr, groups []string) ([]string, error) {
+	if err := b.IsValidContext(ctx); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(b.slots))
+	for i, s := range b.slots {
+		names[i] = s.name
+	}
+	if len(names) == 0 {
+		return nil, scard.ErrNoReadersAvailable
+	}
+	return names, nil
+}
+
+func (b *Backend) ListReaderGroups(ctx uintptr) ([]string, error) {
+	if err := b.IsValidContext(ctx); err != nil {
+		return nil, err
+	}
+	return []string{"SCard$DefaultReaders"}, nil
+}
+
+// updateStates sets the event state of states and reports whether any of
+// them changed. b.mu must be held.
+func (b *Backend) updateStates(states []scard.ReaderState) bool {
+	const mask = ^(scard.StateChanged | scard.StateAtrmatch | 0xffff0000)
+
+	changed := false
+	for i := range states {
+		rs := &states[i]
+		if rs.CurrentState&scard.StateIgnore != 0 || rs.Reader == scard.PnPNotification {
+			rs.EventState = rs.CurrentState &^ scard.StateChanged
+			continue
+		}
+
+		s := b.slot(rs.Reader)
+		switch {
+		case s == nil:
+			rs.EventState = scard.StateUnknown | scard.StateUnavailable
+			rs.Atr = nil
+		case s.conn == nil:
+			rs.EventState = scard.StateEmpty | scard.StateFlag(s.events)<<16
+			rs.Atr = nil
+		default:
+			rs.EventState = scard.StatePresent | scard.StateFlag(s.events)<<16
+			rs.Atr = append([]byte(nil), s.atr...)
+			for _, h := range b.handles {
+				if h.slot == s {
+					if h.mode == scard.ShareExclusive {
+						rs.EventState |= scard.StateExclusive
+					} else {
+						rs.EventState |= scard.StateInuse
+					}
+					break
+				}
+			}
+		}
+
+		if rs.EventState&mask != rs.CurrentState&mask ||
+			(rs.CurrentState>>16 != 0 && rs.EventState>>16 != rs.CurrentState>>16) {
+			rs.EventState |= scard.StateChanged
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (b *Backend) GetStatusChange(ctx uintptr, timeout time.Duration, states []scard.ReaderState) error {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for {
+		b.mu.Lock()
+		cancel, ok := b.cancel[ctx]
+		if !ok {
+			b.mu.Unlock()
+			return scard.ErrInvalidHandle
+		}
+		changed := b.updateStates(states)
+		wait := b.changed
+		b.mu.Unlock()
+
+		if changed {
+			return nil
+		}
+
+		select {
+		case <-wait:
+		case <-cancel:
+			return scard.ErrCancelled
+		case <-expired:
+			return scard.ErrTimeout
+		}
+	}
+}
+
+func (b *Backend) Connect(ctx uintptr, reader string, mode scard.ShareMode, proto scard.Protocol) (uintptr, scard.Protocol, error) {
+	if err := b.IsValidContext(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	s := b.slot(reader)
+	if s == nil {
+		return 0, 0, scard.ErrUnknownReader
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := &handle{slot: s, gen: s.gen, mode: mode}
+	if mode != scard.ShareDirect {
+		if s.conn == nil {
+			return 0, 0, scard.ErrNoSmartcard
+		}
+		switch {
+		case proto&scard.ProtocolT1 != 0:
+			h.protocol = scard.ProtocolT1
+		case proto&scard.ProtocolT0 != 0:
+			h.protocol = scard.ProtocolT0
+		default:
+			return 0, 0, scard.ErrProtoMismatch
+		}
+	}
+	if b.sharingViolation(s, nil, mode) {
+		return 0, 0, scard.ErrSharingViolation
+	}
+
+	b.nextCard++
+	b.handles[b.nextCard] = h
+	b.signal()
+	return b.nextCard, h.protocol, nil
+}
+
+// sharingViolation reports whether a handle to s other than self conflicts
+// with sharing mode. b.mu must be held.
+func (b *Backend) sharingViolation(s *slot, self *handle, mode scard.ShareMode) bool {
+	for _, o := range b.handles {
+		if o != self && o.slot == s && (mode == scard.ShareExclusive || o.mode == scard.ShareExclusive) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backend) Disconnect(card uintptr, d scard.Disposition) error {
+	h, err := b.handle(card)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	delete(b.handles, card)
+	b.signal()
+	b.mu.Unlock()
+
+	if err := b.dispose(h, d); err != nil && err != scard.ErrRemovedCard {
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) Reconnect(card uintptr, mode scard.ShareMode, proto scard.Protocol, d scard.Disposition) (scard.Protocol, error) {
+	h, err := b.handle(card)
+	if err != nil {
+		return 0, err
+	}
+
+	b.mu.Lock()
+	if h.slot.conn == nil {
+		b.mu.Unlock()
+		return 0, scard.ErrNoSmartcard
+	}
+	if b.sharingViolation(h.slot, h, mode) {
+		b.mu.Unlock()
+		return 0, scard.ErrSharingViolation
+	}
+	h.gen = h.slot.gen
+	h.mode = mode
+	switch {
+	case proto&scard.ProtocolT1 != 0:
+		h.protocol = scard.ProtocolT1
+	case proto&scard.ProtocolT0 != 0:
+		h.protocol = scard.ProtocolT0
+	}
+	b.mu.Unlock()
+
+	if err := b.dispose(h, d); err != nil {
+		return 0, err
+	}
+	return h.protocol, nil
+}
+
+func (b *Backend) BeginTransaction(card uintptr) error {
+	_, err := b.handle(card)
+	return err
+}
+
+func (b *Backend) EndTransaction(card uintptr, d scard.Disposition) error {
+	h, err := b.handle(card)
+	if err != nil {
+		return err
+	}
+	return b.dispose(h, d)
+}
+
+func (b *Backend) Status(card uintptr) (*scard.CardStatus, error) {
+	h, err := b.handle(card)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if h.slot.conn == nil || h.slot.gen != h.gen {
+		return nil, scard.ErrRemovedCard
+	}
+	return &scard.CardStatus{
+		Reader:         h.slot.name,
+		State:          scard.Present | scard.Powered | scard.Specific,
+		ActiveProtocol: h.protocol,
+		Atr:            append([]byte(nil), h.slot.atr...),
+	}, nil
+}
+
+func (b *Backend) Transmit(card uintptr, proto scard.Protocol, cmd, rsp []byte) (int, error) {
+	h, err := b.handle(card)
+	if err != nil {
+		return 0, err
+	}
+	if h.protocol == 0 || proto != h.protocol {
+		return 0, scard.ErrProtoMismatch
+	}
+	if len(cmd) < 4 {
+		return 0, scard.ErrInvalidParameter
+	}
+
+	out, err := b.exchange(h, cmd, true)
+	if err != nil {
+		return 0, err
+	}
+	if len(out) > len(rsp) {
+		return 0, scard.ErrInsufficientBuffer
+	}
+	return copy(rsp, out), nil
+}
+
+func (b *Backend) Control(card uintptr, ioctl uint32, in, out []byte) (int, error) {
+	if _, err := b.handle(card); err != nil {
+		return 0, err
+	}
+	return 0, scard.ErrUnsupportedFeature
+}
+
+func (b *Backend) GetAttrib(card uintptr, id scard.Attrib) ([]byte, error) {
+	status, err := b.Status(card)
+	if err != nil {
+		return nil, err
+	}
+	switch id {
+	case scard.AttrAtrString:
+		return status.Atr, nil
+	case scard.AttrDeviceFriendlyName:
+		return append([]byte(status.Reader), 0), nil
+	}
+	return nil, scard.ErrUnsupportedFeature
+}
+
+func (b *Backend) SetAttrib(card uintptr, id scard.Attrib, data []byte) error {
+	if _, err := b.handle(card); err != nil {
+		return err
+	}
+	return scard.ErrUnsupportedFeature
+}
@@ -0,0 +1,77 @@
+package vpcd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Card is a virtual ICC. sim.Card implements it.
+type Card interface {
+	// ATR returns the answer to reset.
+	ATR() []byte
+	// Transmit processes a command APDU and returns the response APDU.
+	Transmit(cmd []byte) ([]byte, error)
+}
+
+// Resetter is implemented by cards that want to be notified when vpcd
+// powers them on or off or resets them.
+type Resetter interface {
+	Reset()
+}
+
+// Serve answers the requests of vpcd read from conn with card until conn
+// is closed. It returns nil if vpcd closed the connection.
+func Serve(conn io.ReadWriter, card Card) error {
+	for {
+		msg, err := readFrame(conn)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var rsp []byte
+		switch {
+		case len(msg) == 1:
+			switch msg[0] {
+			case msgPowerOff, msgPowerOn, msgReset:
+				if r, ok := card.(Resetter); ok {
+					r.Reset()
+				}
+				continue
+			case msgGetATR:
+				rsp = card.ATR()
+			default:
+				return fmt.Errorf("vpcd: unknown control message %#x", msg[0])
+			}
+		default:
+			rsp, err = card.Transmit(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := writeFrame(conn, rsp); err != nil {
+			return err
+		}
+	}
+}
+
+// Dial connects to vpcd at addr and serves card until the connection is
+// closed.
+func Dial(addr string, card Card) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = Serve(conn, card)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
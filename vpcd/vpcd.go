@@ -0,0 +1,67 @@
+// Package vpcd implements the protocol of the vsmartcard virtual smart card
+// reader driver (vpcd).
+//
+// vpcd is a pcscd reader driver that exposes each of its readers on a TCP
+// port (35963 for the first). A virtual ICC (vicc) connects to the port and
+// appears as a card in the reader. Serve and Dial implement the vicc side,
+// so a Go-defined card can be plugged into any pcscd running vpcd and used
+// through Context.Connect and Card.Transmit:
+//
+//	go vpcd.Dial(vpcd.DefaultAddr, sim.New(nil))
+//
+// Backend implements the vpcd side directly as a scard.Backend, for tests
+// that talk to virtual ICCs without pcscd.
+//
+// All messages are framed with a two byte big-endian length. vpcd sends a
+// single control byte (power off, power on, reset, get ATR) or a command
+// APDU; the vicc answers get ATR with the ATR and command APDUs with the
+// response APDU.
+package vpcd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DefaultAddr is the address vpcd listens on for the first reader.
+const DefaultAddr = "localhost:35963"
+
+// Control messages sent by vpcd.
+const (
+	msgPowerOff = 0x00
+	msgPowerOn  = 0x01
+	msgReset    = 0x02
+	msgGetATR   = 0x04
+)
+
+// maxFrame is the largest message that fits the length prefix.
+const maxFrame = 0xffff
+
+var errFrameTooLarge = errors.New("vpcd: message too large")
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeFrame(w io.Writer, b []byte) error {
+	if len(b) > maxFrame {
+		return errFrameTooLarge
+	}
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,276 @@
+package vpcd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ebfe/scard"
+	"github.com/ebfe/scard/sim"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, []byte{0x00, 0xa4, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x00, 0x04, 0x00, 0xa4, 0x00, 0x00}; !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("writeFrame() wrote % x; want % x", buf.Bytes(), want)
+	}
+	msg, err := readFrame(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, []byte{0x00, 0xa4, 0x00, 0x00}) {
+		t.Fatalf("readFrame() = % x", msg)
+	}
+	if _, err := readFrame(&buf); err != io.EOF {
+		t.Fatalf("readFrame() = %v; want %v", err, io.EOF)
+	}
+	if _, err := readFrame(bytes.NewReader([]byte{0x00, 0x02, 0x01})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("readFrame() = %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+	if err := writeFrame(io.Discard, make([]byte, maxFrame+1)); err != errFrameTooLarge {
+		t.Fatalf("writeFrame() = %v; want %v", err, errFrameTooLarge)
+	}
+}
+
+// resetCounter counts the power and reset messages seen by a card.
+type resetCounter struct {
+	*sim.Card
+	resets chan struct{}
+}
+
+func (c *resetCounter) Reset() {
+	c.Card.Reset()
+	c.resets <- struct{}{}
+}
+
+func TestServe(t *testing.T) {
+	vpcd, vicc := net.Pipe()
+	defer vpcd.Close()
+
+	card := &resetCounter{Card: sim.New(nil), resets: make(chan struct{}, 10)}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(vicc, card)
+	}()
+
+	if err := writeFrame(vpcd, []byte{msgPowerOn}); err != nil {
+		t.Fatal(err)
+	}
+	<-card.resets
+
+	atr, err := getATR(vpcd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(atr, sim.DefaultATR) {
+		t.Fatalf("ATR = % x", atr)
+	}
+
+	if err := writeFrame(vpcd, []byte{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x3f, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := readFrame(vpcd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, []byte{0x90, 0x00}) {
+		t.Fatalf("response % x", rsp)
+	}
+
+	vpcd.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	b, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ctx, err := scard.EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 || readers[0] != "Virtual PCD 00 00" {
+		t.Fatalf("ListReaders() = %q", readers)
+	}
+	if _, err := ctx.Connect(readers[0], scard.ShareShared, scard.ProtocolAny); !errors.Is(err, scard.ErrNoSmartcard) {
+		t.Fatalf("Connect() = %v; want %v", err, scard.ErrNoSmartcard)
+	}
+
+	rs := []scard.ReaderState{{Reader: readers[0], CurrentState: scard.StateUnaware}}
+	if err := ctx.GetStatusChange(rs, 0); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StateEmpty == 0 {
+		t.Fatalf("EventState = %v; want StateEmpty", rs[0].EventState)
+	}
+	rs[0].CurrentState = rs[0].EventState
+
+	vc := sim.New(nil)
+	vc.MF().AddEF(0x0101, []byte("vicc"))
+	conn, err := net.Dial("tcp", b.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(conn, vc)
+	}()
+
+	if err := ctx.GetStatusChange(rs, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StatePresent == 0 || !bytes.Equal(rs[0].Atr, sim.DefaultATR) {
+		t.Fatalf("EventState = %v, Atr = % x", rs[0].EventState, rs[0].Atr)
+	}
+
+	card, err := ctx.Connect(readers[0], scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.ActiveProtocol() != scard.ProtocolT1 {
+		t.Errorf("ActiveProtocol() = %v", card.ActiveProtocol())
+	}
+
+	for _, cmd := range [][]byte{
+		{0x00, 0xa4, 0x00, 0x0c, 0x02, 0x01, 0x01},
+		{0x00, 0xb0, 0x00, 0x00, 0x00},
+	} {
+		rsp, err := card.Transmit(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsp[len(rsp)-2] != 0x90 {
+			t.Fatalf("Transmit(% x) = % x", cmd, rsp)
+		}
+	}
+
+	// the reset deselects the EF
+	if err := card.Reconnect(scard.ShareShared, scard.ProtocolAny, scard.ResetCard); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := card.Transmit([]byte{0x00, 0xb0, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, []byte{0x69, 0x86}) {
+		t.Fatalf("Transmit() after reset = % x", rsp)
+	}
+
+	conn.Close()
+	<-served
+	if _, err := card.Transmit([]byte{0x00, 0xb0, 0x00, 0x00, 0x00}); !errors.Is(err, scard.ErrRemovedCard) {
+		t.Fatalf("Transmit() = %v; want %v", err, scard.ErrRemovedCard)
+	}
+	if err := card.Disconnect(scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackendSilentVICC(t *testing.T) {
+	defer func(d time.Duration) { powerOnTimeout = d }(powerOnTimeout)
+	powerOnTimeout = 50 * time.Millisecond
+
+	b, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// connects but never answers the power on
+	silent, err := net.Dial("tcp", b.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	conn, err := net.Dial("tcp", b.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go Serve(conn, sim.New(nil))
+
+	ctx, err := scard.EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	rs := []scard.ReaderState{{Reader: "Virtual PCD 00 00", CurrentState: scard.StateEmpty}}
+	if err := ctx.GetStatusChange(rs, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if rs[0].EventState&scard.StatePresent == 0 {
+		t.Fatalf("EventState = %v; want StatePresent", rs[0].EventState)
+	}
+}
+
+func TestBackendSharing(t *testing.T) {
+	b, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	conn, err := net.Dial("tcp", b.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go Serve(conn, sim.New(nil))
+
+	ctx, err := scard.EstablishContextWithBackend(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+
+	rs := []scard.ReaderState{{Reader: "Virtual PCD 00 00", CurrentState: scard.StateEmpty}}
+	if err := ctx.GetStatusChange(rs, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	c1, err := ctx.Connect(rs[0].Reader, scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ctx.Connect(rs[0].Reader, scard.ShareShared, scard.ProtocolAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.Connect(rs[0].Reader, scard.ShareExclusive, scard.ProtocolAny); !errors.Is(err, scard.ErrSharingViolation) {
+		t.Fatalf("Connect(ShareExclusive) = %v; want %v", err, scard.ErrSharingViolation)
+	}
+	if err := c1.Reconnect(scard.ShareExclusive, scard.ProtocolAny, scard.LeaveCard); !errors.Is(err, scard.ErrSharingViolation) {
+		t.Fatalf("Reconnect(ShareExclusive) = %v; want %v", err, scard.ErrSharingViolation)
+	}
+
+	if err := c2.Disconnect(scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.Reconnect(scard.ShareExclusive, scard.ProtocolAny, scard.LeaveCard); err != nil {
+		t.Fatalf("Reconnect(ShareExclusive) = %v", err)
+	}
+	if _, err := ctx.Connect(rs[0].Reader, scard.ShareShared, scard.ProtocolAny); !errors.Is(err, scard.ErrSharingViolation) {
+		t.Fatalf("Connect(ShareShared) = %v; want %v", err, scard.ErrSharingViolation)
+	}
+	if err := c1.Disconnect(scard.LeaveCard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -121,6 +121,8 @@ const (
 	ProtocolUndefined Protocol = 0x0
 	ProtocolT0        Protocol = 0x1
 	ProtocolT1        Protocol = 0x2
+	ProtocolRaw       Protocol = 0x4
+	ProtocolT15       Protocol = 0x8
 	ProtocolAny       Protocol = ProtocolT0 | ProtocolT1
 )
 